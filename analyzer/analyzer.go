@@ -9,6 +9,16 @@ import (
 )
 
 func AnalyzeQueries(queries []string, schemas []domains.TableSchema) (domains.CachePlan, error) {
+	extracted := make([]domains.ExtractedQuery, 0, len(queries))
+	for _, query := range queries {
+		extracted = append(extracted, domains.ExtractedQuery{Query: query})
+	}
+	return AnalyzeExtractedQueries(extracted, schemas)
+}
+
+// AnalyzeExtractedQueries is like AnalyzeQueries, but records the locations of the queries in the plan
+// and prefixes the warnings with them. The locations of the same query are merged into one plan entry.
+func AnalyzeExtractedQueries(queries []domains.ExtractedQuery, schemas []domains.TableSchema) (domains.CachePlan, error) {
 	a := newAnalyzer(schemas)
 	return a.analyzeQueries(queries)
 }
@@ -40,17 +50,24 @@ func newAnalyzer(schemas []domains.TableSchema) *analyzer {
 	return &analyzer{schemas}
 }
 
-func (a *analyzer) analyzeQueries(queries []string) (domains.CachePlan, error) {
+func (a *analyzer) analyzeQueries(queries []domains.ExtractedQuery) (domains.CachePlan, error) {
 	plan := domains.CachePlan{}
 	planErr := &analyzerError{}
-	for _, query := range queries {
+	analyzedQueries := make(map[string]*domains.CachePlanQuery)
+	for _, extracted := range queries {
+		var sources []domains.QuerySource
+		location := ""
+		if extracted.File != "" {
+			sources = []domains.QuerySource{extracted.QuerySource}
+			location = extracted.QuerySource.String() + ": "
+		}
 		week := false
-		query = normalizer.NormalizeQuery(query)
+		query := normalizer.NormalizeQuery(extracted.Query)
 		parsed, err := sql_parser.ParseSQL(query)
 		if err != nil {
 			weekParsed, weekErr := sql_parser.ParseSQLWeekly(query, a.schemas)
 			if weekErr != nil {
-				planErr.errors = append(planErr.errors, fmt.Errorf("failed to parse query:\nmain -> %s\nweek -> %s", err, weekErr))
+				planErr.errors = append(planErr.errors, fmt.Errorf("%sfailed to parse query:\nmain -> %s\nweek -> %s", location, err, weekErr))
 				continue
 			}
 			parsed = weekParsed
@@ -58,7 +75,7 @@ func (a *analyzer) analyzeQueries(queries []string) (domains.CachePlan, error) {
 		}
 		analyzed, err := a.analyzeQuery(parsed)
 		if err != nil {
-			planErr.errors = append(planErr.errors, fmt.Errorf("failed to analyze query: %s", err))
+			planErr.errors = append(planErr.errors, fmt.Errorf("%sfailed to analyze query: %s", location, err))
 			continue
 		}
 		if week {
@@ -67,8 +84,16 @@ func (a *analyzer) analyzeQueries(queries []string) (domains.CachePlan, error) {
 		if !week {
 			err = a.normalizeArgs(query, &analyzed)
 			if err != nil {
-				planErr.errors = append(planErr.errors, fmt.Errorf("failed to normalize args: %s", err))
+				planErr.errors = append(planErr.errors, fmt.Errorf("%sfailed to normalize args: %s", location, err))
+			}
+		}
+		if sources != nil {
+			if existing, ok := analyzedQueries[analyzed.Query]; ok {
+				existing.Sources = append(existing.Sources, sources...)
+				continue
 			}
+			analyzed.Sources = sources
+			analyzedQueries[analyzed.Query] = &analyzed
 		}
 		plan.Queries = append(plan.Queries, &analyzed)
 	}
@@ -100,11 +125,19 @@ func (a *analyzer) normalizeArgs(sql string, queryPlan *domains.CachePlanQuery)
 	switch queryPlan.Type {
 	case domains.CachePlanQueryType_SELECT:
 		for i, arg := range result.ExtraArgs {
-			queryPlan.Select.Conditions = append(queryPlan.Select.Conditions, domains.CachePlanCondition{
-				Column:      arg.Column,
-				Operator:    domains.CachePlanOperator_EQ,
-				Placeholder: domains.CachePlanPlaceholder{Index: i, Extra: true},
-			})
+			placeholder := domains.CachePlanPlaceholder{Index: i, Extra: true}
+			switch arg.Column {
+			case "LIMIT()":
+				queryPlan.Select.Limit = &placeholder
+			case "OFFSET()":
+				queryPlan.Select.Offset = &placeholder
+			default:
+				queryPlan.Select.Conditions = append(queryPlan.Select.Conditions, domains.CachePlanCondition{
+					Column:      arg.Column,
+					Operator:    domains.CachePlanOperator_EQ,
+					Placeholder: placeholder,
+				})
+			}
 		}
 	case domains.CachePlanQueryType_UPDATE:
 		for i, set := range result.ExtraSets {
@@ -91,16 +91,15 @@ func TestAnalyzeQueries(t *testing.T) {
 							Type:  domains.CachePlanQueryType_SELECT,
 						},
 						Select: &domains.CachePlanSelectQuery{
-							Cache:   true,
-							Table:   "users",
-							Targets: []string{"created_at", "id", "name", "username"},
-							Conditions: []domains.CachePlanCondition{
-								{Column: "OFFSET()", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 0}},
-								{Column: "LIMIT()", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 0, Extra: true}},
-							},
+							Cache:      true,
+							Table:      "users",
+							Targets:    []string{"created_at", "id", "name", "username"},
+							Conditions: []domains.CachePlanCondition{},
 							Orders: []domains.CachePlanOrder{
 								{Column: "created_at", Order: domains.CachePlanOrder_DESC},
 							},
+							Limit:  &domains.CachePlanPlaceholder{Index: 0, Extra: true},
+							Offset: &domains.CachePlanPlaceholder{Index: 0},
 						},
 					},
 					{
@@ -488,11 +487,11 @@ func TestAnalyzeQueries(t *testing.T) {
 							Targets: []string{"comment", "created_at", "id", "post_id", "user_id"},
 							Conditions: []domains.CachePlanCondition{
 								{Column: "post_id", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 0}},
-								{Column: "LIMIT()", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 0, Extra: true}},
 							},
 							Orders: []domains.CachePlanOrder{
 								{Column: "created_at", Order: domains.CachePlanOrder_DESC},
 							},
+							Limit: &domains.CachePlanPlaceholder{Index: 0, Extra: true},
 						},
 					},
 					{
@@ -513,6 +512,57 @@ func TestAnalyzeQueries(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "named placeholders",
+			queries: []string{
+				"SELECT * FROM `users` WHERE `id` IN (:ids)",
+				"UPDATE `users` SET `name` = :name WHERE `id` = :id",
+			},
+			schemas: []domains.TableSchema{
+				{
+					TableName: "users",
+					Columns: map[string]domains.TableSchemaColumn{
+						"id":   {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsNullable: false, IsPrimary: true, IsUnique: false},
+						"name": {ColumnName: "name", DataType: domains.TableSchemaDataType_STRING, IsNullable: false, IsPrimary: false, IsUnique: false},
+					},
+				},
+			},
+			expected: domains.CachePlan{
+				Queries: []*domains.CachePlanQuery{
+					{
+						CachePlanQueryBase: &domains.CachePlanQueryBase{
+							Query: "SELECT * FROM users WHERE id IN (:ids);",
+							Type:  domains.CachePlanQueryType_SELECT,
+						},
+						Select: &domains.CachePlanSelectQuery{
+							Cache:   true,
+							Table:   "users",
+							Targets: []string{"id", "name"},
+							Conditions: []domains.CachePlanCondition{
+								{Column: "id", Operator: domains.CachePlanOperator_IN, Placeholder: domains.CachePlanPlaceholder{Index: 0, Name: "ids"}},
+							},
+							Orders: []domains.CachePlanOrder{},
+						},
+					},
+					{
+						CachePlanQueryBase: &domains.CachePlanQueryBase{
+							Query: "UPDATE users SET name = :name WHERE id = :id;",
+							Type:  domains.CachePlanQueryType_UPDATE,
+						},
+						Update: &domains.CachePlanUpdateQuery{
+							Table: "users",
+							Targets: []domains.CachePlanUpdateTarget{
+								{Column: "name", Placeholder: domains.CachePlanPlaceholder{Index: 0, Name: "name"}},
+							},
+							Conditions: []domains.CachePlanCondition{
+								{Column: "id", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 1, Name: "id"}},
+							},
+							Orders: []domains.CachePlanOrder{},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -524,3 +574,29 @@ func TestAnalyzeQueries(t *testing.T) {
 		})
 	}
 }
+
+func TestAnalyzeExtractedQueries(t *testing.T) {
+	schemas := []domains.TableSchema{
+		{
+			TableName: "users",
+			Columns: map[string]domains.TableSchemaColumn{
+				"id":   {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsNullable: false, IsPrimary: true, IsUnique: false},
+				"name": {ColumnName: "name", DataType: domains.TableSchemaDataType_STRING, IsNullable: false, IsPrimary: false, IsUnique: false},
+			},
+		},
+	}
+	getUser := domains.QuerySource{File: "main.go", Line: 10, Column: 9, Function: "getUser"}
+	getMe := domains.QuerySource{File: "handler.go", Line: 24, Column: 12, Function: "(*Handler).getMe"}
+	broken := domains.QuerySource{File: "main.go", Line: 30, Column: 9, Function: "broken"}
+
+	actual, err := AnalyzeExtractedQueries([]domains.ExtractedQuery{
+		{QuerySource: getUser, Query: "SELECT * FROM `users` WHERE `id` = ?;"},
+		{QuerySource: getMe, Query: "SELECT * FROM users WHERE id = ?;"},
+		{QuerySource: broken, Query: "SELECT * FROM `unknown` WHERE `id` = ?;"},
+	}, schemas)
+	assert.ErrorContains(t, err, "main.go:30:9 in broken: ")
+
+	assert.Len(t, actual.Queries, 1)
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?;", actual.Queries[0].Query)
+	assert.Equal(t, []domains.QuerySource{getUser, getMe}, actual.Queries[0].Sources)
+}
@@ -0,0 +1,383 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/traP-jp/isuc/domains"
+	"github.com/traP-jp/isuc/normalizer"
+	"github.com/traP-jp/isuc/sql_parser"
+)
+
+type InvalidationKind string
+
+const (
+	// InvalidationKind_FORGET means only the entries keyed by the written row, or holding it in the rows shared by primary key, are dropped
+	InvalidationKind_FORGET InvalidationKind = "forget"
+	// InvalidationKind_PURGE means the whole cache is dropped
+	InvalidationKind_PURGE InvalidationKind = "purge"
+	// InvalidationKind_UPDATE means the cached rows are rewritten with the written values
+	InvalidationKind_UPDATE InvalidationKind = "update"
+)
+
+type InvalidationGraph struct {
+	Reads  []string            `json:"reads"`
+	Writes []InvalidationWrite `json:"writes"`
+}
+
+type InvalidationWrite struct {
+	Query       string                     `json:"query"`
+	Type        domains.CachePlanQueryType `json:"type"`
+	Table       string                     `json:"table"`
+	Invalidates []InvalidationEdge         `json:"invalidates"`
+}
+
+type InvalidationEdge struct {
+	Query string           `json:"query"`
+	Kind  InvalidationKind `json:"kind"`
+}
+
+// AnalyzeInvalidations computes which select caches are forgotten or purged by each write query in the plan.
+// The rules mirror handleInsertQuery, handleUpdateQuery and handleDeleteQuery of the generated driver.
+func AnalyzeInvalidations(plan *domains.CachePlan, schemas []domains.TableSchema) InvalidationGraph {
+	g := newGraphAnalyzer(plan, schemas)
+	return g.analyze()
+}
+
+type graphAnalyzer struct {
+	plan    *domains.CachePlan
+	schemas map[string]domains.TableSchema
+	// table name -> cached select queries
+	readsByTable map[string][]*domains.CachePlanQuery
+}
+
+func newGraphAnalyzer(plan *domains.CachePlan, schemas []domains.TableSchema) *graphAnalyzer {
+	g := &graphAnalyzer{
+		plan:         plan,
+		schemas:      make(map[string]domains.TableSchema, len(schemas)),
+		readsByTable: make(map[string][]*domains.CachePlanQuery),
+	}
+	for _, schema := range schemas {
+		g.schemas[schema.TableName] = schema
+	}
+	for _, query := range plan.Queries {
+		if query.Type != domains.CachePlanQueryType_SELECT || !query.Select.Cache {
+			continue
+		}
+		g.readsByTable[query.Select.Table] = append(g.readsByTable[query.Select.Table], query)
+	}
+	return g
+}
+
+func (g *graphAnalyzer) analyze() InvalidationGraph {
+	graph := InvalidationGraph{Reads: []string{}, Writes: []InvalidationWrite{}}
+	for _, query := range g.plan.Queries {
+		switch query.Type {
+		case domains.CachePlanQueryType_SELECT:
+			if query.Select.Cache {
+				graph.Reads = append(graph.Reads, query.Query)
+			}
+		case domains.CachePlanQueryType_INSERT:
+			graph.Writes = append(graph.Writes, g.analyzeInsert(query))
+		case domains.CachePlanQueryType_UPDATE:
+			graph.Writes = append(graph.Writes, g.analyzeUpdate(query))
+		case domains.CachePlanQueryType_DELETE:
+			graph.Writes = append(graph.Writes, g.analyzeDelete(query))
+		}
+	}
+	return graph
+}
+
+func (g *graphAnalyzer) analyzeInsert(query *domains.CachePlanQuery) InvalidationWrite {
+	table := query.Insert.Table
+	write := newInvalidationWrite(query, table)
+	// the driver purges the rows shared by primary key on "ON DUPLICATE KEY UPDATE", since the existing rows may be updated
+	onDuplicate := strings.Contains(query.Query, "ON DUPLICATE KEY UPDATE")
+	args, _ := normalizer.NormalizeArgs(query.Query)
+	for _, read := range g.readsByTable[table] {
+		conditions := read.Select.Conditions
+		if onDuplicate && g.isEntityRead(read) {
+			write.add(read, InvalidationKind_PURGE)
+			continue
+		}
+		if g.isSingleUniqueCondition(conditions, table) {
+			// a new row never changes the result of a lookup by an existing unique key
+			continue
+		}
+		if len(args.ExtraArgs) > 0 {
+			// the driver purges the reads on the inserts with literals in the conditions
+			write.add(read, InvalidationKind_PURGE)
+			continue
+		}
+		if len(conditions) == 0 && g.isInsertedIntoList(query, read) {
+			// the only list like "SELECT * FROM table ORDER BY ... LIMIT ?"
+			write.add(read, InvalidationKind_UPDATE)
+			continue
+		}
+		if len(conditions) != 1 || conditions[0].Operator != domains.CachePlanOperator_EQ {
+			write.add(read, InvalidationKind_PURGE)
+			continue
+		}
+		column := g.schemas[table].Columns[conditions[0].Column]
+		switch {
+		case !slices.Contains(query.Insert.Columns, conditions[0].Column) && column.IsNullable && !column.HasDefault:
+			// the new rows have NULL in the column, which never matches "col = ?"
+			continue
+		case !slices.Contains(query.Insert.Columns, conditions[0].Column):
+			write.add(read, InvalidationKind_PURGE)
+		case g.isInsertedIntoList(query, read):
+			write.add(read, InvalidationKind_UPDATE)
+		default:
+			write.add(read, InvalidationKind_FORGET)
+		}
+	}
+	return write
+}
+
+// isInsertedIntoList reports whether the inserted rows are added to the cached lists of the entity read,
+// i.e. the insert query supplies every selected column and the primary key (or it is an auto-increment one) by placeholders,
+// and the read is sorted by integers or datetimes.
+// The rows inserted at once without the auto-increment primary key are still forgotten at runtime.
+// With LIMIT, the rows falling after the cached window are ignored, and so the read needs ORDER BY.
+func (g *graphAnalyzer) isInsertedIntoList(query *domains.CachePlanQuery, read *domains.CachePlanQuery) bool {
+	if !g.isEntityRead(read) || strings.Contains(query.Query, "ON DUPLICATE KEY UPDATE") || !sql_parser.ValuesArePlaceholders(query.Query) {
+		return false
+	}
+	columns := g.schemas[query.Insert.Table].Columns
+	inserted := slices.Clone(query.Insert.Columns)
+	var missing []string
+	for name, column := range columns {
+		if column.IsPrimary && !slices.Contains(inserted, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 1 || (len(missing) == 1 && !columns[missing[0]].IsAutoIncrement) {
+		return false
+	}
+	inserted = append(inserted, missing...)
+	for _, target := range read.Select.Targets {
+		if !slices.Contains(inserted, target) {
+			return false
+		}
+	}
+	if read.Select.Limit != nil && len(read.Select.Orders) == 0 {
+		return false
+	}
+	for _, order := range read.Select.Orders {
+		if !slices.Contains(inserted, order.Column) {
+			return false
+		}
+		switch columns[order.Column].DataType {
+		case domains.TableSchemaDataType_INT, domains.TableSchemaDataType_INT64, domains.TableSchemaDataType_DATETIME:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (g *graphAnalyzer) analyzeUpdate(query *domains.CachePlanQuery) InvalidationWrite {
+	table := query.Update.Table
+	write := newInvalidationWrite(query, table)
+	conditions := query.Update.Conditions
+	byUnique := g.isSingleUniqueCondition(conditions, table)
+	byPrimaryKey := byUnique && g.schemas[table].Columns[conditions[0].Column].IsPrimary
+	writeThrough := byPrimaryKey && g.isWriteThrough(query)
+	for _, read := range g.readsByTable[table] {
+		if !usesUpdatedColumn(read.Select.Targets, query.Update.Targets) {
+			continue
+		}
+		// the rows of an entity read are shared by primary key, and its result does not change unless it is filtered or sorted by the updated columns
+		entityRead := g.isEntityRead(read) && !filtersUpdatedColumn(*read.Select, query.Update.Targets)
+		readConditions := read.Select.Conditions
+		switch {
+		case writeThrough && entityRead:
+			write.add(read, InvalidationKind_UPDATE)
+		case byUnique && g.isSingleUniqueCondition(readConditions, table) && readConditions[0].Column == conditions[0].Column:
+			write.add(read, InvalidationKind_FORGET)
+		case byPrimaryKey && entityRead:
+			write.add(read, InvalidationKind_FORGET)
+		default:
+			write.add(read, InvalidationKind_PURGE)
+		}
+	}
+	return write
+}
+
+func (g *graphAnalyzer) analyzeDelete(query *domains.CachePlanQuery) InvalidationWrite {
+	table := query.Delete.Table
+	write := newInvalidationWrite(query, table)
+	conditions := query.Delete.Conditions
+	byUnique := g.isSingleUniqueCondition(conditions, table)
+	byPrimaryKey := byUnique && g.isPrimaryKey(table, conditions[0].Column)
+	for _, read := range g.readsByTable[table] {
+		switch {
+		case g.isEntityRead(read) && byPrimaryKey:
+			// the deleted row is forgotten from the rows shared by primary key, and the entries holding it are fetched again
+			write.add(read, InvalidationKind_FORGET)
+		case g.isEntityRead(read):
+			// the rows shared by primary key are purged
+			write.add(read, InvalidationKind_PURGE)
+		case byUnique && g.isSingleUniqueCondition(read.Select.Conditions, table):
+			write.add(read, InvalidationKind_FORGET)
+		default:
+			write.add(read, InvalidationKind_PURGE)
+		}
+	}
+	return write
+}
+
+// isPrimaryKey reports whether column is the whole primary key of table
+func (g *graphAnalyzer) isPrimaryKey(table string, column string) bool {
+	for name, c := range g.schemas[table].Columns {
+		if c.IsPrimary != (name == column) {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *graphAnalyzer) isSingleUniqueCondition(conditions []domains.CachePlanCondition, table string) bool {
+	if len(conditions) != 1 {
+		return false
+	}
+	condition := conditions[0]
+	column := g.schemas[table].Columns[condition.Column]
+	return (column.IsPrimary || column.IsUnique) && condition.Operator == domains.CachePlanOperator_EQ
+}
+
+// isWriteThrough reports whether the values of the update query are written through the cache,
+// i.e. every assignment is "col = ?" on a column of string, bytes or integer other than the primary key
+func (g *graphAnalyzer) isWriteThrough(query *domains.CachePlanQuery) bool {
+	tokens := sql_parser.TokenizeRaw(query.Query)
+	set := slices.IndexFunc(tokens, func(t sql_parser.RawToken) bool { return t.IsReserved() && t.Literal() == "SET" })
+	if set < 0 {
+		return false
+	}
+	columns := g.schemas[query.Update.Table].Columns
+	for i := set + 1; i < len(tokens); i += 4 {
+		if i+2 >= len(tokens) || !tokens[i].IsIdentifier() || !tokens[i+1].IsSymbol("=") || !tokens[i+2].IsPlaceholder() {
+			return false
+		}
+		column, ok := columns[tokens[i].Literal()]
+		if !ok || column.IsPrimary {
+			return false
+		}
+		switch column.DataType {
+		case domains.TableSchemaDataType_STRING, domains.TableSchemaDataType_BYTES, domains.TableSchemaDataType_INT, domains.TableSchemaDataType_INT64:
+		default:
+			return false
+		}
+		if i+3 == len(tokens) || !tokens[i+3].IsSymbol(",") {
+			return true
+		}
+	}
+	return false
+}
+
+// isEntityRead reports whether the rows of the select query are shared by primary key with the other queries on the table,
+// i.e. the driver keeps them in the entity store of a table with a primary key
+func (g *graphAnalyzer) isEntityRead(read *domains.CachePlanQuery) bool {
+	hasPrimaryKey := false
+	for _, column := range g.schemas[read.Select.Table].Columns {
+		hasPrimaryKey = hasPrimaryKey || column.IsPrimary
+	}
+	if !hasPrimaryKey {
+		return false
+	}
+	_, ok := sql_parser.EntityProjection(read.Query, read.Select.Table)
+	return ok
+}
+
+func filtersUpdatedColumn(selectQuery domains.CachePlanSelectQuery, updateTargets []domains.CachePlanUpdateTarget) bool {
+	for _, target := range updateTargets {
+		inConditions := slices.ContainsFunc(selectQuery.Conditions, func(condition domains.CachePlanCondition) bool {
+			return condition.Column == target.Column
+		})
+		inOrders := slices.ContainsFunc(selectQuery.Orders, func(order domains.CachePlanOrder) bool {
+			return order.Column == target.Column
+		})
+		if inConditions || inOrders {
+			return true
+		}
+	}
+	return false
+}
+
+func usesUpdatedColumn(selectTargets []string, updateTargets []domains.CachePlanUpdateTarget) bool {
+	for _, target := range updateTargets {
+		if slices.Contains(selectTargets, target.Column) {
+			return true
+		}
+	}
+	return false
+}
+
+func newInvalidationWrite(query *domains.CachePlanQuery, table string) InvalidationWrite {
+	return InvalidationWrite{
+		Query:       query.Query,
+		Type:        query.Type,
+		Table:       table,
+		Invalidates: []InvalidationEdge{},
+	}
+}
+
+func (w *InvalidationWrite) add(read *domains.CachePlanQuery, kind InvalidationKind) {
+	w.Invalidates = append(w.Invalidates, InvalidationEdge{Query: read.Query, Kind: kind})
+}
+
+func (g InvalidationGraph) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, write := range g.Writes {
+		fmt.Fprintf(&b, "%s\n", write.Query)
+		if len(write.Invalidates) == 0 {
+			b.WriteString("  (no cache invalidated)\n")
+		}
+		for _, edge := range write.Invalidates {
+			fmt.Fprintf(&b, "  %-6s %s\n", edge.Kind, edge.Query)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (g InvalidationGraph) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(g); err != nil {
+		return fmt.Errorf("failed to encode invalidation graph: %w", err)
+	}
+	return nil
+}
+
+func (g InvalidationGraph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph isuc {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for i, read := range g.Reads {
+		fmt.Fprintf(&b, "  r%d [label=%q, shape=ellipse];\n", i, read)
+	}
+	for i, write := range g.Writes {
+		fmt.Fprintf(&b, "  w%d [label=%q, shape=box];\n", i, write.Query)
+	}
+	for i, write := range g.Writes {
+		for _, edge := range write.Invalidates {
+			j := slices.Index(g.Reads, edge.Query)
+			color := "orange"
+			switch edge.Kind {
+			case InvalidationKind_PURGE:
+				color = "red"
+			case InvalidationKind_UPDATE:
+				color = "green"
+			}
+			fmt.Fprintf(&b, "  w%d -> r%d [label=%q, color=%s];\n", i, j, edge.Kind, color)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,154 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/traP-jp/isuc/domains"
+)
+
+func TestAnalyzeInvalidations(t *testing.T) {
+	schemas := []domains.TableSchema{
+		{
+			TableName: "users",
+			Columns: map[string]domains.TableSchemaColumn{
+				"id":       {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true, IsAutoIncrement: true},
+				"name":     {ColumnName: "name", DataType: domains.TableSchemaDataType_STRING},
+				"group_id": {ColumnName: "group_id", DataType: domains.TableSchemaDataType_INT, IsNullable: true},
+			},
+		},
+	}
+	plan, err := AnalyzeQueries([]string{
+		"SELECT * FROM users WHERE id = ?",
+		"SELECT * FROM users WHERE group_id = ?",
+		"SELECT id FROM users WHERE group_id = ?",
+		"INSERT INTO users (name, group_id) VALUES (?, ?)",
+		"UPDATE users SET name = ? WHERE id = ?",
+		"DELETE FROM users WHERE id = ?",
+		"DELETE FROM users WHERE group_id = ?",
+		"INSERT INTO users (name) VALUES (?)",
+		"SELECT * FROM users ORDER BY id DESC LIMIT ?",
+	}, schemas)
+	assert.NoError(t, err)
+
+	graph := AnalyzeInvalidations(&plan, schemas)
+
+	expected := InvalidationGraph{
+		Reads: []string{
+			"SELECT * FROM users WHERE id = ?;",
+			"SELECT * FROM users WHERE group_id = ?;",
+			"SELECT id FROM users WHERE group_id = ?;",
+			"SELECT * FROM users ORDER BY id DESC LIMIT ?;",
+		},
+		Writes: []InvalidationWrite{
+			{
+				Query: "INSERT INTO users (name, group_id) VALUES (?);",
+				Type:  domains.CachePlanQueryType_INSERT,
+				Table: "users",
+				Invalidates: []InvalidationEdge{
+					{Query: "SELECT * FROM users WHERE group_id = ?;", Kind: InvalidationKind_UPDATE},
+					{Query: "SELECT id FROM users WHERE group_id = ?;", Kind: InvalidationKind_UPDATE},
+					{Query: "SELECT * FROM users ORDER BY id DESC LIMIT ?;", Kind: InvalidationKind_UPDATE},
+				},
+			},
+			{
+				Query: "UPDATE users SET name = ? WHERE id = ?;",
+				Type:  domains.CachePlanQueryType_UPDATE,
+				Table: "users",
+				Invalidates: []InvalidationEdge{
+					{Query: "SELECT * FROM users WHERE id = ?;", Kind: InvalidationKind_UPDATE},
+					{Query: "SELECT * FROM users WHERE group_id = ?;", Kind: InvalidationKind_UPDATE},
+					{Query: "SELECT * FROM users ORDER BY id DESC LIMIT ?;", Kind: InvalidationKind_UPDATE},
+				},
+			},
+			{
+				// the deleted row is forgotten from the rows shared by primary key
+				Query: "DELETE FROM users WHERE id = ?;",
+				Type:  domains.CachePlanQueryType_DELETE,
+				Table: "users",
+				Invalidates: []InvalidationEdge{
+					{Query: "SELECT * FROM users WHERE id = ?;", Kind: InvalidationKind_FORGET},
+					{Query: "SELECT * FROM users WHERE group_id = ?;", Kind: InvalidationKind_FORGET},
+					{Query: "SELECT id FROM users WHERE group_id = ?;", Kind: InvalidationKind_FORGET},
+					{Query: "SELECT * FROM users ORDER BY id DESC LIMIT ?;", Kind: InvalidationKind_FORGET},
+				},
+			},
+			{
+				Query: "DELETE FROM users WHERE group_id = ?;",
+				Type:  domains.CachePlanQueryType_DELETE,
+				Table: "users",
+				Invalidates: []InvalidationEdge{
+					{Query: "SELECT * FROM users WHERE id = ?;", Kind: InvalidationKind_PURGE},
+					{Query: "SELECT * FROM users WHERE group_id = ?;", Kind: InvalidationKind_PURGE},
+					{Query: "SELECT id FROM users WHERE group_id = ?;", Kind: InvalidationKind_PURGE},
+					{Query: "SELECT * FROM users ORDER BY id DESC LIMIT ?;", Kind: InvalidationKind_PURGE},
+				},
+			},
+			{
+				Query: "INSERT INTO users (name) VALUES (?);",
+				Type:  domains.CachePlanQueryType_INSERT,
+				Table: "users",
+				Invalidates: []InvalidationEdge{
+					{Query: "SELECT * FROM users ORDER BY id DESC LIMIT ?;", Kind: InvalidationKind_PURGE},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, graph)
+
+	var dot strings.Builder
+	assert.NoError(t, graph.WriteDOT(&dot))
+	assert.Contains(t, dot.String(), "w0 -> r1 [label=\"update\", color=green];")
+	assert.Contains(t, dot.String(), "w1 -> r0 [label=\"update\", color=green];")
+	assert.Contains(t, dot.String(), "w2 -> r0 [label=\"forget\", color=orange];")
+	assert.Contains(t, dot.String(), "w2 -> r1 [label=\"forget\", color=orange];")
+	assert.Contains(t, dot.String(), "w3 -> r1 [label=\"purge\", color=red];")
+}
+
+func TestAnalyzeInsertInvalidations(t *testing.T) {
+	schemas := []domains.TableSchema{
+		{
+			TableName: "users",
+			Columns: map[string]domains.TableSchemaColumn{
+				"id":       {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true, IsAutoIncrement: true},
+				"name":     {ColumnName: "name", DataType: domains.TableSchemaDataType_STRING},
+				"group_id": {ColumnName: "group_id", DataType: domains.TableSchemaDataType_INT, IsNullable: true},
+			},
+		},
+	}
+	plan, err := AnalyzeQueries([]string{
+		"SELECT * FROM users WHERE id = ?",
+		"SELECT * FROM users WHERE group_id = ?",
+		"SELECT * FROM users ORDER BY id DESC LIMIT ?",
+		"INSERT INTO users (name, group_id) VALUES ('guest', ?)",
+		"INSERT INTO users (id, name, group_id) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name = ?",
+	}, schemas)
+	assert.NoError(t, err)
+
+	graph := AnalyzeInvalidations(&plan, schemas)
+
+	assert.Equal(t, []InvalidationWrite{
+		{
+			// the rows with literals are not added to the lists
+			Query: "INSERT INTO users (name, group_id) VALUES ('guest', ?);",
+			Type:  domains.CachePlanQueryType_INSERT,
+			Table: "users",
+			Invalidates: []InvalidationEdge{
+				{Query: "SELECT * FROM users WHERE group_id = ?;", Kind: InvalidationKind_FORGET},
+				{Query: "SELECT * FROM users ORDER BY id DESC LIMIT ?;", Kind: InvalidationKind_PURGE},
+			},
+		},
+		{
+			// the existing rows may be updated
+			Query: "INSERT INTO users (id, name, group_id) VALUES (?) ON DUPLICATE KEY UPDATE name = ?;",
+			Type:  domains.CachePlanQueryType_INSERT,
+			Table: "users",
+			Invalidates: []InvalidationEdge{
+				{Query: "SELECT * FROM users WHERE id = ?;", Kind: InvalidationKind_PURGE},
+				{Query: "SELECT * FROM users WHERE group_id = ?;", Kind: InvalidationKind_PURGE},
+				{Query: "SELECT * FROM users ORDER BY id DESC LIMIT ?;", Kind: InvalidationKind_PURGE},
+			},
+		},
+	}, graph.Writes)
+}
@@ -62,8 +62,8 @@ func (q *queryAnalyzer) analyzeSelectStmt(node sql_parser.SelectStmtNode) (domai
 	if err != nil {
 		selectErr.errors = append(selectErr.errors, fmt.Errorf("failed to analyze orders: %s", err))
 	}
-	conditions = append(conditions, q.analyzeLimit(node.Limit, len(conditions))...)
-	conditions = append(conditions, q.analyzeOffset(node.Offset, len(conditions))...)
+	limit := q.analyzeLimit(node.Limit)
+	offset := q.analyzeOffset(node.Offset)
 
 	query := domains.CachePlanQuery{
 		CachePlanQueryBase: &domains.CachePlanQueryBase{
@@ -76,6 +76,8 @@ func (q *queryAnalyzer) analyzeSelectStmt(node sql_parser.SelectStmtNode) (domai
 			Targets:    targets,
 			Conditions: conditions,
 			Orders:     orders,
+			Limit:      limit,
+			Offset:     offset,
 		},
 	}
 
@@ -144,8 +146,7 @@ func (q *queryAnalyzer) analyzeUpdateStmt(node sql_parser.UpdateStmtNode) (domai
 	if err != nil {
 		selectErr.errors = append(selectErr.errors, fmt.Errorf("failed to analyze orders: %s", err))
 	}
-	conditions = append(conditions, q.analyzeLimit(node.Limit, len(conditions))...)
-	conditions = append(conditions, q.analyzeOffset(node.Offset, len(conditions))...)
+	conditions = append(conditions, limitConditions(q.analyzeLimit(node.Limit), q.analyzeOffset(node.Offset))...)
 
 	query := domains.CachePlanQuery{
 		CachePlanQueryBase: &domains.CachePlanQueryBase{
@@ -166,12 +167,13 @@ func (q *queryAnalyzer) analyzeUpdateStmt(node sql_parser.UpdateStmtNode) (domai
 func (q *queryAnalyzer) analyzeUpdateSets(sets sql_parser.UpdateSetsNode) []domains.CachePlanUpdateTarget {
 	result := []domains.CachePlanUpdateTarget{}
 	for _, set := range sets.Sets {
-		if _, ok := set.Value.(sql_parser.PlaceholderNode); !ok {
+		placeholder, ok := set.Value.(sql_parser.PlaceholderNode)
+		if !ok {
 			continue
 		}
 		result = append(result, domains.CachePlanUpdateTarget{
 			Column:      set.Column.Name,
-			Placeholder: domains.CachePlanPlaceholder{Index: q.placeholder()},
+			Placeholder: domains.CachePlanPlaceholder{Index: q.placeholder(), Name: placeholder.Name},
 		})
 	}
 	return result
@@ -182,8 +184,7 @@ func (a *queryAnalyzer) analyzeDeleteStmt(node sql_parser.DeleteStmtNode) (domai
 	if err != nil {
 		return domains.CachePlanQuery{}, fmt.Errorf("failed to analyze conditions: %s", err)
 	}
-	conditions = append(conditions, a.analyzeLimit(node.Limit, len(conditions))...)
-	conditions = append(conditions, a.analyzeOffset(node.Offset, len(conditions))...)
+	conditions = append(conditions, limitConditions(a.analyzeLimit(node.Limit), a.analyzeOffset(node.Offset))...)
 
 	return domains.CachePlanQuery{
 		CachePlanQueryBase: &domains.CachePlanQueryBase{
@@ -205,12 +206,14 @@ func (a *queryAnalyzer) analyzeConditions(node *sql_parser.ConditionsNode) ([]do
 	conditions := []domains.CachePlanCondition{}
 	for _, condition := range node.Conditions {
 		// continue if the value is not ? or (?)
-		if _, ok := condition.Value.(sql_parser.PlaceholderNode); !ok {
+		placeholder, ok := condition.Value.(sql_parser.PlaceholderNode)
+		if !ok {
 			v, ok := condition.Value.(sql_parser.ValuesNode)
 			if !ok {
 				continue
 			}
-			if _, ok := v.Values[0].(sql_parser.PlaceholderNode); !ok {
+			placeholder, ok = v.Values[0].(sql_parser.PlaceholderNode)
+			if !ok {
 				continue
 			}
 		}
@@ -223,7 +226,7 @@ func (a *queryAnalyzer) analyzeConditions(node *sql_parser.ConditionsNode) ([]do
 		conditions = append(conditions, domains.CachePlanCondition{
 			Column:      condition.Column.Name,
 			Operator:    op,
-			Placeholder: domains.CachePlanPlaceholder{Index: a.placeholder()},
+			Placeholder: domains.CachePlanPlaceholder{Index: a.placeholder(), Name: placeholder.Name},
 		})
 
 	}
@@ -258,40 +261,42 @@ func (a *queryAnalyzer) analyzeOrder(node sql_parser.OrderNode) (domains.CachePl
 	}, nil
 }
 
-func (q *queryAnalyzer) analyzeLimit(node *sql_parser.LimitNode, index int) []domains.CachePlanCondition {
+func (q *queryAnalyzer) analyzeLimit(node *sql_parser.LimitNode) *domains.CachePlanPlaceholder {
 	if node == nil {
-		return []domains.CachePlanCondition{}
+		return nil
 	}
 
-	if _, ok := node.Limit.(sql_parser.PlaceholderNode); !ok {
-		return []domains.CachePlanCondition{}
+	placeholder, ok := node.Limit.(sql_parser.PlaceholderNode)
+	if !ok {
+		return nil
 	}
 
-	return []domains.CachePlanCondition{
-		{
-			Column:      "LIMIT()",
-			Operator:    domains.CachePlanOperator_EQ,
-			Placeholder: domains.CachePlanPlaceholder{Index: index},
-		},
-	}
+	return &domains.CachePlanPlaceholder{Index: q.placeholder(), Name: placeholder.Name}
 }
 
-func (q *queryAnalyzer) analyzeOffset(node *sql_parser.OffsetNode, index int) []domains.CachePlanCondition {
+func (q *queryAnalyzer) analyzeOffset(node *sql_parser.OffsetNode) *domains.CachePlanPlaceholder {
 	if node == nil {
-		return []domains.CachePlanCondition{}
+		return nil
 	}
 
-	if _, ok := node.Offset.(sql_parser.PlaceholderNode); !ok {
-		return []domains.CachePlanCondition{}
+	placeholder, ok := node.Offset.(sql_parser.PlaceholderNode)
+	if !ok {
+		return nil
 	}
 
-	return []domains.CachePlanCondition{
-		{
-			Column:      "OFFSET()",
-			Operator:    domains.CachePlanOperator_EQ,
-			Placeholder: domains.CachePlanPlaceholder{Index: index},
-		},
+	return &domains.CachePlanPlaceholder{Index: q.placeholder(), Name: placeholder.Name}
+}
+
+// limitConditions encodes LIMIT and OFFSET of update and delete queries as the conditions "LIMIT()" and "OFFSET()"
+func limitConditions(limit *domains.CachePlanPlaceholder, offset *domains.CachePlanPlaceholder) []domains.CachePlanCondition {
+	conditions := []domains.CachePlanCondition{}
+	if limit != nil {
+		conditions = append(conditions, domains.CachePlanCondition{Column: "LIMIT()", Operator: domains.CachePlanOperator_EQ, Placeholder: *limit})
+	}
+	if offset != nil {
+		conditions = append(conditions, domains.CachePlanCondition{Column: "OFFSET()", Operator: domains.CachePlanOperator_EQ, Placeholder: *offset})
 	}
+	return conditions
 }
 
 func (q *queryAnalyzer) analyzeEnum(order sql_parser.OrderEnum) (domains.CachePlanOrderEnum, error) {
@@ -0,0 +1,82 @@
+package analyzer
+
+import (
+	"time"
+
+	"github.com/traP-jp/isuc/domains"
+	"github.com/traP-jp/isuc/normalizer"
+)
+
+// ApplyWorkload estimates the hit ratio of each cached select query under the observed workload
+// and disables caching of the queries whose estimated hit ratio is below minHitRatio.
+// The estimate is recorded in the plan.
+//
+// Every forget or purge by a write query is assumed to cause one miss on the next read,
+// so the estimated hit ratio is an upper bound when a purge evicts many keys at once.
+// The rows rewritten by a write-through update stay cached, and so they are not counted.
+func ApplyWorkload(plan *domains.CachePlan, schemas []domains.TableSchema, stats []domains.QueryStats, minHitRatio float64) {
+	statsByQuery := aggregateQueryStats(stats)
+	// the graph must be computed before any cache is disabled
+	graph := AnalyzeInvalidations(plan, schemas)
+
+	invalidations := make(map[string]int64)
+	for _, write := range graph.Writes {
+		count := statsByQuery[write.Query].Count
+		for _, edge := range write.Invalidates {
+			if edge.Kind == InvalidationKind_UPDATE {
+				continue
+			}
+			invalidations[edge.Query] += count
+		}
+	}
+
+	for _, query := range plan.Queries {
+		if query.Type != domains.CachePlanQueryType_SELECT || !query.Select.Cache {
+			continue
+		}
+		s, ok := statsByQuery[query.Query]
+		if !ok {
+			// the query was not observed in the workload, so there is nothing to estimate
+			continue
+		}
+		estimate := estimateCache(s, invalidations[query.Query])
+		query.Select.Estimate = &estimate
+		if estimate.HitRatio < minHitRatio {
+			query.Select.Cache = false
+		}
+	}
+}
+
+func estimateCache(stats domains.QueryStats, invalidations int64) domains.CachePlanEstimate {
+	hits := max(stats.Count-invalidations, 0)
+	hitRatio := 0.0
+	if stats.Count > 0 {
+		hitRatio = float64(hits) / float64(stats.Count)
+	}
+	return domains.CachePlanEstimate{
+		Reads:         stats.Count,
+		Invalidations: invalidations,
+		HitRatio:      hitRatio,
+		AverageRows:   stats.AverageRows(),
+		SavedTime:     stats.AverageLatency() * time.Duration(hits),
+	}
+}
+
+// aggregateQueryStats normalizes the queries in the same way as AnalyzeQueries does
+// and sums up the stats of queries which differ only in literals
+func aggregateQueryStats(stats []domains.QueryStats) map[string]domains.QueryStats {
+	result := make(map[string]domains.QueryStats, len(stats))
+	for _, s := range stats {
+		query := normalizer.NormalizeQuery(s.Query)
+		if normalized, err := normalizer.NormalizeArgs(query); err == nil {
+			query = normalized.Query
+		}
+		aggregated := result[query]
+		aggregated.Query = query
+		aggregated.Count += s.Count
+		aggregated.TotalLatency += s.TotalLatency
+		aggregated.Rows += s.Rows
+		result[query] = aggregated
+	}
+	return result
+}
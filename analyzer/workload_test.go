@@ -0,0 +1,68 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/traP-jp/isuc/domains"
+)
+
+func TestApplyWorkload(t *testing.T) {
+	schemas := []domains.TableSchema{
+		{
+			TableName: "users",
+			Columns: map[string]domains.TableSchemaColumn{
+				"id":       {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true},
+				"name":     {ColumnName: "name", DataType: domains.TableSchemaDataType_STRING},
+				"group_id": {ColumnName: "group_id", DataType: domains.TableSchemaDataType_INT},
+			},
+		},
+	}
+	plan, err := AnalyzeQueries([]string{
+		"SELECT * FROM users WHERE id = ?",
+		"SELECT * FROM users WHERE group_id = ?",
+		"SELECT name FROM users",
+		"UPDATE users SET name = ? WHERE id = ?",
+		"UPDATE users SET group_id = ? WHERE id = ?",
+	}, schemas)
+	assert.NoError(t, err)
+
+	stats := []domains.QueryStats{
+		{Query: "SELECT * FROM `users` WHERE `id` = ?", Count: 100, TotalLatency: 100 * time.Millisecond, Rows: 100},
+		// literals are folded into the same query
+		{Query: "SELECT * FROM users WHERE group_id = 1", Count: 30, TotalLatency: 30 * time.Millisecond, Rows: 90},
+		{Query: "SELECT * FROM users WHERE group_id = 2", Count: 10, TotalLatency: 10 * time.Millisecond, Rows: 30},
+		// written through the cached rows, which stay cached
+		{Query: "UPDATE users SET name = ? WHERE id = ?", Count: 20},
+		// written through the rows by id, but purging the rows filtered by group_id
+		{Query: "UPDATE users SET group_id = ? WHERE id = ?", Count: 20},
+	}
+
+	ApplyWorkload(&plan, schemas, stats, 0.6)
+
+	byID := plan.Queries[0].Select
+	assert.True(t, byID.Cache)
+	assert.Equal(t, &domains.CachePlanEstimate{
+		Reads:         100,
+		Invalidations: 0,
+		HitRatio:      1,
+		AverageRows:   1,
+		SavedTime:     100 * time.Millisecond,
+	}, byID.Estimate)
+
+	byGroup := plan.Queries[1].Select
+	assert.False(t, byGroup.Cache)
+	assert.Equal(t, &domains.CachePlanEstimate{
+		Reads:         40,
+		Invalidations: 20,
+		HitRatio:      0.5,
+		AverageRows:   3,
+		SavedTime:     20 * time.Millisecond,
+	}, byGroup.Estimate)
+
+	// not observed
+	all := plan.Queries[2].Select
+	assert.True(t, all.Cache)
+	assert.Nil(t, all.Estimate)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
 	"regexp"
@@ -20,6 +21,11 @@ var analyzeCmd = &cobra.Command{
 		sqlFile := cmd.Flag("sql").Value.String()
 		schemasFile := cmd.Flag("schema").Value.String()
 		outFile := cmd.Flag("out").Value.String()
+		statsFile := cmd.Flag("stats").Value.String()
+		minHitRatio, err := cmd.Flags().GetFloat64("min-hit-ratio")
+		if err != nil {
+			return fmt.Errorf("error getting min-hit-ratio flag: %v", err)
+		}
 
 		// read sql file
 		queries, err := readQueriesFromFile(sqlFile)
@@ -34,11 +40,20 @@ var analyzeCmd = &cobra.Command{
 		}
 
 		// analyze queries
-		cachePlan, err := analyzer.AnalyzeQueries(queries, schemas)
+		cachePlan, err := analyzer.AnalyzeExtractedQueries(queries, schemas)
 		if err != nil {
 			fmt.Printf("warnings:\n%v\n", err)
 		}
 
+		// weigh the plan by the observed workload
+		if statsFile != "" {
+			stats, err := readStatsFromFile(statsFile)
+			if err != nil {
+				return fmt.Errorf("failed to read query stats from file: %w", err)
+			}
+			analyzer.ApplyWorkload(&cachePlan, schemas, stats, minHitRatio)
+		}
+
 		// write cache plan to file
 		file, err := os.Create(outFile)
 		if err != nil {
@@ -57,22 +72,38 @@ var analyzeCmd = &cobra.Command{
 }
 
 func init() {
-	analyzeCmd.Flags().StringP("sql", "s", "extracted.sql", "File containing extracted queries")
+	analyzeCmd.Flags().StringP("sql", "s", "extracted.sql", "File containing extracted queries (sql or json format)")
 	analyzeCmd.Flags().StringP("schema", "t", "schema.sql", "File containing table schemas")
 	analyzeCmd.Flags().StringP("out", "o", "isuc.yaml", "Destination file that cache plan will be written to")
+	analyzeCmd.Flags().String("stats", "", "File containing query stats (JSON) collected by the dynamic extractor")
+	analyzeCmd.Flags().Float64("min-hit-ratio", 0.5, "Disable caching of queries whose estimated hit ratio is below this value (requires --stats)")
 	rootCmd.AddCommand(analyzeCmd)
 }
 
 var commentRegex = regexp.MustCompile(`(?m)--.*$`)
 
-func readQueriesFromFile(path string) ([]string, error) {
+// readQueriesFromFile reads the queries written by `isuc extract`.
+// The locations of the queries are only available in the json format, which is told from the content
+// since the file may be named anything.
+func readQueriesFromFile(path string) ([]domains.ExtractedQuery, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		queries, err := domains.LoadExtractedQueries(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load extracted queries: %w", err)
+		}
+		return queries, nil
+	}
+
 	content := string(data)
 	content = commentRegex.ReplaceAllString(content, "")
-	queries := strings.Split(content, ";")
+	queries := []domains.ExtractedQuery{}
+	for _, query := range strings.Split(content, ";") {
+		queries = append(queries, domains.ExtractedQuery{Query: query})
+	}
 	return queries, nil
 }
 
@@ -89,3 +120,16 @@ func readSchemasFromFile(path string) ([]domains.TableSchema, error) {
 	}
 	return schemas, nil
 }
+
+func readStatsFromFile(path string) ([]domains.QueryStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	stats, err := domains.LoadQueryStats(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load query stats: %w", err)
+	}
+	return stats, nil
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
@@ -11,7 +14,7 @@ import (
 var extractCmd = &cobra.Command{
 	Use:       "extract",
 	Short:     "Extract SQL queries from the codebase",
-	Long:      "Statistically analyze the codebase and extract SQL queries",
+	Long:      "Statically analyze the codebase with type information and extract the SQL queries passed to database/sql and sqlx",
 	Args:      cobra.ExactArgs(1),
 	ValidArgs: []string{"path"},
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -21,32 +24,48 @@ var extractCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("error getting out flag: %v", err)
 		}
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return fmt.Errorf("error getting format flag: %v", err)
+		}
+		if format != "sql" && format != "json" {
+			return fmt.Errorf("unknown format: %s", format)
+		}
+		if out == "" {
+			out = "extracted." + format
+		}
 
 		valid := static_extractor.IsValidDir(path)
 		if !valid {
 			return fmt.Errorf("invalid directory: %s", path)
 		}
-		files, err := static_extractor.ListAllGoFiles(path)
+		opts, err := extractOptions(cmd, path)
 		if err != nil {
-			return fmt.Errorf("error listing go files: %v", err)
+			return err
+		}
+		results, err := static_extractor.Extract(path, opts)
+		if err != nil {
+			return fmt.Errorf("error loading packages: %v", err)
 		}
 
-		fmt.Printf("found %d go files\n", len(files))
+		fmt.Printf("found %d go files\n", len(results))
 		extractedQueries := []*static_extractor.ExtractedQuery{}
-		for _, file := range files {
-			relativePath, err := filepath.Rel(path, file)
-			if err != nil {
-				return fmt.Errorf("error getting relative path: %v", err)
-			}
-			queries, err := static_extractor.ExtractQueryFromFile(file, path)
-			if err != nil {
-				return fmt.Errorf("❌ %s: error while extracting: %v", relativePath, err)
+		for _, result := range results {
+			if result.Err != nil {
+				fmt.Printf("❌ %s: error while extracting: %v\n", result.File, result.Err)
+			} else if result.Cached {
+				fmt.Printf("✅ %s: %d queries extracted (cached)\n", result.File, len(result.Queries))
+			} else {
+				fmt.Printf("✅ %s: %d queries extracted\n", result.File, len(result.Queries))
 			}
-			fmt.Printf("✅ %s: %d queries extracted\n", relativePath, len(queries))
-			extractedQueries = append(extractedQueries, queries...)
+			extractedQueries = append(extractedQueries, result.Queries...)
 		}
 
-		err = static_extractor.WriteQueriesToFile(out, extractedQueries)
+		if format == "json" {
+			err = static_extractor.WriteQueriesToJSONFile(out, extractedQueries)
+		} else {
+			err = static_extractor.WriteQueriesToFile(out, extractedQueries)
+		}
 		if err != nil {
 			return fmt.Errorf("error writing queries to file: %v", err)
 		}
@@ -57,7 +76,62 @@ var extractCmd = &cobra.Command{
 	},
 }
 
+func extractOptions(cmd *cobra.Command, path string) (static_extractor.Options, error) {
+	opts := static_extractor.Options{}
+	var err error
+	if opts.Include, err = cmd.Flags().GetStringSlice("include"); err != nil {
+		return opts, fmt.Errorf("error getting include flag: %v", err)
+	}
+	if opts.Exclude, err = cmd.Flags().GetStringSlice("exclude"); err != nil {
+		return opts, fmt.Errorf("error getting exclude flag: %v", err)
+	}
+	if opts.Tags, err = cmd.Flags().GetStringSlice("tags"); err != nil {
+		return opts, fmt.Errorf("error getting tags flag: %v", err)
+	}
+	if opts.Workers, err = cmd.Flags().GetInt("workers"); err != nil {
+		return opts, fmt.Errorf("error getting workers flag: %v", err)
+	}
+	noCache, err := cmd.Flags().GetBool("no-cache")
+	if err != nil {
+		return opts, fmt.Errorf("error getting no-cache flag: %v", err)
+	}
+	if noCache {
+		return opts, nil
+	}
+	if opts.CacheFile, err = cmd.Flags().GetString("cache-file"); err != nil {
+		return opts, fmt.Errorf("error getting cache-file flag: %v", err)
+	}
+	if opts.CacheFile == "" {
+		opts.CacheFile, err = defaultExtractCacheFile(path)
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// defaultExtractCacheFile returns a cache file in the user cache directory unique to the codebase
+func defaultExtractCacheFile(path string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting cache directory: %v", err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("error getting absolute path: %v", err)
+	}
+	sum := sha256.Sum256([]byte(absPath))
+	return filepath.Join(cacheDir, "isuc", "extract", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
 func init() {
-	extractCmd.Flags().StringP("out", "o", "extracted.sql", "Destination file that extracted queries will be written to")
+	extractCmd.Flags().StringP("out", "o", "", "Destination file that extracted queries will be written to (extracted.sql, or extracted.json for the json format, by default)")
+	extractCmd.Flags().StringSlice("include", nil, "Only extract from the files matching the globs (e.g. 'internal/**')")
+	extractCmd.Flags().StringSlice("exclude", nil, "Skip the files and directories matching the globs (e.g. '*_mock.go')")
+	extractCmd.Flags().StringSlice("tags", nil, "Build tags used to select the files")
+	extractCmd.Flags().Int("workers", 0, "Number of files parsed and packages extracted concurrently (the number of CPUs by default)")
+	extractCmd.Flags().String("cache-file", "", "File caching the results of unchanged packages (in the user cache directory by default)")
+	extractCmd.Flags().Bool("no-cache", false, "Extract all packages without the cache")
+	extractCmd.Flags().StringP("format", "f", "sql", "Output format (sql, json); json records the location and the raw query of each query")
 	rootCmd.AddCommand(extractCmd)
 }
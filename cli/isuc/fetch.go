@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:       "fetch",
+	Short:     "Fetch the queries collected by the dynamic extractor",
+	Long:      "Fetch the queries (and optionally the stats) collected by the dynamic extractor server",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"url"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		base := args[0]
+		out, err := cmd.Flags().GetString("out")
+		if err != nil {
+			return fmt.Errorf("error getting out flag: %v", err)
+		}
+		stats, err := cmd.Flags().GetString("stats")
+		if err != nil {
+			return fmt.Errorf("error getting stats flag: %v", err)
+		}
+		reset, err := cmd.Flags().GetBool("reset")
+		if err != nil {
+			return fmt.Errorf("error getting reset flag: %v", err)
+		}
+
+		if err := download(base, "extracted.sql", out); err != nil {
+			return fmt.Errorf("error fetching queries: %v", err)
+		}
+		fmt.Printf("queries written to %s\n", out)
+
+		if stats != "" {
+			if err := download(base, "stats", stats); err != nil {
+				return fmt.Errorf("error fetching stats: %v", err)
+			}
+			fmt.Printf("stats written to %s\n", stats)
+		}
+
+		if reset {
+			endpoint, err := url.JoinPath(base, "reset")
+			if err != nil {
+				return fmt.Errorf("invalid url: %v", err)
+			}
+			res, err := http.Post(endpoint, "", nil)
+			if err != nil {
+				return fmt.Errorf("error resetting queries: %v", err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusNoContent {
+				return fmt.Errorf("error resetting queries: unexpected status %s", res.Status)
+			}
+			fmt.Println("queries on the server are reset")
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	fetchCmd.Flags().StringP("out", "o", "extracted.sql", "Destination file that fetched queries will be written to")
+	fetchCmd.Flags().String("stats", "", "Destination file that fetched query stats (JSON) will be written to")
+	fetchCmd.Flags().Bool("reset", false, "Reset the queries on the server after fetching")
+	rootCmd.AddCommand(fetchCmd)
+}
+
+func download(base string, path string, dest string) error {
+	endpoint, err := url.JoinPath(base, path)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	res, err := http.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, res.Body); err != nil {
+		return fmt.Errorf("error writing to file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/traP-jp/isuc/analyzer"
+	"github.com/traP-jp/isuc/domains"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Show which caches are invalidated by each write query",
+	Long:  "Compute, for each write query in the cache plan, which select caches it forgets or purges",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		planFile := cmd.Flag("plan").Value.String()
+		schemasFile := cmd.Flag("schema").Value.String()
+		format := cmd.Flag("format").Value.String()
+		outFile := cmd.Flag("out").Value.String()
+
+		plan, err := readPlanFromFile(planFile)
+		if err != nil {
+			return fmt.Errorf("failed to read cache plan from file: %w", err)
+		}
+
+		schemas, err := readSchemasFromFile(schemasFile)
+		if err != nil {
+			return fmt.Errorf("failed to read schemas from file: %w", err)
+		}
+
+		graph := analyzer.AnalyzeInvalidations(plan, schemas)
+
+		var out io.Writer = os.Stdout
+		if outFile != "" {
+			file, err := os.Create(outFile)
+			if err != nil {
+				return fmt.Errorf("failed to create file: %w", err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		switch format {
+		case "text":
+			err = graph.WriteText(out)
+		case "json":
+			err = graph.WriteJSON(out)
+		case "dot":
+			err = graph.WriteDOT(out)
+		default:
+			return fmt.Errorf("unknown format: %s", format)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write invalidation graph: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	graphCmd.Flags().StringP("plan", "p", "isuc.yaml", "File containing the cache plan")
+	graphCmd.Flags().StringP("schema", "s", "schema.sql", "File containing the table schema")
+	graphCmd.Flags().StringP("format", "f", "text", "Output format (text, json, dot)")
+	graphCmd.Flags().StringP("out", "o", "", "Destination file (stdout by default)")
+	rootCmd.AddCommand(graphCmd)
+}
+
+func readPlanFromFile(path string) (*domains.CachePlan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	plan, err := domains.LoadCachePlan(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cache plan: %w", err)
+	}
+	return plan, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	dynamic_extractor "github.com/traP-jp/isuc/extractor/dynamic"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay a workload log recorded by the dynamic extractor",
+	Long:  "Replay a workload log recorded by the dynamic extractor against a database and compare the timings and results with the recorded ones",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logFile := cmd.Flag("log").Value.String()
+		dsn := cmd.Flag("dsn").Value.String()
+		driverName := cmd.Flag("driver").Value.String()
+		cachePackage := cmd.Flag("cache-package").Value.String()
+		outFile := cmd.Flag("out").Value.String()
+
+		if dsn == "" {
+			return fmt.Errorf("dsn is required")
+		}
+
+		var out io.Writer = os.Stdout
+		if outFile != "" {
+			file, err := os.Create(outFile)
+			if err != nil {
+				return fmt.Errorf("failed to create file: %w", err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		switch driverName {
+		case "mysql":
+			file, err := os.Open(logFile)
+			if err != nil {
+				return fmt.Errorf("failed to open workload log: %w", err)
+			}
+			defer file.Close()
+			if err := dynamic_extractor.RunReplay(context.Background(), driverName, dsn, file, out); err != nil {
+				return fmt.Errorf("failed to replay workload: %w", err)
+			}
+		case "mysql+cache":
+			if cachePackage == "" {
+				return fmt.Errorf("cache-package is required for the mysql+cache driver")
+			}
+			if err := replayWithCache(cachePackage, dsn, logFile, out); err != nil {
+				return fmt.Errorf("failed to replay workload: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown driver: %s", driverName)
+		}
+		return nil
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringP("log", "l", "workload.jsonl", "Workload log written by the dynamic extractor")
+	replayCmd.Flags().String("dsn", "", "DSN of the database to replay the workload against")
+	replayCmd.Flags().StringP("driver", "d", "mysql", "Driver used to replay the workload (mysql, mysql+cache)")
+	replayCmd.Flags().String("cache-package", "", "Import path of the generated cache driver (required for mysql+cache)")
+	replayCmd.Flags().StringP("out", "o", "", "Destination file of the report (stdout by default)")
+	rootCmd.AddCommand(replayCmd)
+}
+
+const replayMain = `package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	dynamic_extractor "github.com/traP-jp/isuc/extractor/dynamic"
+	_ %s
+)
+
+func main() {
+	file, err := os.Open(%s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := dynamic_extractor.RunReplay(context.Background(), "mysql+cache", %s, file, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+`
+
+// replayWithCache builds a program linking the generated driver in the current module and runs the replay in it,
+// since the generated driver cannot be loaded into this binary
+func replayWithCache(cachePackage string, dsn string, logFile string, out io.Writer) error {
+	logPath, err := filepath.Abs(logFile)
+	if err != nil {
+		return fmt.Errorf("failed to resolve workload log path: %w", err)
+	}
+
+	// the program has to be inside the module which contains the generated driver
+	dir, err := os.MkdirTemp(".", "isuc-replay-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := fmt.Sprintf(replayMain, strconv.Quote(cachePackage), strconv.Quote(logPath), strconv.Quote(dsn))
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(source), 0o644); err != nil {
+		return fmt.Errorf("failed to write replay program: %w", err)
+	}
+
+	cmd := exec.Command("go", "run", "./"+filepath.Base(dir))
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run replay program (is github.com/traP-jp/isuc required in go.mod?): %w", err)
+	}
+	return nil
+}
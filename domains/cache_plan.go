@@ -1,5 +1,7 @@
 package domains
 
+import "time"
+
 type CachePlan struct {
 	Queries []*CachePlanQuery `yaml:"queries"`
 }
@@ -15,6 +17,8 @@ type CachePlanQuery struct {
 type CachePlanQueryBase struct {
 	Query string             `yaml:"query"`
 	Type  CachePlanQueryType `yaml:"type"`
+	// Sources are the locations in the codebase where the query is issued
+	Sources []QuerySource `yaml:"sources,omitempty"`
 }
 
 type CachePlanQueryType string
@@ -29,6 +33,8 @@ const (
 type CachePlanPlaceholder struct {
 	Index int  `yaml:"index"`
 	Extra bool `yaml:"extra,omitempty"`
+	// Name is the name of the named placeholder (:name) used by sqlx
+	Name string `yaml:"name,omitempty"`
 }
 
 type CachePlanCondition struct {
@@ -62,6 +68,20 @@ type CachePlanSelectQuery struct {
 	Targets    []string             `yaml:"targets,omitempty"`
 	Conditions []CachePlanCondition `yaml:"conditions,omitempty"`
 	Orders     []CachePlanOrder     `yaml:"orders,omitempty"`
+	// Limit and Offset are the placeholders of "LIMIT ?" and "OFFSET ?", or nil if the query does not have them
+	Limit    *CachePlanPlaceholder `yaml:"limit,omitempty"`
+	Offset   *CachePlanPlaceholder `yaml:"offset,omitempty"`
+	Estimate *CachePlanEstimate    `yaml:"estimate,omitempty"`
+}
+
+// CachePlanEstimate is the expected effect of caching a select query under the observed workload
+type CachePlanEstimate struct {
+	Reads         int64   `yaml:"reads"`
+	Invalidations int64   `yaml:"invalidations"`
+	HitRatio      float64 `yaml:"hitRatio"`
+	AverageRows   float64 `yaml:"averageRows"`
+	// SavedTime is the total query time expected to be served from the cache
+	SavedTime time.Duration `yaml:"savedTime"`
 }
 
 type CachePlanUpdateTarget struct {
@@ -20,6 +20,7 @@ func (c *CachePlanQuery) UnmarshalYAML(value *yaml.Node) error {
 		if err := value.Decode(&query); err != nil {
 			return fmt.Errorf("failed to decode cache plan select query: %w", err)
 		}
+		query.liftLimit()
 		c.Select = &query
 	case CachePlanQueryType_UPDATE:
 		var query CachePlanUpdateQuery
@@ -48,6 +49,25 @@ func (c *CachePlanQuery) UnmarshalYAML(value *yaml.Node) error {
 
 var _ yaml.Unmarshaler = &CachePlanQuery{}
 
+// liftLimit moves the conditions "LIMIT()" and "OFFSET()" of the plans generated by the older versions to Limit and Offset
+func (q *CachePlanSelectQuery) liftLimit() {
+	conditions := make([]CachePlanCondition, 0, len(q.Conditions))
+	for _, condition := range q.Conditions {
+		placeholder := condition.Placeholder
+		switch condition.Column {
+		case "LIMIT()":
+			q.Limit = &placeholder
+		case "OFFSET()":
+			q.Offset = &placeholder
+		default:
+			conditions = append(conditions, condition)
+		}
+	}
+	if len(conditions) < len(q.Conditions) {
+		q.Conditions = conditions
+	}
+}
+
 func LoadCachePlan(reader io.Reader) (*CachePlan, error) {
 	data, err := io.ReadAll(reader)
 	if err != nil {
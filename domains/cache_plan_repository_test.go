@@ -27,6 +27,25 @@ var formatted = `queries:
     orders:
       - column: created_at
         order: desc
+  - query: SELECT * FROM livecomments WHERE livestream_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?
+    type: select
+    table: livecomments
+    cache: true
+    targets:
+      - id
+      - created_at
+    conditions:
+      - column: livestream_id
+        operator: eq
+        placeholder:
+          index: 0
+    orders:
+      - column: created_at
+        order: desc
+    limit:
+      index: 1
+    offset:
+      index: 2
   - query: SELECT r.emoji_name FROM users u INNER JOIN livestreams l ON l.user_id = u.id INNER JOIN reactions r ON r.livestream_id = l.id WHERE u.name = ? GROUP BY emoji_name ORDER BY COUNT(*) DESC, emoji_name DESC LIMIT ?
     type: select
     cache: false
@@ -108,6 +127,25 @@ var parsed = &CachePlan{
 				},
 			},
 		},
+		{
+			CachePlanQueryBase: &CachePlanQueryBase{
+				Type:  CachePlanQueryType_SELECT,
+				Query: "SELECT * FROM livecomments WHERE livestream_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?",
+			},
+			Select: &CachePlanSelectQuery{
+				Table:   "livecomments",
+				Cache:   true,
+				Targets: []string{"id", "created_at"},
+				Conditions: []CachePlanCondition{
+					{Column: "livestream_id", Operator: CachePlanOperator_EQ, Placeholder: CachePlanPlaceholder{Index: 0}},
+				},
+				Orders: []CachePlanOrder{
+					{Column: "created_at", Order: "desc"},
+				},
+				Limit:  &CachePlanPlaceholder{Index: 1},
+				Offset: &CachePlanPlaceholder{Index: 2},
+			},
+		},
 		{
 			CachePlanQueryBase: &CachePlanQueryBase{
 				Type:  CachePlanQueryType_SELECT,
@@ -199,3 +237,29 @@ func TestSaveCachePlan(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, formatted, writer.String())
 }
+
+func TestLoadCachePlanLimitConditions(t *testing.T) {
+	// the plans generated by the older versions have LIMIT and OFFSET as conditions
+	reader := strings.NewReader(`queries:
+  - query: SELECT * FROM livecomments ORDER BY created_at DESC LIMIT ? OFFSET ?
+    type: select
+    table: livecomments
+    cache: true
+    conditions:
+      - column: OFFSET()
+        operator: eq
+        placeholder:
+          index: 0
+      - column: LIMIT()
+        operator: eq
+        placeholder:
+          index: 0
+          extra: true
+`)
+
+	plan, err := LoadCachePlan(reader)
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Queries[0].Select.Conditions)
+	assert.Equal(t, &CachePlanPlaceholder{Index: 0, Extra: true}, plan.Queries[0].Select.Limit)
+	assert.Equal(t, &CachePlanPlaceholder{Index: 0}, plan.Queries[0].Select.Offset)
+}
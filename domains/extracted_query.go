@@ -0,0 +1,55 @@
+package domains
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// QuerySource is the location in the codebase where a query is issued
+type QuerySource struct {
+	File   string `json:"file" yaml:"file"`
+	Line   int    `json:"line" yaml:"line"`
+	Column int    `json:"column,omitempty" yaml:"column,omitempty"`
+	// Function is the enclosing function or method (e.g. "getUser", "(*Handler).getUser")
+	Function string `json:"function,omitempty" yaml:"function,omitempty"`
+}
+
+func (s QuerySource) String() string {
+	location := fmt.Sprintf("%s:%d", s.File, s.Line)
+	if s.Column > 0 {
+		location += fmt.Sprintf(":%d", s.Column)
+	}
+	if s.Function != "" {
+		location += fmt.Sprintf(" in %s", s.Function)
+	}
+	return location
+}
+
+// ExtractedQuery is a query found by the static extractor
+type ExtractedQuery struct {
+	QuerySource
+	// Raw is the query as written in the code
+	Raw string `json:"raw"`
+	// Query is the normalized query
+	Query string `json:"query"`
+	// Guesses describes the fragments substituted because they could not be determined statically
+	Guesses []string `json:"guesses,omitempty"`
+}
+
+func LoadExtractedQueries(reader io.Reader) ([]ExtractedQuery, error) {
+	var queries []ExtractedQuery
+	if err := json.NewDecoder(reader).Decode(&queries); err != nil {
+		return nil, fmt.Errorf("failed to decode extracted queries: %w", err)
+	}
+	return queries, nil
+}
+
+func SaveExtractedQueries(writer io.Writer, queries []ExtractedQuery) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(queries); err != nil {
+		return fmt.Errorf("failed to encode extracted queries: %w", err)
+	}
+	return nil
+}
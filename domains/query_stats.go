@@ -0,0 +1,50 @@
+package domains
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// QueryStats is the observed workload of a single normalized query
+type QueryStats struct {
+	Query string `json:"query"`
+	// Count is the number of executions
+	Count int64 `json:"count"`
+	// TotalLatency is the sum of the execution time of all executions
+	TotalLatency time.Duration `json:"total_latency"`
+	// Rows is the sum of rows returned (select) or affected (insert, update, delete)
+	Rows int64 `json:"rows"`
+}
+
+func (s QueryStats) AverageLatency() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Count)
+}
+
+func (s QueryStats) AverageRows() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Rows) / float64(s.Count)
+}
+
+func LoadQueryStats(reader io.Reader) ([]QueryStats, error) {
+	var stats []QueryStats
+	if err := json.NewDecoder(reader).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to decode query stats: %w", err)
+	}
+	return stats, nil
+}
+
+func SaveQueryStats(writer io.Writer, stats []QueryStats) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(stats); err != nil {
+		return fmt.Errorf("failed to encode query stats: %w", err)
+	}
+	return nil
+}
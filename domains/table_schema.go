@@ -18,6 +18,10 @@ type TableSchemaColumn struct {
 	IsNullable bool
 	IsPrimary  bool
 	IsUnique   bool
+	// IsAutoIncrement is true if the column is AUTO_INCREMENT
+	IsAutoIncrement bool
+	// HasDefault is true if the column has a DEFAULT value
+	HasDefault bool
 }
 
 type TableSchemaDataType string
@@ -80,6 +80,10 @@ func LoadTableSchema(sql string) ([]TableSchema, error) {
 							column.IsPrimary = columnMatch[j] != ""
 						case "Unique":
 							column.IsUnique = columnMatch[j] != ""
+						case "AutoIncrement":
+							column.IsAutoIncrement = columnMatch[j] != ""
+						case "Default":
+							column.HasDefault = columnMatch[j] != ""
 						}
 					}
 					schema.Columns[column.ColumnName] = column
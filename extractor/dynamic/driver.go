@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"hash"
+	"io"
+	"reflect"
 	"regexp"
-	"strings"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/traP-jp/isuc/normalizer"
@@ -37,19 +40,18 @@ var _ driver.Driver = AnalyzerDriver{}
 
 type AnalyzerConn struct {
 	inner driver.Conn
+	// tx is true while a transaction is running on this connection
+	tx bool
 }
 
 var sqlPattern = regexp.MustCompile(`(?i)\b(SELECT|INSERT|UPDATE|DELETE)\b`)
-var replacePattern = regexp.MustCompile(`\s+`)
 
-func processQuery(query string) {
+func normalizeQuery(query string) (string, bool) {
 	if !sqlPattern.MatchString(query) {
-		return
+		return "", false
 	}
-	query = strings.ReplaceAll(query, "\n", " ")
-	query = replacePattern.ReplaceAllString(query, " ")
 	query = normalizer.NormalizeQuery(query)
-	addQuery(query)
+	return query, true
 }
 
 func (c *AnalyzerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
@@ -57,8 +59,9 @@ func (c *AnalyzerConn) ExecContext(ctx context.Context, query string, args []dri
 	if !ok {
 		return nil, driver.ErrSkip
 	}
-	processQuery(query)
-	return i.ExecContext(ctx, query, args)
+	return recordExec(query, args, c.tx, func() (driver.Result, error) {
+		return i.ExecContext(ctx, query, args)
+	})
 }
 
 func (c *AnalyzerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
@@ -66,12 +69,27 @@ func (c *AnalyzerConn) QueryContext(ctx context.Context, query string, args []dr
 	if !ok {
 		return nil, driver.ErrSkip
 	}
-	processQuery(query)
-	return i.QueryContext(ctx, query, args)
+	return recordQueryRows(query, args, c.tx, func() (driver.Rows, error) {
+		return i.QueryContext(ctx, query, args)
+	})
 }
 
 func (c *AnalyzerConn) Prepare(query string) (driver.Stmt, error) {
-	return c.inner.Prepare(query)
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *AnalyzerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var inner driver.Stmt
+	var err error
+	if i, ok := c.inner.(driver.ConnPrepareContext); ok {
+		inner, err = i.PrepareContext(ctx, query)
+	} else {
+		inner, err = c.inner.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &analyzerStmt{inner: inner, conn: c, query: query}, nil
 }
 
 func (c *AnalyzerConn) Close() error {
@@ -83,12 +101,180 @@ func (c *AnalyzerConn) Begin() (driver.Tx, error) {
 }
 
 func (c *AnalyzerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var inner driver.Tx
+	var err error
 	if i, ok := c.inner.(driver.ConnBeginTx); ok {
-		return i.BeginTx(ctx, opts)
+		inner, err = i.BeginTx(ctx, opts)
+	} else {
+		inner, err = c.inner.Begin()
+	}
+	if err != nil {
+		return nil, err
 	}
-	return c.inner.Begin()
+	c.tx = true
+	return &analyzerTx{inner: inner, conn: c}, nil
 }
 
 var _ driver.Conn = &AnalyzerConn{}
 var _ driver.QueryerContext = &AnalyzerConn{}
 var _ driver.ExecerContext = &AnalyzerConn{}
+var _ driver.ConnPrepareContext = &AnalyzerConn{}
+var _ driver.ConnBeginTx = &AnalyzerConn{}
+
+var _ driver.Tx = &analyzerTx{}
+
+type analyzerTx struct {
+	inner driver.Tx
+	conn  *AnalyzerConn
+}
+
+func (t *analyzerTx) Commit() error {
+	t.conn.tx = false
+	return t.inner.Commit()
+}
+
+func (t *analyzerTx) Rollback() error {
+	t.conn.tx = false
+	return t.inner.Rollback()
+}
+
+// recordExec runs exec and records its execution if query is SQL
+func recordExec(query string, args []driver.NamedValue, inTx bool, run func() (driver.Result, error)) (driver.Result, error) {
+	start := time.Now()
+	res, err := run()
+	if err == driver.ErrSkip {
+		// database/sql retries with a prepared statement
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	if normalized, ok := normalizeQuery(query); ok {
+		exec := execution{start: start, args: args, elapsed: elapsed, err: err, inTx: inTx}
+		if err == nil {
+			exec.rows, _ = res.RowsAffected()
+		}
+		recordQuery(normalized, exec)
+		logWorkload(query, WorkloadKind_EXEC, exec, nil)
+	}
+	return res, err
+}
+
+// recordQueryRows runs query and records its execution if query is SQL.
+// Rows are counted while the caller reads them and recorded on Close.
+func recordQueryRows(query string, args []driver.NamedValue, inTx bool, run func() (driver.Rows, error)) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := run()
+	if err == driver.ErrSkip {
+		// database/sql retries with a prepared statement
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	normalized, ok := normalizeQuery(query)
+	if !ok {
+		return rows, err
+	}
+	exec := execution{start: start, args: args, elapsed: elapsed, err: err, inTx: inTx}
+	if err != nil {
+		recordQuery(normalized, exec)
+		logWorkload(query, WorkloadKind_QUERY, exec, nil)
+		return nil, err
+	}
+	r := &countingRows{Rows: rows, rawQuery: query, query: normalized, exec: exec}
+	if workloadEnabled() {
+		r.checksum = newChecksum()
+	}
+	return r, nil
+}
+
+// countingRows counts the rows read by the caller
+type countingRows struct {
+	driver.Rows
+	rawQuery string
+	query    string
+	exec     execution
+	// checksum of the rows read, only computed when the workload log is enabled
+	checksum hash.Hash64
+	closed   bool
+}
+
+func (r *countingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == nil {
+		r.exec.rows++
+		if r.checksum != nil {
+			writeChecksum(r.checksum, dest)
+		}
+	} else if err != io.EOF {
+		r.exec.err = err
+	}
+	return err
+}
+
+func (r *countingRows) Close() error {
+	if !r.closed {
+		r.closed = true
+		recordQuery(r.query, r.exec)
+		logWorkload(r.rawQuery, WorkloadKind_QUERY, r.exec, r.checksum)
+	}
+	return r.Rows.Close()
+}
+
+// the methods below forward the optional interfaces of the inner rows
+
+func (r *countingRows) HasNextResultSet() bool {
+	if i, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return i.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *countingRows) NextResultSet() error {
+	if i, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return i.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *countingRows) ColumnTypeScanType(index int) reflect.Type {
+	if i, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return i.ColumnTypeScanType(index)
+	}
+	return reflect.TypeFor[any]()
+}
+
+func (r *countingRows) ColumnTypeDatabaseTypeName(index int) string {
+	if i, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return i.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *countingRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if i, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return i.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *countingRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if i, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return i.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *countingRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if i, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return i.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+var (
+	_ driver.Rows                           = &countingRows{}
+	_ driver.RowsNextResultSet              = &countingRows{}
+	_ driver.RowsColumnTypeScanType         = &countingRows{}
+	_ driver.RowsColumnTypeDatabaseTypeName = &countingRows{}
+	_ driver.RowsColumnTypeNullable         = &countingRows{}
+	_ driver.RowsColumnTypeLength           = &countingRows{}
+	_ driver.RowsColumnTypePrecisionScale   = &countingRows{}
+)
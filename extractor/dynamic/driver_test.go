@@ -41,4 +41,51 @@ func TestExtract(t *testing.T) {
 		`SELECT * FROM users;`,
 	}
 	assert.ElementsMatch(t, expected, queries)
+
+	stats := getQueryStats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "INSERT INTO users (id, name) VALUES (1, 'Alice');", stats[0].Query)
+	assert.Equal(t, int64(1), stats[0].Count)
+	assert.Equal(t, int64(1), stats[0].Rows)
+	assert.Equal(t, "SELECT * FROM users;", stats[1].Query)
+	assert.Equal(t, int64(1), stats[1].Count)
+	assert.Equal(t, int64(1), stats[1].Rows)
+	assert.Equal(t, int64(0), stats[1].Errors)
+}
+
+func TestExtractPreparedAndTransaction(t *testing.T) {
+	db := dbtest.SetupMysqlDB(t, "mysql+analyzer")
+	defer db.Close()
+	// keep the database selected by SetupMysqlDB
+	db.SetMaxOpenConns(1)
+
+	deleteAllQueries()
+
+	_, err := db.Exec(`CREATE TABLE users (id INT, name VARCHAR(255))`)
+	assert.NoError(t, err)
+
+	// with interpolateParams=false, queries with args are run as prepared statements
+	_, err = db.Exec(`INSERT INTO users (id, name) VALUES (?, ?)`, 1, "Alice")
+	assert.NoError(t, err)
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	stmt, err := tx.Prepare(`SELECT name FROM users WHERE id = ?`)
+	assert.NoError(t, err)
+	var name string
+	err = stmt.QueryRow(1).Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", name)
+	assert.NoError(t, stmt.Close())
+	assert.NoError(t, tx.Commit())
+
+	stats := getQueryStats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "INSERT INTO users (id, name) VALUES (?);", stats[0].Query)
+	assert.Equal(t, int64(1), stats[0].Count)
+	assert.Equal(t, int64(0), stats[0].InTransaction)
+	assert.Equal(t, [][]interface{}{{int64(1), "Alice"}}, stats[0].Args)
+	assert.Equal(t, "SELECT name FROM users WHERE id = ?;", stats[1].Query)
+	assert.Equal(t, int64(1), stats[1].Count)
+	assert.Equal(t, int64(1), stats[1].InTransaction)
 }
@@ -0,0 +1,181 @@
+package dynamic_extractor
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReplayResult is the result of replaying a single workload entry
+type ReplayResult struct {
+	Entry    WorkloadEntry
+	Duration time.Duration
+	Rows     int64
+	Checksum string
+	Err      error
+}
+
+// Mismatch reports whether the replayed result differs from the recorded one
+func (r ReplayResult) Mismatch() bool {
+	if (r.Err != nil) != (r.Entry.Error != "") {
+		return true
+	}
+	if r.Err != nil {
+		return false
+	}
+	if r.Rows != r.Entry.Rows {
+		return true
+	}
+	return r.Entry.Checksum != "" && r.Checksum != r.Entry.Checksum
+}
+
+// Replay runs the entries one by one in the recorded order.
+// Entries recorded inside transactions are replayed outside of them because the log does not keep transaction boundaries.
+func Replay(ctx context.Context, db *sql.DB, entries []WorkloadEntry) []ReplayResult {
+	results := make([]ReplayResult, 0, len(entries))
+	for _, entry := range entries {
+		var result ReplayResult
+		switch entry.Kind {
+		case WorkloadKind_QUERY:
+			result = replayQuery(ctx, db, entry)
+		default:
+			result = replayExec(ctx, db, entry)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func replayExec(ctx context.Context, db *sql.DB, entry WorkloadEntry) ReplayResult {
+	start := time.Now()
+	res, err := db.ExecContext(ctx, entry.Query, entry.Args...)
+	result := ReplayResult{Entry: entry, Duration: time.Since(start), Err: err}
+	if err == nil {
+		result.Rows, _ = res.RowsAffected()
+	}
+	return result
+}
+
+func replayQuery(ctx context.Context, db *sql.DB, entry WorkloadEntry) ReplayResult {
+	start := time.Now()
+	result := ReplayResult{Entry: entry}
+	rows, err := db.QueryContext(ctx, entry.Query, entry.Args...)
+	if err != nil {
+		result.Duration = time.Since(start)
+		result.Err = err
+		return result
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		result.Duration = time.Since(start)
+		result.Err = err
+		return result
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	row := make([]driver.Value, len(columns))
+	checksum := newChecksum()
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			result.Err = err
+			break
+		}
+		for i, v := range values {
+			row[i] = v
+		}
+		writeChecksum(checksum, row)
+		result.Rows++
+	}
+	if err := rows.Err(); err != nil && result.Err == nil {
+		result.Err = err
+	}
+	result.Duration = time.Since(start)
+	result.Checksum = fmt.Sprintf("%016x", checksum.Sum64())
+	return result
+}
+
+// ReplayQueryReport is the summary of the replayed executions of a normalized query
+type ReplayQueryReport struct {
+	Query        string
+	Count        int
+	RecordedTime time.Duration
+	ReplayedTime time.Duration
+	Mismatches   int
+	Errors       int
+}
+
+func NewReplayReport(results []ReplayResult) []ReplayQueryReport {
+	byQuery := make(map[string]*ReplayQueryReport)
+	for _, result := range results {
+		query, ok := normalizeQuery(result.Entry.Query)
+		if !ok {
+			query = result.Entry.Query
+		}
+		report, ok := byQuery[query]
+		if !ok {
+			report = &ReplayQueryReport{Query: query}
+			byQuery[query] = report
+		}
+		report.Count++
+		report.RecordedTime += result.Entry.Duration
+		report.ReplayedTime += result.Duration
+		if result.Mismatch() {
+			report.Mismatches++
+		}
+		if result.Err != nil {
+			report.Errors++
+		}
+	}
+
+	reports := make([]ReplayQueryReport, 0, len(byQuery))
+	for _, report := range byQuery {
+		reports = append(reports, *report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].ReplayedTime > reports[j].ReplayedTime
+	})
+	return reports
+}
+
+func WriteReplayReport(w io.Writer, reports []ReplayQueryReport) error {
+	var b strings.Builder
+	var recorded, replayed time.Duration
+	var mismatches int
+	for _, report := range reports {
+		fmt.Fprintf(&b, "query: \"%s\"\n", report.Query)
+		fmt.Fprintf(&b, "%d executions / recorded %s / replayed %s / %d mismatches / %d errors\n\n",
+			report.Count, report.RecordedTime, report.ReplayedTime, report.Mismatches, report.Errors)
+		recorded += report.RecordedTime
+		replayed += report.ReplayedTime
+		mismatches += report.Mismatches
+	}
+	fmt.Fprintf(&b, "total: recorded %s / replayed %s / %d mismatches\n", recorded, replayed, mismatches)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// RunReplay replays the workload log read from reader against dsn through driverName and writes the report to w
+func RunReplay(ctx context.Context, driverName string, dsn string, reader io.Reader, w io.Writer) error {
+	entries, err := LoadWorkload(reader)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	results := Replay(ctx, db, entries)
+	return WriteReplayReport(w, NewReplayReport(results))
+}
@@ -1,15 +1,140 @@
 package dynamic_extractor
 
-import "sync"
+import (
+	"database/sql/driver"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"sync"
+	"time"
 
-var queries sync.Map
+	"github.com/traP-jp/isuc/domains"
+)
+
+const (
+	// maxLatencySamples is the size of the reservoir used to compute latency percentiles
+	maxLatencySamples = 1000
+	// maxArgSamples is the number of argument tuples kept for each query
+	maxArgSamples = 10
+)
+
+// QueryStats is the workload of a normalized query observed by the extractor.
+// It embeds domains.QueryStats so that the JSON can be passed to `isuc analyze --stats` as is.
+type QueryStats struct {
+	domains.QueryStats
+	Errors int64 `json:"errors"`
+	// InTransaction is the number of executions inside a transaction
+	InTransaction int64           `json:"in_transaction"`
+	P50Latency    time.Duration   `json:"p50_latency"`
+	P90Latency    time.Duration   `json:"p90_latency"`
+	P99Latency    time.Duration   `json:"p99_latency"`
+	Args          [][]interface{} `json:"args"`
+}
+
+type queryRecord struct {
+	mu           sync.Mutex
+	count        int64
+	errors       int64
+	inTx         int64
+	totalLatency time.Duration
+	rows         int64
+	latencies    []time.Duration
+	args         [][]interface{}
+}
+
+var queries sync.Map // string -> *queryRecord
 
 func deleteAllQueries() {
 	queries.Clear()
 }
 
-func addQuery(query string) {
-	queries.Store(query, struct{}{})
+// execution is a single run of a query observed by the extractor
+type execution struct {
+	start   time.Time
+	args    []driver.NamedValue
+	elapsed time.Duration
+	// rows returned or affected
+	rows int64
+	err  error
+	inTx bool
+}
+
+func recordQuery(query string, exec execution) {
+	v, _ := queries.LoadOrStore(query, &queryRecord{})
+	record := v.(*queryRecord)
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	record.count++
+	record.totalLatency += exec.elapsed
+	record.rows += exec.rows
+	if exec.err != nil {
+		record.errors++
+	}
+	if exec.inTx {
+		record.inTx++
+	}
+	// reservoir sampling keeps a uniform sample of all executions
+	if len(record.latencies) < maxLatencySamples {
+		record.latencies = append(record.latencies, exec.elapsed)
+	} else if i := rand.Int64N(record.count); i < maxLatencySamples {
+		record.latencies[i] = exec.elapsed
+	}
+	if len(exec.args) > 0 {
+		if len(record.args) < maxArgSamples {
+			record.args = append(record.args, sampleArgs(exec.args))
+		} else if i := rand.Int64N(record.count); i < maxArgSamples {
+			record.args[i] = sampleArgs(exec.args)
+		}
+	}
+}
+
+func sampleArgs(args []driver.NamedValue) []interface{} {
+	sample := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.Value.(type) {
+		case []byte:
+			// keep bytes readable, encoding/json would encode them in base64
+			sample[i] = string(v)
+		case time.Time:
+			// the format MySQL accepts as a DATETIME literal so that the args can be replayed
+			sample[i] = v.UTC().Format("2006-01-02 15:04:05.999999")
+		default:
+			sample[i] = v
+		}
+	}
+	return sample
+}
+
+func (r *queryRecord) stats(query string) QueryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	latencies := slices.Clone(r.latencies)
+	slices.Sort(latencies)
+	return QueryStats{
+		QueryStats: domains.QueryStats{
+			Query:        query,
+			Count:        r.count,
+			TotalLatency: r.totalLatency,
+			Rows:         r.rows,
+		},
+		Errors:        r.errors,
+		InTransaction: r.inTx,
+		P50Latency:    percentile(latencies, 0.50),
+		P90Latency:    percentile(latencies, 0.90),
+		P99Latency:    percentile(latencies, 0.99),
+		Args:          slices.Clone(r.args),
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
 }
 
 func getQueries() []string {
@@ -19,3 +144,14 @@ func getQueries() []string {
 	}
 	return ret
 }
+
+func getQueryStats() []QueryStats {
+	ret := make([]QueryStats, 0)
+	for query, record := range queries.Range {
+		ret = append(ret, record.(*queryRecord).stats(query.(string)))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Query < ret[j].Query
+	})
+	return ret
+}
@@ -1,28 +1,93 @@
 package dynamic_extractor
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 )
 
-func StartServer() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		res := ""
-		queries := getQueries()
-		for _, query := range queries {
-			res += query + "\n"
+type Server struct {
+	server *http.Server
+}
+
+// NewServer creates a server which exposes the extracted queries on addr
+//
+//   - GET /              : the queries, one per line
+//   - GET /extracted.sql : the queries in the format read by `isuc analyze --sql`
+//   - GET /stats         : the stats of each query as JSON, read by `isuc analyze --stats`
+//   - POST /reset        : deletes all the extracted queries
+func NewServer(addr string) *Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", handleQueries)
+	mux.HandleFunc("GET /extracted.sql", handleExtractedSQL)
+	mux.HandleFunc("GET /stats", handleStats)
+	mux.HandleFunc("POST /reset", handleReset)
+	return &Server{server: &http.Server{Addr: addr, Handler: mux}}
+}
+
+// Start starts the server in the background
+func (s *Server) Start() {
+	go func() {
+		err := s.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
 		}
-		w.Write([]byte(res))
-		w.WriteHeader(http.StatusOK)
-	})
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
 
+// StartServer starts the server on the port EXTRACTOR_PORT (39393 by default)
+func StartServer() *Server {
 	port := os.Getenv("EXTRACTOR_PORT")
 	if port == "" {
 		port = "39393"
 	}
 
-	go func() {
-		log.Fatal(http.ListenAndServe(":"+port, nil))
-	}()
+	s := NewServer(":" + port)
+	s.Start()
+	return s
+}
+
+func handleQueries(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, query := range getQueries() {
+		b.WriteString(query + "\n")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
+
+func handleExtractedSQL(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, stats := range getQueryStats() {
+		b.WriteString(stats.Query)
+		if !strings.HasSuffix(stats.Query, ";") {
+			b.WriteString(";")
+		}
+		b.WriteString("\n")
+	}
+	w.Header().Set("Content-Type", "application/sql; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(getQueryStats()); err != nil {
+		log.Println("failed to encode query stats:", err)
+	}
+}
+
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	deleteAllQueries()
+	w.WriteHeader(http.StatusNoContent)
 }
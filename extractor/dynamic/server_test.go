@@ -0,0 +1,48 @@
+package dynamic_extractor
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	deleteAllQueries()
+	recordQuery("SELECT * FROM users WHERE id = ?;", execution{
+		args:    []driver.NamedValue{{Ordinal: 1, Value: int64(1)}},
+		elapsed: time.Millisecond,
+		rows:    1,
+	})
+
+	s := NewServer(":0")
+	ts := httptest.NewServer(s.server.Handler)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/extracted.sql")
+	assert.NoError(t, err)
+	body := make([]byte, 1024)
+	n, _ := res.Body.Read(body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?;\n", string(body[:n]))
+
+	res, err = http.Get(ts.URL + "/stats")
+	assert.NoError(t, err)
+	var stats []QueryStats
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+	res.Body.Close()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, int64(1), stats[0].Count)
+	assert.Equal(t, time.Millisecond, stats[0].TotalLatency)
+
+	res, err = http.Post(ts.URL+"/reset", "", nil)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Empty(t, getQueries())
+}
@@ -0,0 +1,78 @@
+package dynamic_extractor
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+var (
+	_ driver.Stmt              = &analyzerStmt{}
+	_ driver.StmtExecContext   = &analyzerStmt{}
+	_ driver.StmtQueryContext  = &analyzerStmt{}
+	_ driver.NamedValueChecker = &analyzerStmt{}
+)
+
+// analyzerStmt records the executions of a prepared statement
+type analyzerStmt struct {
+	inner driver.Stmt
+	conn  *AnalyzerConn
+	query string
+}
+
+func (s *analyzerStmt) Close() error {
+	return s.inner.Close()
+}
+
+func (s *analyzerStmt) NumInput() int {
+	return s.inner.NumInput()
+}
+
+func (s *analyzerStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valueToNamedValue(args))
+}
+
+func (s *analyzerStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valueToNamedValue(args))
+}
+
+func (s *analyzerStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return recordExec(s.query, args, s.conn.tx, func() (driver.Result, error) {
+		if i, ok := s.inner.(driver.StmtExecContext); ok {
+			return i.ExecContext(ctx, args)
+		}
+		return s.inner.Exec(namedValueToValue(args))
+	})
+}
+
+func (s *analyzerStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return recordQueryRows(s.query, args, s.conn.tx, func() (driver.Rows, error) {
+		if i, ok := s.inner.(driver.StmtQueryContext); ok {
+			return i.QueryContext(ctx, args)
+		}
+		return s.inner.Query(namedValueToValue(args))
+	})
+}
+
+// CheckNamedValue lets the inner driver convert the arguments as it does without the extractor
+func (s *analyzerStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if i, ok := s.inner.(driver.NamedValueChecker); ok {
+		return i.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func valueToNamedValue(args []driver.Value) []driver.NamedValue {
+	nvargs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		nvargs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return nvargs
+}
+
+func namedValueToValue(nvargs []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(nvargs))
+	for i, nv := range nvargs {
+		args[i] = nv.Value
+	}
+	return args
+}
@@ -0,0 +1,187 @@
+package dynamic_extractor
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WorkloadEntry is a single execution written to the workload log
+type WorkloadEntry struct {
+	Time time.Time `json:"time"`
+	// Query is the query as executed (not normalized) so that it can be replayed
+	Query    string        `json:"query"`
+	Kind     WorkloadKind  `json:"kind"`
+	Args     []interface{} `json:"args,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Rows     int64         `json:"rows"`
+	Checksum string        `json:"checksum,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	InTx     bool          `json:"in_transaction,omitempty"`
+}
+
+type WorkloadKind string
+
+const (
+	WorkloadKind_QUERY WorkloadKind = "query"
+	WorkloadKind_EXEC  WorkloadKind = "exec"
+)
+
+type workloadLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+var workload atomic.Pointer[workloadLog]
+
+func init() {
+	if path := os.Getenv("EXTRACTOR_WORKLOAD_LOG"); path != "" {
+		if err := EnableWorkloadLog(path); err != nil {
+			log.Println("failed to enable workload log:", err)
+		}
+	}
+}
+
+// EnableWorkloadLog appends every captured execution to the JSONL file at path.
+// Each entry is written as soon as the execution finishes, so the log survives the process being killed.
+func EnableWorkloadLog(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open workload log: %w", err)
+	}
+	old := workload.Swap(&workloadLog{file: file, encoder: json.NewEncoder(file)})
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+func DisableWorkloadLog() error {
+	old := workload.Swap(nil)
+	if old == nil {
+		return nil
+	}
+	return old.close()
+}
+
+func (l *workloadLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (l *workloadLog) write(entry WorkloadEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.encoder.Encode(entry); err != nil {
+		log.Println("failed to write workload log:", err)
+	}
+}
+
+func workloadEnabled() bool {
+	return workload.Load() != nil
+}
+
+func logWorkload(query string, kind WorkloadKind, exec execution, checksum hash.Hash64) {
+	l := workload.Load()
+	if l == nil {
+		return
+	}
+	entry := WorkloadEntry{
+		Time:     exec.start,
+		Query:    query,
+		Kind:     kind,
+		Args:     sampleArgs(exec.args),
+		Duration: exec.elapsed,
+		Rows:     exec.rows,
+		InTx:     exec.inTx,
+	}
+	if checksum != nil {
+		entry.Checksum = fmt.Sprintf("%016x", checksum.Sum64())
+	}
+	if exec.err != nil {
+		entry.Error = exec.err.Error()
+	}
+	l.write(entry)
+}
+
+func newChecksum() hash.Hash64 {
+	return fnv.New64a()
+}
+
+// writeChecksum adds a row to the checksum of the result set
+func writeChecksum(h hash.Hash64, row []driver.Value) {
+	for _, v := range row {
+		switch v := v.(type) {
+		case nil:
+			h.Write([]byte("\x00NULL"))
+		case []byte:
+			h.Write(v)
+		case string:
+			h.Write([]byte(v))
+		case time.Time:
+			h.Write([]byte(v.UTC().Format(time.RFC3339Nano)))
+		default:
+			fmt.Fprint(h, v)
+		}
+		// unit separator
+		h.Write([]byte{0x1f})
+	}
+	// record separator
+	h.Write([]byte{0x1e})
+}
+
+// LoadWorkload reads the workload log written by EnableWorkloadLog.
+// The last line is skipped with a warning if it cannot be decoded,
+// since it may be truncated when the process was killed while writing it.
+func LoadWorkload(reader io.Reader) ([]WorkloadEntry, error) {
+	var entries []WorkloadEntry
+	// truncated is the error of the line which cannot be decoded, which must be the last one
+	var truncated error
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if truncated != nil {
+			return entries, truncated
+		}
+		var entry WorkloadEntry
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		// keep integers as they are, float64 cannot represent large ids
+		decoder.UseNumber()
+		if err := decoder.Decode(&entry); err != nil {
+			truncated = fmt.Errorf("failed to decode workload log at line %d: %w", line, err)
+			continue
+		}
+		for i, arg := range entry.Args {
+			if n, ok := arg.(json.Number); ok {
+				if v, err := n.Int64(); err == nil {
+					entry.Args[i] = v
+				} else if v, err := n.Float64(); err == nil {
+					entry.Args[i] = v
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("failed to read workload log: %w", err)
+	}
+	if truncated != nil {
+		log.Println("skipped the truncated last line:", truncated)
+	}
+	return entries, nil
+}
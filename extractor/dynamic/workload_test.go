@@ -0,0 +1,70 @@
+package dynamic_extractor
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkloadLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workload.jsonl")
+	assert.NoError(t, EnableWorkloadLog(path))
+
+	checksum := newChecksum()
+	writeChecksum(checksum, []driver.Value{int64(1), []byte("alice"), nil})
+	logWorkload("SELECT * FROM users WHERE id = ?", WorkloadKind_QUERY, execution{
+		start:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		args:    []driver.NamedValue{{Ordinal: 1, Value: int64(9007199254740993)}},
+		elapsed: time.Millisecond,
+		rows:    1,
+	}, checksum)
+	logWorkload("DELETE FROM users WHERE id = 1", WorkloadKind_EXEC, execution{
+		inTx: true,
+		rows: 1,
+	}, nil)
+	assert.NoError(t, DisableWorkloadLog())
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	entries, err := LoadWorkload(file)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", entries[0].Query)
+	assert.Equal(t, WorkloadKind_QUERY, entries[0].Kind)
+	assert.Equal(t, []interface{}{int64(9007199254740993)}, entries[0].Args)
+	assert.Equal(t, time.Millisecond, entries[0].Duration)
+	assert.NotEmpty(t, entries[0].Checksum)
+
+	assert.Equal(t, WorkloadKind_EXEC, entries[1].Kind)
+	assert.True(t, entries[1].InTx)
+	assert.Empty(t, entries[1].Checksum)
+
+	replayed := newChecksum()
+	writeChecksum(replayed, []driver.Value{int64(1), "alice", nil})
+	result := ReplayResult{Entry: entries[0], Rows: 1, Checksum: fmt.Sprintf("%016x", replayed.Sum64())}
+	assert.False(t, result.Mismatch())
+	result.Rows = 2
+	assert.True(t, result.Mismatch())
+}
+
+func TestLoadWorkloadTruncated(t *testing.T) {
+	entry := `{"query":"SELECT * FROM users WHERE id = ?","kind":"query","args":[1]}`
+
+	// the record being written when the process was killed
+	entries, err := LoadWorkload(strings.NewReader(entry + "\n" + entry + "\n" + `{"query":"SELECT * FR`))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, []interface{}{int64(1)}, entries[1].Args)
+
+	// a corrupt record in the middle
+	_, err = LoadWorkload(strings.NewReader(entry + "\n" + `{"query":"SELECT * FR` + "\n" + entry + "\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
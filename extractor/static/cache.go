@@ -0,0 +1,192 @@
+package static_extractor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/traP-jp/isuc/domains"
+	"golang.org/x/tools/go/packages"
+)
+
+// extractCacheVersion must be incremented whenever the extraction result for the same source changes
+const extractCacheVersion = 2
+
+// extractCache holds the extraction results of each package keyed by the content hash of its files
+// and of the packages it imports, so that a change of a constant in another package is detected.
+type extractCache struct {
+	Version int `json:"version"`
+	// Packages is keyed by the directory of the package relative to the root
+	Packages map[string]*cachedPackage `json:"packages"`
+}
+
+type cachedPackage struct {
+	Hash  string       `json:"hash"`
+	Files []cachedFile `json:"files"`
+}
+
+type cachedFile struct {
+	File    string                   `json:"file"`
+	Queries []domains.ExtractedQuery `json:"queries"`
+	Err     string                   `json:"error,omitempty"`
+}
+
+// loadExtractCache returns an empty cache if the file does not exist or is broken
+func loadExtractCache(path string) *extractCache {
+	empty := &extractCache{Version: extractCacheVersion, Packages: make(map[string]*cachedPackage)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return empty
+	}
+	var cache extractCache
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Version != extractCacheVersion || cache.Packages == nil {
+		return empty
+	}
+	return &cache
+}
+
+func (c *extractCache) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating cache directory: %v", err)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error encoding cache: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing cache: %v", err)
+	}
+	return nil
+}
+
+func (p *cachedPackage) results() []*FileResult {
+	results := make([]*FileResult, 0, len(p.Files))
+	for _, file := range p.Files {
+		result := &FileResult{File: file.File, Cached: true}
+		for _, query := range file.Queries {
+			result.Queries = append(result.Queries, importExtractedQuery(query))
+		}
+		if file.Err != "" {
+			result.Err = errors.New(file.Err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func newCachedPackage(hash string, results []*FileResult) *cachedPackage {
+	p := &cachedPackage{Hash: hash}
+	for _, result := range results {
+		file := cachedFile{File: result.File}
+		for _, query := range result.Queries {
+			file.Queries = append(file.Queries, query.Export())
+		}
+		if result.Err != nil {
+			file.Err = result.Err.Error()
+		}
+		p.Files = append(p.Files, file)
+	}
+	return p
+}
+
+// hashPackage hashes the contents of the files of a package together with the build tags
+// and the hash of its dependencies
+func hashPackage(root string, files []string, tags []string, deps string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00", extractCacheVersion, strings.Join(tags, ","), deps)
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		if err != nil {
+			return "", fmt.Errorf("error reading file: %v", err)
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(file), len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dependencyHashes hashes the packages imported by each package in root transitively, keyed by the directory relative to root.
+// The packages of the main module and of the modules replaced by a local directory are hashed by the contents of their files,
+// and the other packages by the versions of their modules.
+func dependencyHashes(root string, tags []string) (map[string]string, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+		Dir:  root,
+	}
+	if len(tags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(tags, ",")}
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil, fmt.Errorf("error listing packages: %v", err)
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path: %v", err)
+	}
+
+	hashes := make(map[*packages.Package]string)
+	var hashImports func(pkg *packages.Package) (string, error)
+	// hashDependency hashes a package together with its imports
+	hashDependency := func(pkg *packages.Package) (string, error) {
+		if hash, ok := hashes[pkg]; ok {
+			return hash, nil
+		}
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00", pkg.PkgPath)
+		if mod := pkg.Module; mod != nil {
+			if mod.Replace != nil {
+				mod = mod.Replace
+			}
+			if mod.Version != "" {
+				fmt.Fprintf(h, "%s@%s\x00", mod.Path, mod.Version)
+			} else {
+				for _, file := range pkg.GoFiles {
+					data, err := os.ReadFile(file)
+					if err != nil {
+						return "", fmt.Errorf("error reading file: %v", err)
+					}
+					fmt.Fprintf(h, "%s\x00%d\x00", filepath.Base(file), len(data))
+					h.Write(data)
+				}
+			}
+		}
+		imports, err := hashImports(pkg)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(imports))
+		hashes[pkg] = hex.EncodeToString(h.Sum(nil))
+		return hashes[pkg], nil
+	}
+	hashImports = func(pkg *packages.Package) (string, error) {
+		h := sha256.New()
+		for _, path := range slices.Sorted(maps.Keys(pkg.Imports)) {
+			hash, err := hashDependency(pkg.Imports[path])
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00", path, hash)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	res := make(map[string]string)
+	for _, pkg := range pkgs {
+		dir, err := filepath.Rel(absRoot, pkg.Dir)
+		if err != nil {
+			continue
+		}
+		if res[dir], err = hashImports(pkg); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
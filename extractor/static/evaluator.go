@@ -0,0 +1,632 @@
+package static_extractor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// maxCandidates limits the number of queries a single argument can be expanded to
+const maxCandidates = 64
+
+// candidate is one of the possible values of an expression
+type candidate struct {
+	value string
+	// guesses describes the fragments which could not be determined statically and were substituted
+	guesses []string
+}
+
+func (c candidate) concat(value string, guesses ...string) candidate {
+	result := candidate{value: c.value + value}
+	if len(c.guesses)+len(guesses) > 0 {
+		result.guesses = append(append([]string{}, c.guesses...), guesses...)
+	}
+	return result
+}
+
+// appendOp is a write to a strings.Builder / bytes.Buffer or a += to a string variable
+type appendOp struct {
+	pos token.Pos
+	// expr is the written string, or the fmt.Fprintf call when format is true
+	expr   ast.Expr
+	format bool
+	// ancestors are the nodes enclosing the write, outermost first
+	ancestors []ast.Node
+}
+
+type evaluator struct {
+	pkg *packages.Package
+	// assigns holds every expression assigned to each variable in the package
+	assigns map[*types.Var][]ast.Expr
+	// appends holds the writes to each string / builder variable in the source order
+	appends  map[*types.Var][]appendOp
+	visiting map[*types.Var]bool
+}
+
+func newEvaluator(pkg *packages.Package) *evaluator {
+	e := &evaluator{
+		pkg:      pkg,
+		assigns:  make(map[*types.Var][]ast.Expr),
+		appends:  make(map[*types.Var][]appendOp),
+		visiting: make(map[*types.Var]bool),
+	}
+	for _, file := range pkg.Syntax {
+		var stack []ast.Node
+		ast.Inspect(file, func(n ast.Node) bool {
+			if n == nil {
+				stack = stack[:len(stack)-1]
+				return true
+			}
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				switch n.Tok {
+				case token.DEFINE, token.ASSIGN:
+					e.collect(n.Lhs, n.Rhs)
+				case token.ADD_ASSIGN:
+					if v := e.varOf(n.Lhs[0]); v != nil {
+						e.addAppend(v, appendOp{pos: n.Pos(), expr: n.Rhs[0]}, stack)
+					}
+				}
+			case *ast.ValueSpec:
+				lhs := make([]ast.Expr, len(n.Names))
+				for i, name := range n.Names {
+					lhs[i] = name
+				}
+				e.collect(lhs, n.Values)
+			case *ast.CallExpr:
+				e.collectWrite(n, stack)
+			}
+			stack = append(stack, n)
+			return true
+		})
+	}
+	return e
+}
+
+func (e *evaluator) varOf(expr ast.Expr) *types.Var {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		v, _ := e.pkg.TypesInfo.ObjectOf(expr).(*types.Var)
+		return v
+	case *ast.UnaryExpr:
+		// &b
+		if expr.Op == token.AND {
+			return e.varOf(expr.X)
+		}
+	case *ast.ParenExpr:
+		return e.varOf(expr.X)
+	}
+	return nil
+}
+
+func (e *evaluator) collect(lhs []ast.Expr, rhs []ast.Expr) {
+	for i, l := range lhs {
+		ident, ok := l.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		v, ok := e.pkg.TypesInfo.ObjectOf(ident).(*types.Var)
+		if !ok {
+			continue
+		}
+		switch {
+		case len(lhs) == len(rhs):
+			e.assigns[v] = append(e.assigns[v], rhs[i])
+		case len(rhs) == 1 && i == 0:
+			// query, args, err := sqlx.In(...)
+			e.assigns[v] = append(e.assigns[v], rhs[0])
+		}
+	}
+}
+
+// collectWrite records b.WriteString(...), b.WriteByte(...), b.WriteRune(...) and fmt.Fprintf(&b, ...)
+func (e *evaluator) collectWrite(call *ast.CallExpr, stack []ast.Node) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	fn, ok := e.pkg.TypesInfo.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return
+	}
+	switch {
+	case fn.Pkg().Path() == "fmt" && fn.Name() == "Fprintf" && len(call.Args) >= 2:
+		if v := e.varOf(call.Args[0]); v != nil && isBuilder(v.Type()) {
+			e.addAppend(v, appendOp{pos: call.Pos(), expr: call, format: true}, stack)
+		}
+	case (fn.Pkg().Path() == "strings" || fn.Pkg().Path() == "bytes") && len(call.Args) == 1:
+		switch fn.Name() {
+		case "WriteString", "WriteByte", "WriteRune":
+			if v := e.varOf(sel.X); v != nil && isBuilder(v.Type()) {
+				e.addAppend(v, appendOp{pos: call.Pos(), expr: call.Args[0]}, stack)
+			}
+		}
+	}
+}
+
+func (e *evaluator) addAppend(v *types.Var, op appendOp, stack []ast.Node) {
+	op.ancestors = append([]ast.Node{}, stack...)
+	e.appends[v] = append(e.appends[v], op)
+}
+
+func isBuilder(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return false
+	}
+	path, name := named.Obj().Pkg().Path(), named.Obj().Name()
+	return path == "strings" && name == "Builder" || path == "bytes" && name == "Buffer"
+}
+
+// queryArgIndex returns the index of the query argument of call (-1 if call does not receive a query)
+// and whether the call sends the query to the database rather than returning a rewritten query
+func (e *evaluator) queryArgIndex(call *ast.CallExpr) (int, bool) {
+	fn := e.funcOf(call)
+	if fn == nil {
+		return -1, false
+	}
+	if _, ok := queryPackages[fn.Pkg().Path()]; !ok {
+		return -1, false
+	}
+	sig := fn.Type().(*types.Signature)
+	for i := 0; i < sig.Params().Len(); i++ {
+		param := sig.Params().At(i)
+		if param.Name() != "query" || !types.Identical(param.Type(), types.Typ[types.String]) {
+			continue
+		}
+		if i >= len(call.Args) {
+			return -1, false
+		}
+		// Rebind, In, Named, ... return the rewritten query
+		returnsQuery := sig.Results().Len() > 0 && types.Identical(sig.Results().At(0).Type(), types.Typ[types.String])
+		return i, !returnsQuery
+	}
+	return -1, false
+}
+
+func (e *evaluator) funcOf(call *ast.CallExpr) *types.Func {
+	var ident *ast.Ident
+	switch fun := call.Fun.(type) {
+	case *ast.SelectorExpr:
+		ident = fun.Sel
+	case *ast.Ident:
+		ident = fun
+	default:
+		return nil
+	}
+	fn, ok := e.pkg.TypesInfo.Uses[ident].(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return nil
+	}
+	return fn
+}
+
+// eval returns the possible values of expr, or false if it cannot be determined statically
+func (e *evaluator) eval(expr ast.Expr) ([]candidate, bool) {
+	if tv, ok := e.pkg.TypesInfo.Types[expr]; ok && tv.Value != nil {
+		if tv.Value.Kind() != constant.String {
+			return nil, false
+		}
+		return []candidate{{value: constant.StringVal(tv.Value)}}, true
+	}
+
+	switch expr := expr.(type) {
+	case *ast.ParenExpr:
+		return e.eval(expr.X)
+	case *ast.BinaryExpr:
+		if expr.Op != token.ADD {
+			return nil, false
+		}
+		left, leftOk := e.fragment(expr.X, []candidate{{}})
+		right, rightOk := e.fragment(expr.Y, left)
+		return right, leftOk || rightOk
+	case *ast.Ident:
+		return e.evalVar(expr)
+	case *ast.CallExpr:
+		if index, sink := e.queryArgIndex(expr); index >= 0 {
+			if sink {
+				return nil, false
+			}
+			return e.eval(expr.Args[index])
+		}
+		fn := e.funcOf(expr)
+		if fn == nil {
+			return nil, false
+		}
+		switch {
+		case fn.Pkg().Path() == "fmt" && fn.Name() == "Sprintf" && len(expr.Args) >= 1:
+			return e.sprintf(expr.Args[0], expr.Args[1:], []candidate{{}})
+		case fn.Pkg().Path() == "strings" && fn.Type().(*types.Signature).Recv() == nil:
+			return e.evalStrings(fn.Name(), expr)
+		case fn.Name() == "String" && len(expr.Args) == 0:
+			// b.String()
+			sel, ok := expr.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return nil, false
+			}
+			if v := e.varOf(sel.X); v != nil && isBuilder(v.Type()) {
+				return e.evalAppends(v, []candidate{{}}, expr.Pos())
+			}
+		}
+	}
+	return nil, false
+}
+
+// evalStrings evaluates the functions of the strings package used to build queries
+func (e *evaluator) evalStrings(name string, call *ast.CallExpr) ([]candidate, bool) {
+	switch name {
+	case "Repeat":
+		// strings.Repeat("?, ", len(ids)-1) is expanded as a single repetition
+		if len(call.Args) != 2 {
+			return nil, false
+		}
+		values, ok := e.eval(call.Args[0])
+		if !ok {
+			return nil, false
+		}
+		if tv, ok := e.pkg.TypesInfo.Types[call.Args[1]]; ok && tv.Value != nil {
+			if n, ok := constant.Int64Val(tv.Value); ok {
+				result := make([]candidate, 0, len(values))
+				for _, v := range values {
+					result = append(result, candidate{value: strings.Repeat(v.value, int(n)), guesses: v.guesses})
+				}
+				return result, true
+			}
+		}
+		guess := fmt.Sprintf("%s => repeated once", types.ExprString(call))
+		result := make([]candidate, 0, len(values))
+		for _, v := range values {
+			result = append(result, v.concat("", guess))
+		}
+		return result, true
+	case "Join":
+		if len(call.Args) != 2 {
+			return nil, false
+		}
+		lit, ok := call.Args[0].(*ast.CompositeLit)
+		if !ok {
+			return nil, false
+		}
+		seps, ok := e.eval(call.Args[1])
+		if !ok {
+			return nil, false
+		}
+		var result []candidate
+		for _, sep := range seps {
+			values := []candidate{{}}
+			for i, elt := range lit.Elts {
+				if i > 0 {
+					values = concatAll(values, []candidate{sep})
+				}
+				values, _ = e.fragment(elt, values)
+			}
+			result = appendUnique(result, values...)
+		}
+		return result, true
+	case "TrimSpace", "TrimSuffix", "TrimPrefix", "TrimRight", "TrimLeft", "ToUpper", "ToLower":
+		if len(call.Args) == 0 {
+			return nil, false
+		}
+		values, ok := e.eval(call.Args[0])
+		if !ok {
+			return nil, false
+		}
+		cutset := ""
+		if len(call.Args) == 2 {
+			tv, ok := e.pkg.TypesInfo.Types[call.Args[1]]
+			if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+				return nil, false
+			}
+			cutset = constant.StringVal(tv.Value)
+		}
+		result := make([]candidate, 0, len(values))
+		for _, v := range values {
+			var value string
+			switch name {
+			case "TrimSpace":
+				value = strings.TrimSpace(v.value)
+			case "TrimSuffix":
+				value = strings.TrimSuffix(v.value, cutset)
+			case "TrimPrefix":
+				value = strings.TrimPrefix(v.value, cutset)
+			case "TrimRight":
+				value = strings.TrimRight(v.value, cutset)
+			case "TrimLeft":
+				value = strings.TrimLeft(v.value, cutset)
+			case "ToUpper":
+				value = strings.ToUpper(v.value)
+			case "ToLower":
+				value = strings.ToLower(v.value)
+			}
+			result = append(result, candidate{value: value, guesses: v.guesses})
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func (e *evaluator) evalVar(ident *ast.Ident) ([]candidate, bool) {
+	v, ok := e.pkg.TypesInfo.ObjectOf(ident).(*types.Var)
+	if !ok || e.visiting[v] {
+		return nil, false
+	}
+	e.visiting[v] = true
+	defer delete(e.visiting, v)
+
+	var values []candidate
+	for _, assigned := range e.assigns[v] {
+		// reassignments through itself (query = db.Rebind(query)) cannot be evaluated and are skipped
+		vs, ok := e.eval(assigned)
+		if !ok {
+			continue
+		}
+		values = appendUnique(values, vs...)
+	}
+	if len(e.appends[v]) == 0 {
+		return values, len(values) > 0
+	}
+	known := len(values) > 0
+	if !known {
+		if len(e.assigns[v]) > 0 {
+			return nil, false
+		}
+		// var query string
+		values = []candidate{{}}
+	}
+	values, ok = e.evalAppends(v, values, ident.Pos())
+	return values, known || ok
+}
+
+// evalAppends applies the writes to v before pos to each of the initial values.
+// A conditional write yields the values both with and without it, and the writes in a loop are evaluated as a single iteration.
+func (e *evaluator) evalAppends(v *types.Var, values []candidate, pos token.Pos) ([]candidate, bool) {
+	var ops []appendOp
+	for _, op := range e.appends[v] {
+		if op.pos < pos && op.pos > v.Pos() {
+			ops = append(ops, op)
+		}
+	}
+
+	known := false
+	for i := 0; i < len(ops); {
+		loop := outermost(ops[i].ancestors, v.Pos(), isLoop)
+		if loop == nil {
+			next, ok := e.write(ops[i], values)
+			known = known || ok
+			values = e.branch(ops[i].ancestors, v.Pos(), values, next)
+			i++
+			continue
+		}
+
+		// all the writes in the same loop, evaluated as a single iteration
+		j := i
+		iteration := []candidate{{}}
+		for ; j < len(ops) && outermost(ops[j].ancestors, v.Pos(), isLoop) == loop; j++ {
+			if outermost(ops[j].ancestors, loop.Pos(), isConditional) != nil {
+				// conditional writes in the loop are usually separators written from the second iteration
+				continue
+			}
+			next, ok := e.write(ops[j], iteration)
+			known = known || ok
+			iteration = next
+		}
+		line := e.pkg.Fset.Position(loop.Pos()).Line
+		fragments := make([]candidate, 0, len(iteration))
+		for _, c := range iteration {
+			fragments = append(fragments, c.concat("", fmt.Sprintf("loop at line %d => %q", line, c.value)))
+		}
+		values = e.branch(enclosing(ops[i].ancestors, loop), v.Pos(), values, concatAll(values, fragments))
+		i = j
+	}
+	return values, known
+}
+
+func (e *evaluator) write(op appendOp, values []candidate) ([]candidate, bool) {
+	if op.format {
+		call := op.expr.(*ast.CallExpr)
+		return e.sprintf(call.Args[1], call.Args[2:], values)
+	}
+	if tv, ok := e.pkg.TypesInfo.Types[op.expr]; ok && tv.Value != nil && tv.Value.Kind() == constant.Int {
+		// WriteByte('(') / WriteRune(',')
+		if r, ok := constant.Int64Val(tv.Value); ok {
+			return concatAll(values, []candidate{{value: string(rune(r))}}), true
+		}
+	}
+	return e.fragment(op.expr, values)
+}
+
+// branch returns both the values with and without the write when it is inside a conditional
+func (e *evaluator) branch(ancestors []ast.Node, declPos token.Pos, without []candidate, with []candidate) []candidate {
+	if outermost(ancestors, declPos, isConditional) == nil {
+		return with
+	}
+	return appendUnique(append([]candidate{}, without...), with...)
+}
+
+// enclosing returns the ancestors outside of n
+func enclosing(ancestors []ast.Node, n ast.Node) []ast.Node {
+	for i, ancestor := range ancestors {
+		if ancestor == n {
+			return ancestors[:i]
+		}
+	}
+	return ancestors
+}
+
+// outermost returns the outermost ancestor starting after pos that satisfies f
+func outermost(ancestors []ast.Node, pos token.Pos, f func(ast.Node) bool) ast.Node {
+	for _, n := range ancestors {
+		if n.Pos() > pos && f(n) {
+			return n
+		}
+	}
+	return nil
+}
+
+func isLoop(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.ForStmt, *ast.RangeStmt:
+		return true
+	}
+	return false
+}
+
+func isConditional(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.IfStmt, *ast.CaseClause, *ast.CommClause:
+		return true
+	}
+	return false
+}
+
+// fragment appends the values of expr to each prefix.
+// When expr cannot be determined statically, a symbolic placeholder is appended instead and recorded as a guess.
+func (e *evaluator) fragment(expr ast.Expr, prefixes []candidate) ([]candidate, bool) {
+	values, ok := e.eval(expr)
+	if ok {
+		return concatAll(prefixes, values), true
+	}
+	result := make([]candidate, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, e.guess(prefix, expr))
+	}
+	return result, false
+}
+
+func (e *evaluator) guess(prefix candidate, expr ast.Expr) candidate {
+	symbol := symbolFor(prefix.value)
+	return prefix.concat(symbol, fmt.Sprintf("%s => %s", types.ExprString(expr), symbol))
+}
+
+// symbolFor returns the placeholder substituted for an unknown fragment following prefix
+func symbolFor(prefix string) string {
+	upper := strings.ToUpper(strings.TrimSpace(prefix))
+	if strings.HasSuffix(upper, "SELECT") || strings.HasSuffix(upper, "SELECT DISTINCT") {
+		return "*"
+	}
+	return "?"
+}
+
+// sprintf expands fmt.Sprintf(format, args...) after each prefix
+func (e *evaluator) sprintf(format ast.Expr, args []ast.Expr, prefixes []candidate) ([]candidate, bool) {
+	formats, ok := e.eval(format)
+	if !ok {
+		return nil, false
+	}
+	var result []candidate
+	for _, prefix := range prefixes {
+		for _, f := range formats {
+			result = appendUnique(result, e.expandFormat(f.value, args, prefix.concat("", f.guesses...))...)
+		}
+	}
+	return result, true
+}
+
+func (e *evaluator) expandFormat(format string, args []ast.Expr, prefix candidate) []candidate {
+	values := []candidate{prefix}
+	argIndex := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			values = concatAll(values, []candidate{{value: format[i : i+1]}})
+			continue
+		}
+		// %[flags][width][.precision]verb
+		j := i + 1
+		for j < len(format) && strings.IndexByte("+-# 0123456789.*[]", format[j]) >= 0 {
+			j++
+		}
+		if j >= len(format) {
+			break
+		}
+		spec := format[i : j+1]
+		i = j
+		if format[j] == '%' {
+			values = concatAll(values, []candidate{{value: "%"}})
+			continue
+		}
+		if start := strings.IndexByte(spec, '['); start >= 0 {
+			if end := strings.IndexByte(spec, ']'); end > start {
+				if n, err := strconv.Atoi(spec[start+1 : end]); err == nil {
+					argIndex = n - 1
+				}
+				spec = spec[:start] + spec[end+1:]
+			}
+		}
+		if argIndex >= len(args) {
+			break
+		}
+		arg := args[argIndex]
+		argIndex++
+		values = e.formatArg(spec, arg, values)
+	}
+	return values
+}
+
+func (e *evaluator) formatArg(spec string, arg ast.Expr, prefixes []candidate) []candidate {
+	if tv, ok := e.pkg.TypesInfo.Types[arg]; ok && tv.Value != nil {
+		var value interface{}
+		switch tv.Value.Kind() {
+		case constant.String:
+			value = constant.StringVal(tv.Value)
+		case constant.Int:
+			value, _ = constant.Int64Val(tv.Value)
+		case constant.Float:
+			value, _ = constant.Float64Val(tv.Value)
+		case constant.Bool:
+			value = constant.BoolVal(tv.Value)
+		}
+		if value != nil {
+			return concatAll(prefixes, []candidate{{value: fmt.Sprintf(spec, value)}})
+		}
+	}
+	if values, ok := e.eval(arg); ok {
+		formatted := make([]candidate, 0, len(values))
+		for _, v := range values {
+			formatted = append(formatted, candidate{value: fmt.Sprintf(spec, v.value), guesses: v.guesses})
+		}
+		return concatAll(prefixes, formatted)
+	}
+	result := make([]candidate, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, e.guess(prefix, arg))
+	}
+	return result
+}
+
+func concatAll(left []candidate, right []candidate) []candidate {
+	values := make([]candidate, 0, len(left)*len(right))
+	for _, l := range left {
+		for _, r := range right {
+			if len(values) >= maxCandidates {
+				return values
+			}
+			values = append(values, l.concat(r.value, r.guesses...))
+		}
+	}
+	return values
+}
+
+func appendUnique(values []candidate, vs ...candidate) []candidate {
+	for _, v := range vs {
+		found := false
+		for _, value := range values {
+			if value.value == v.value {
+				found = true
+				break
+			}
+		}
+		if !found && len(values) < maxCandidates {
+			values = append(values, v)
+		}
+	}
+	return values
+}
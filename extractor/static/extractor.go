@@ -5,6 +5,7 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -13,12 +14,18 @@ import (
 )
 
 var sqlPattern = regexp.MustCompile(`^(SELECT|INSERT|UPDATE|DELETE)\b`)
-var replacePattern = regexp.MustCompile(`\s+`)
 
 type ExtractedQuery struct {
-	file    string
-	pos     int
+	file   string
+	pos    int
+	column int
+	// function is the enclosing function or method
+	function string
+	// raw is the query before normalization
+	raw     string
 	content string
+	// guesses describes the fragments substituted because they could not be determined statically
+	guesses []string
 }
 
 func ExtractQueryFromFile(path string, root string) ([]*ExtractedQuery, error) {
@@ -30,34 +37,52 @@ func ExtractQueryFromFile(path string, root string) ([]*ExtractedQuery, error) {
 
 	// 結果を収集
 	var results []*ExtractedQuery
-	ast.Inspect(node, func(n ast.Node) bool {
-		if n == nil {
-			return false
+	for _, decl := range node.Decls {
+		function := ""
+		if f, ok := decl.(*ast.FuncDecl); ok {
+			function = funcName(f)
 		}
-		// 文字列リテラルを抽出
-		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
-			// SQLクエリらしき文字列を抽出
-			value := strings.Trim(lit.Value, "\"`")
-			value = strings.ReplaceAll(value, "\n", " ")
-			value = replacePattern.ReplaceAllString(value, " ")
-			value = normalizer.NormalizeQuery(value)
-			pos := lit.Pos()
-			if sqlPattern.MatchString(value) {
-				pos := fs.Position(pos)
-				relativePath, err := filepath.Rel(root, path)
-				if err != nil {
-					return false
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if n == nil {
+				return false
+			}
+			// 文字列リテラルを抽出
+			if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				// SQLクエリらしき文字列を抽出
+				raw := strings.Trim(lit.Value, "\"`")
+				value := normalizer.NormalizeQuery(raw)
+				pos := lit.Pos()
+				if sqlPattern.MatchString(value) {
+					pos := fs.Position(pos)
+					relativePath, err := filepath.Rel(root, path)
+					if err != nil {
+						return false
+					}
+					results = append(results, &ExtractedQuery{
+						file:     relativePath,
+						pos:      pos.Line,
+						function: function,
+						raw:      raw,
+						content:  value,
+					})
 				}
-				results = append(results, &ExtractedQuery{
-					file:    relativePath,
-					pos:     pos.Line,
-					content: value,
-				})
+				return false
 			}
-			return false
-		}
-		return true
-	})
+			return true
+		})
+	}
 
 	return results, nil
 }
+
+// funcName returns the name of the function or method declared by decl (e.g. "getUser", "(*Handler).getUser")
+func funcName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return decl.Name.Name
+	}
+	recv := types.ExprString(decl.Recv.List[0].Type)
+	if strings.HasPrefix(recv, "*") {
+		return fmt.Sprintf("(%s).%s", recv, decl.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s", recv, decl.Name.Name)
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/traP-jp/isuc/domains"
 )
 
 func TestExtractQueryFromFile(t *testing.T) {
@@ -18,21 +19,21 @@ func TestExtractQueryFromFile(t *testing.T) {
 			path: "testdata/extractor1.go",
 			root: "testdata",
 			expected: []*ExtractedQuery{
-				{file: "extractor1.go", pos: 32, content: "SELECT id, name FROM users;"},
-				{file: "extractor1.go", pos: 44, content: "INSERT INTO users (name) VALUES (?);"},
-				{file: "extractor1.go", pos: 59, content: "SELECT id, name FROM users WHERE id = ?;"},
-				{file: "extractor1.go", pos: 72, content: "UPDATE users SET name = ? WHERE id = ?;"},
-				{file: "extractor1.go", pos: 79, content: "DELETE FROM users WHERE id = ?;"},
-				{file: "extractor1.go", pos: 89, content: "SELECT id, user_id, title, body FROM posts;"},
-				{file: "extractor1.go", pos: 103, content: "INSERT INTO posts (user_id, title, body) VALUES (?);"},
-				{file: "extractor1.go", pos: 118, content: "SELECT id, user_id, title, body FROM posts WHERE id = ?;"},
-				{file: "extractor1.go", pos: 133, content: "UPDATE posts SET user_id = ?, title = ?, body = ? WHERE id = ?;"},
-				{file: "extractor1.go", pos: 140, content: "DELETE FROM posts WHERE id = ?;"},
-				{file: "extractor1.go", pos: 150, content: "SELECT id, post_id, body FROM comments;"},
-				{file: "extractor1.go", pos: 163, content: "INSERT INTO comments (post_id, body) VALUES (?);"},
-				{file: "extractor1.go", pos: 178, content: "SELECT id, post_id, body FROM comments WHERE id = ?;"},
-				{file: "extractor1.go", pos: 191, content: "UPDATE comments SET body = ? WHERE id = ?;"},
-				{file: "extractor1.go", pos: 198, content: "DELETE FROM comments WHERE id = ?;"},
+				{file: "extractor1.go", pos: 32, function: "init", raw: "SELECT id, name FROM users", content: "SELECT id, name FROM users;"},
+				{file: "extractor1.go", pos: 44, function: "init", raw: "INSERT INTO users (name) VALUES (?)", content: "INSERT INTO users (name) VALUES (?);"},
+				{file: "extractor1.go", pos: 59, function: "init", raw: "SELECT id, name FROM users WHERE id = ?", content: "SELECT id, name FROM users WHERE id = ?;"},
+				{file: "extractor1.go", pos: 72, function: "init", raw: "UPDATE users SET name = ? WHERE id = ?", content: "UPDATE users SET name = ? WHERE id = ?;"},
+				{file: "extractor1.go", pos: 79, function: "init", raw: "DELETE FROM users WHERE id = ?", content: "DELETE FROM users WHERE id = ?;"},
+				{file: "extractor1.go", pos: 89, function: "init", raw: "SELECT id, user_id, title, body FROM posts", content: "SELECT id, user_id, title, body FROM posts;"},
+				{file: "extractor1.go", pos: 103, function: "init", raw: "INSERT INTO posts (user_id, title, body) VALUES (?, ?, ?)", content: "INSERT INTO posts (user_id, title, body) VALUES (?);"},
+				{file: "extractor1.go", pos: 118, function: "init", raw: "SELECT id, user_id, title, body FROM posts WHERE id = ?", content: "SELECT id, user_id, title, body FROM posts WHERE id = ?;"},
+				{file: "extractor1.go", pos: 133, function: "init", raw: "UPDATE posts SET user_id = ?, title = ?, body = ? WHERE id = ?", content: "UPDATE posts SET user_id = ?, title = ?, body = ? WHERE id = ?;"},
+				{file: "extractor1.go", pos: 140, function: "init", raw: "DELETE FROM posts WHERE id = ?", content: "DELETE FROM posts WHERE id = ?;"},
+				{file: "extractor1.go", pos: 150, function: "init", raw: "SELECT id, post_id, body FROM comments", content: "SELECT id, post_id, body FROM comments;"},
+				{file: "extractor1.go", pos: 163, function: "init", raw: "INSERT INTO comments (post_id, body) VALUES (?, ?)", content: "INSERT INTO comments (post_id, body) VALUES (?);"},
+				{file: "extractor1.go", pos: 178, function: "init", raw: "SELECT id, post_id, body FROM comments WHERE id = ?", content: "SELECT id, post_id, body FROM comments WHERE id = ?;"},
+				{file: "extractor1.go", pos: 191, function: "init", raw: "UPDATE comments SET body = ? WHERE id = ?", content: "UPDATE comments SET body = ? WHERE id = ?;"},
+				{file: "extractor1.go", pos: 198, function: "init", raw: "DELETE FROM comments WHERE id = ?", content: "DELETE FROM comments WHERE id = ?;"},
 			},
 		},
 	}
@@ -45,3 +46,81 @@ func TestExtractQueryFromFile(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractQueries(t *testing.T) {
+	results, err := ExtractQueries(".", "./testdata/typed")
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "testdata/typed/main.go", results[0].File)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, []*ExtractedQuery{
+		{file: "testdata/typed/main.go", pos: 18, column: 9, function: "getUsers", raw: "SELECT id, name FROM users", content: "SELECT id, name FROM users;"},
+		{file: "testdata/typed/main.go", pos: 24, column: 9, function: "getUser", raw: "SELECT id, name FROM users WHERE id = ?", content: "SELECT id, name FROM users WHERE id = ?;"},
+		{file: "testdata/typed/main.go", pos: 34, column: 9, function: "getUsersByIDs", raw: "SELECT id, name FROM users WHERE id IN (?)", content: "SELECT id, name FROM users WHERE id IN (?);"},
+		{file: "testdata/typed/main.go", pos: 38, column: 12, function: "createUser", raw: "INSERT INTO users (name)\n\t\tVALUES (?)", content: "INSERT INTO users (name) VALUES (?);"},
+		{file: "testdata/typed/main.go", pos: 47, column: 12, function: "renameUser", raw: "UPDATE users SET name = :name WHERE id = :id", content: "UPDATE users SET name = :name WHERE id = :id;"},
+		{file: "testdata/typed/main.go", pos: 56, column: 12, function: "(*handler).deleteUser", raw: "DELETE FROM users WHERE id = ?", content: "DELETE FROM users WHERE id = ?;"},
+	}, results[0].Queries)
+}
+
+func TestExtractTemplatedQueries(t *testing.T) {
+	results, err := ExtractQueries(".", "./testdata/typed")
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "testdata/typed/templated.go", results[1].File)
+	assert.NoError(t, results[1].Err)
+	assert.Equal(t, []*ExtractedQuery{
+		{
+			file: "testdata/typed/templated.go", pos: 13, column: 12, function: "getPostsByIDs",
+			raw:     "SELECT * FROM posts WHERE id IN (?, ?)",
+			content: "SELECT * FROM posts WHERE id IN (?);",
+			guesses: []string{"columns => *", `strings.Repeat("?, ", len(ids) - 1) => repeated once`},
+		},
+		{
+			file: "testdata/typed/templated.go", pos: 22, column: 12, function: "getPostsOfUser",
+			raw:     "SELECT id, user_id, title FROM posts WHERE user_id = 1",
+			content: "SELECT id, user_id, title FROM posts WHERE user_id = 1;",
+		},
+		{
+			file: "testdata/typed/templated.go", pos: 22, column: 12, function: "getPostsOfUser",
+			raw:     "SELECT id, user_id, title FROM archived_posts WHERE user_id = 1",
+			content: "SELECT id, user_id, title FROM archived_posts WHERE user_id = 1;",
+		},
+		{
+			file: "testdata/typed/templated.go", pos: 40, column: 12, function: "searchPosts",
+			raw:     "SELECT id, user_id, title FROM posts WHERE user_id = ? AND id IN (?)",
+			content: "SELECT id, user_id, title FROM posts WHERE user_id = ? AND id IN (?);",
+			guesses: []string{`loop at line 33 => "?"`},
+		},
+		{
+			file: "testdata/typed/templated.go", pos: 40, column: 12, function: "searchPosts",
+			raw:     "SELECT id, user_id, title FROM posts WHERE user_id = ? AND title = ? AND id IN (?)",
+			content: "SELECT id, user_id, title FROM posts WHERE user_id = ? AND title = ? AND id IN (?);",
+			guesses: []string{`loop at line 33 => "?"`},
+		},
+		{
+			file: "testdata/typed/templated.go", pos: 50, column: 12, function: "updatePost",
+			raw:     "UPDATE posts SET updated_at = NOW() WHERE id = ?",
+			content: "UPDATE posts SET updated_at = NOW() WHERE id = ?;",
+		},
+		{
+			file: "testdata/typed/templated.go", pos: 50, column: 12, function: "updatePost",
+			raw:     "UPDATE posts SET updated_at = NOW(), title = ? WHERE id = ?",
+			content: "UPDATE posts SET updated_at = NOW(), title = ? WHERE id = ?;",
+		},
+	}, results[1].Queries)
+}
+
+func TestExportExtractedQuery(t *testing.T) {
+	query := &ExtractedQuery{
+		file: "main.go", pos: 12, column: 3, function: "(*handler).getUser",
+		raw:     "SELECT * FROM users\n\tWHERE id = ?",
+		content: "SELECT * FROM users WHERE id = ?;",
+	}
+	assert.Equal(t, domains.ExtractedQuery{
+		QuerySource: domains.QuerySource{File: "main.go", Line: 12, Column: 3, Function: "(*handler).getUser"},
+		Raw:         "SELECT * FROM users\n\tWHERE id = ?",
+		Query:       "SELECT * FROM users WHERE id = ?;",
+	}, query.Export())
+	assert.Equal(t, "-- main.go:12:3 in (*handler).getUser\nSELECT * FROM users WHERE id = ?;", query.String())
+}
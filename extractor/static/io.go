@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/traP-jp/isuc/domains"
 )
 
 func IsValidDir(dir string) bool {
@@ -53,6 +55,56 @@ func WriteQueriesToFile(out string, queries []*ExtractedQuery) error {
 	return nil
 }
 
+// WriteQueriesToJSONFile writes the queries with their locations in the codebase, which can be read by domains.LoadExtractedQueries
+func WriteQueriesToJSONFile(out string, queries []*ExtractedQuery) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", err)
+	}
+	defer f.Close()
+
+	exported := make([]domains.ExtractedQuery, 0, len(queries))
+	for _, query := range queries {
+		exported = append(exported, query.Export())
+	}
+	err = domains.SaveExtractedQueries(f, exported)
+	if err != nil {
+		return fmt.Errorf("error writing to file: %v", err)
+	}
+
+	return nil
+}
+
+func (q *ExtractedQuery) Export() domains.ExtractedQuery {
+	return domains.ExtractedQuery{
+		QuerySource: domains.QuerySource{
+			File:     q.file,
+			Line:     q.pos,
+			Column:   q.column,
+			Function: q.function,
+		},
+		Raw:     q.raw,
+		Query:   q.content,
+		Guesses: q.guesses,
+	}
+}
+
+func importExtractedQuery(q domains.ExtractedQuery) *ExtractedQuery {
+	return &ExtractedQuery{
+		file:     q.File,
+		pos:      q.Line,
+		column:   q.Column,
+		function: q.Function,
+		raw:      q.Raw,
+		content:  q.Query,
+		guesses:  q.Guesses,
+	}
+}
+
 func (q *ExtractedQuery) String() string {
-	return fmt.Sprintf("-- %s:%d\n%s", q.file, q.pos, q.content)
+	location := q.Export().QuerySource.String()
+	if len(q.guesses) > 0 {
+		return fmt.Sprintf("-- %s\n-- guessed: %s\n%s", location, strings.Join(q.guesses, ", "), q.content)
+	}
+	return fmt.Sprintf("-- %s\n%s", location, q.content)
 }
@@ -0,0 +1,302 @@
+package static_extractor
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/traP-jp/isuc/normalizer"
+	"golang.org/x/tools/go/packages"
+)
+
+// queryPackages are the packages whose functions receive a query
+var queryPackages = map[string]struct{}{
+	"database/sql":            {},
+	"github.com/jmoiron/sqlx": {},
+}
+
+type FileResult struct {
+	// File is the path relative to the root
+	File    string
+	Queries []*ExtractedQuery
+	Err     error
+	// Cached is true if the result is restored from the cache
+	Cached bool
+}
+
+type Options struct {
+	WalkOptions
+	// Workers is the number of goroutines parsing the files and extracting the packages concurrently (runtime.NumCPU() by default)
+	Workers int
+	// CacheFile caches the results by the content hash of each package; the cache is disabled if empty
+	CacheFile string
+}
+
+// Extract extracts the queries from the files listed by ListGoFiles.
+// Only the packages whose files have changed since the last run are loaded when the cache is enabled.
+func Extract(root string, opts Options) ([]*FileResult, error) {
+	files, err := ListGoFiles(root, opts.WalkOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// group the files by package directory
+	var dirs []string
+	filesByDir := make(map[string][]string)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if _, ok := filesByDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		filesByDir[dir] = append(filesByDir[dir], file)
+	}
+
+	// the dependencies are listed only for the cache
+	var deps map[string]string
+	if opts.CacheFile != "" {
+		if deps, err = dependencyHashes(root, opts.Tags); err != nil {
+			return nil, err
+		}
+	}
+	hashes := make([]string, len(dirs))
+	hashErrs := make([]error, len(dirs))
+	runParallel(len(dirs), opts.Workers, func(i int) {
+		hashes[i], hashErrs[i] = hashPackage(root, filesByDir[dirs[i]], opts.Tags, deps[dirs[i]])
+	})
+	if err := errors.Join(hashErrs...); err != nil {
+		return nil, err
+	}
+
+	// an empty cache is used if the cache is disabled
+	cache := loadExtractCache(opts.CacheFile)
+	hits := make([]bool, len(dirs))
+	var patterns []string
+	var parseFiles []string
+	for i, dir := range dirs {
+		if cached, ok := cache.Packages[dir]; ok && cached.Hash == hashes[i] {
+			hits[i] = true
+			continue
+		}
+		patterns = append(patterns, "./"+filepath.ToSlash(dir))
+		parseFiles = append(parseFiles, filesByDir[dir]...)
+	}
+
+	loaded := make(map[string]*FileResult)
+	if len(patterns) > 0 {
+		parsed, err := parseGoFiles(root, parseFiles, opts.Workers)
+		if err != nil {
+			return nil, err
+		}
+		results, err := extractPackages(root, patterns, opts.Tags, opts.Workers, parsed)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			loaded[result.File] = result
+		}
+	}
+
+	var results []*FileResult
+	for i, dir := range dirs {
+		if hits[i] {
+			results = append(results, cache.Packages[dir].results()...)
+			continue
+		}
+		// the files excluded from the walk are type-checked as part of the package, but not reported
+		var dirResults []*FileResult
+		for _, file := range filesByDir[dir] {
+			result, ok := loaded[file]
+			if !ok {
+				result = &FileResult{File: file, Err: fmt.Errorf("file not loaded as a part of any package")}
+			}
+			dirResults = append(dirResults, result)
+		}
+		cache.Packages[dir] = newCachedPackage(hashes[i], dirResults)
+		results = append(results, dirResults...)
+	}
+
+	if opts.CacheFile != "" {
+		if err := cache.save(opts.CacheFile); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// ExtractQueries loads the packages matching patterns in root with type information
+// and extracts the queries passed to the functions of database/sql and sqlx
+func ExtractQueries(root string, patterns ...string) ([]*FileResult, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	return extractPackages(root, patterns, nil, 0, nil)
+}
+
+// parseMode is the mode packages.Load parses the files with by default
+const parseMode = parser.AllErrors | parser.ParseComments
+
+// parsedFiles are the files parsed ahead of packages.Load keyed by the absolute path
+type parsedFiles struct {
+	fset  *token.FileSet
+	files map[string]*ast.File
+}
+
+// parseGoFiles parses the files on the workers so that packages.Load only type-checks them.
+// The files failing to parse are left to packages.Load to report the errors.
+func parseGoFiles(root string, files []string, workers int) (*parsedFiles, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path: %v", err)
+	}
+	fset := token.NewFileSet()
+	asts := make([]*ast.File, len(files))
+	runParallel(len(files), workers, func(i int) {
+		asts[i], _ = parser.ParseFile(fset, filepath.Join(absRoot, files[i]), nil, parseMode)
+	})
+	parsed := &parsedFiles{fset: fset, files: make(map[string]*ast.File, len(files))}
+	for i, file := range files {
+		if asts[i] != nil {
+			parsed.files[filepath.Join(absRoot, file)] = asts[i]
+		}
+	}
+	return parsed, nil
+}
+
+func extractPackages(root string, patterns []string, tags []string, workers int, parsed *parsedFiles) ([]*FileResult, error) {
+	cfg := &packages.Config{
+		// dependencies are type-checked from source so that loading does not depend on the export data format of the toolchain
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:  root,
+	}
+	if parsed != nil {
+		cfg.Fset = parsed.fset
+		cfg.ParseFile = func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+			if file, ok := parsed.files[filename]; ok {
+				return file, nil
+			}
+			return parser.ParseFile(fset, filename, src, parseMode)
+		}
+	}
+	if len(tags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(tags, ",")}
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading packages: %v", err)
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path: %v", err)
+	}
+
+	pkgResults := make([][]*FileResult, len(pkgs))
+	pkgErrs := make([]error, len(pkgs))
+	runParallel(len(pkgs), workers, func(i int) {
+		pkgResults[i], pkgErrs[i] = extractPackage(absRoot, pkgs[i])
+	})
+	if err := errors.Join(pkgErrs...); err != nil {
+		return nil, err
+	}
+
+	var results []*FileResult
+	for _, r := range pkgResults {
+		results = append(results, r...)
+	}
+	return results, nil
+}
+
+func extractPackage(absRoot string, pkg *packages.Package) ([]*FileResult, error) {
+	var results []*FileResult
+	e := newEvaluator(pkg)
+	fileResults := make(map[string]*FileResult)
+	for _, file := range pkg.Syntax {
+		path := pkg.Fset.File(file.Pos()).Name()
+		relativePath, err := filepath.Rel(absRoot, path)
+		if err != nil {
+			return nil, fmt.Errorf("error getting relative path: %v", err)
+		}
+		result := &FileResult{File: relativePath}
+		fileResults[path] = result
+		results = append(results, result)
+
+		for _, decl := range file.Decls {
+			function := ""
+			if f, ok := decl.(*ast.FuncDecl); ok {
+				function = funcName(f)
+			}
+			ast.Inspect(decl, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				index, sink := e.queryArgIndex(call)
+				if index < 0 || !sink {
+					return true
+				}
+				values, ok := e.eval(call.Args[index])
+				if !ok {
+					return true
+				}
+				pos := pkg.Fset.Position(call.Pos())
+				for _, value := range values {
+					content := normalizeExtracted(value.value)
+					if !sqlPattern.MatchString(content) {
+						continue
+					}
+					result.Queries = append(result.Queries, &ExtractedQuery{
+						file:     relativePath,
+						pos:      pos.Line,
+						column:   pos.Column,
+						function: function,
+						raw:      value.value,
+						content:  content,
+						guesses:  value.guesses,
+					})
+				}
+				return true
+			})
+		}
+	}
+	for _, pkgErr := range pkg.Errors {
+		path, _, _ := strings.Cut(pkgErr.Pos, ":")
+		if result, ok := fileResults[path]; ok && result.Err == nil {
+			result.Err = pkgErr
+		}
+	}
+
+	return results, nil
+}
+
+func normalizeExtracted(value string) string {
+	return normalizer.NormalizeQuery(value)
+}
+
+// runParallel calls f with 0 to n-1 on at most workers goroutines
+func runParallel(n int, workers int, f func(i int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
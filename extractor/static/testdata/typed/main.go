@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const userColumns = "id, name"
+
+const selectUsers = "SELECT " + userColumns + " FROM users"
+
+var db *sqlx.DB
+
+func getUsers() error {
+	var users []struct{}
+	return db.Select(&users, selectUsers)
+}
+
+func getUser(id int) error {
+	var user struct{}
+	query := selectUsers + " WHERE id = ?"
+	return db.Get(&user, query, id)
+}
+
+func getUsersByIDs(ids []int) error {
+	query, args, err := sqlx.In(selectUsers+" WHERE id IN (?)", ids)
+	if err != nil {
+		return err
+	}
+	query = db.Rebind(query)
+	var users []struct{}
+	return db.Select(&users, query, args...)
+}
+
+func createUser(tx *sql.Tx, name string) error {
+	_, err := tx.Exec(
+		`INSERT INTO users (name)
+		VALUES (?)`,
+		name,
+	)
+	return err
+}
+
+func renameUser(name string, id int) error {
+	_, err := db.NamedExec("UPDATE users SET name = :name WHERE id = :id", map[string]interface{}{"name": name, "id": id})
+	return err
+}
+
+type handler struct {
+	db *sqlx.DB
+}
+
+func (h *handler) deleteUser(id int) error {
+	_, err := h.db.Exec("DELETE FROM users WHERE id = ?", id)
+	return err
+}
+
+func main() {
+	// never reaches the database
+	log.Println("SELECT * FROM users is slow")
+}
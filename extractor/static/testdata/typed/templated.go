@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const postColumns = "id, user_id, title"
+
+func getPostsByIDs(ids []int, columns string) error {
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	query := fmt.Sprintf("SELECT %s FROM posts WHERE id IN (%s)", columns, placeholders)
+	_, err := db.Query(query)
+	return err
+}
+
+func getPostsOfUser(userID int, order string) error {
+	table := "posts"
+	if order == "" {
+		table = "archived_posts"
+	}
+	_, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE user_id = %d", postColumns, table, 1), userID)
+	return err
+}
+
+func searchPosts(userID int, title string, ids []int) error {
+	var b strings.Builder
+	b.WriteString("SELECT " + postColumns + " FROM posts WHERE user_id = ?")
+	if title != "" {
+		b.WriteString(" AND title = ?")
+	}
+	b.WriteString(" AND id IN (")
+	for i := range ids {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('?')
+	}
+	b.WriteRune(')')
+	_, err := db.Query(b.String(), userID)
+	return err
+}
+
+func updatePost(id int, title string) error {
+	query := "UPDATE posts SET updated_at = NOW()"
+	if title != "" {
+		query += ", title = ?"
+	}
+	query += " WHERE id = ?"
+	_, err := db.Exec(query, id)
+	return err
+}
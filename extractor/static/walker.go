@@ -0,0 +1,230 @@
+package static_extractor
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// skippedDirs are never walked, like the go command does for ./...
+var skippedDirs = map[string]struct{}{
+	"vendor":   {},
+	"testdata": {},
+}
+
+var generatedPattern = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$`)
+
+type WalkOptions struct {
+	// Include limits the files to those matching any of the globs (all files by default)
+	Include []string
+	// Exclude skips the files and directories matching any of the globs
+	Exclude []string
+	// Tags are the build tags used to evaluate the build constraints of the files
+	Tags []string
+}
+
+// ListGoFiles lists the non-test Go files under root that the go command would build,
+// skipping vendor, testdata, hidden directories, generated files and the paths ignored by .gitignore.
+// Globs are matched against the slash-separated path relative to root, and "**" matches any number of directories.
+// The returned paths are relative to root.
+func ListGoFiles(root string, opts WalkOptions) ([]string, error) {
+	ctx := build.Default
+	ctx.BuildTags = opts.Tags
+
+	var files []string
+	ignores := []*gitignore{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == "." {
+				ignores = appendGitignore(ignores, p, rel)
+				return nil
+			}
+			name := d.Name()
+			if _, ok := skippedDirs[name]; ok || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if matchAny(opts.Exclude, rel) || isIgnored(ignores, rel, true) {
+				return filepath.SkipDir
+			}
+			ignores = appendGitignore(ignores, p, rel)
+			return nil
+		}
+
+		if !strings.HasSuffix(rel, ".go") || strings.HasSuffix(rel, "_test.go") {
+			return nil
+		}
+		if len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+			return nil
+		}
+		if matchAny(opts.Exclude, rel) || isIgnored(ignores, rel, false) {
+			return nil
+		}
+		match, err := ctx.MatchFile(filepath.Dir(p), d.Name())
+		if err != nil {
+			return fmt.Errorf("error evaluating build constraints of %s: %v", rel, err)
+		}
+		if !match {
+			return nil
+		}
+		generated, err := isGenerated(p)
+		if err != nil {
+			return err
+		}
+		if generated {
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking directory: %v", err)
+	}
+	return files, nil
+}
+
+func isGenerated(p string) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, fmt.Errorf("error opening file: %v", err)
+	}
+	defer f.Close()
+
+	// the comment must appear before the package clause
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "package ") {
+			return false, nil
+		}
+		if generatedPattern.MatchString(line) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func matchAny(globs []string, p string) bool {
+	for _, glob := range globs {
+		if matchGlob(glob, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether the slash-separated path p matches glob.
+// A glob without a slash matches the base name at any depth, and "**" matches any number of directories.
+// A glob matching a directory also matches everything under it.
+func matchGlob(glob string, p string) bool {
+	glob = strings.TrimPrefix(strings.TrimSuffix(glob, "/"), "./")
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+	return matchSegments(strings.Split(glob, "/"), strings.Split(p, "/"), true)
+}
+
+// matchSegments matches the path segments against the glob segments.
+// If prefix is true, the glob may match an ancestor directory of the path.
+func matchSegments(glob []string, p []string, prefix bool) bool {
+	if len(glob) == 0 {
+		return prefix || len(p) == 0
+	}
+	if glob[0] == "**" {
+		for i := 0; i <= len(p); i++ {
+			if matchSegments(glob[1:], p[i:], prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(p) == 0 {
+		return false
+	}
+	ok, err := path.Match(glob[0], p[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchSegments(glob[1:], p[1:], prefix)
+}
+
+type gitignore struct {
+	// dir is the slash-separated directory of the .gitignore relative to the root
+	dir   string
+	rules []gitignoreRule
+}
+
+type gitignoreRule struct {
+	glob    string
+	negate  bool
+	dirOnly bool
+}
+
+func appendGitignore(ignores []*gitignore, dir string, rel string) []*gitignore {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return ignores
+	}
+	ignore := &gitignore{dir: rel}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := gitignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// a pattern without a slash matches at any depth, otherwise it is relative to the directory of the .gitignore
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		rule.glob = strings.TrimPrefix(line, "/")
+		ignore.rules = append(ignore.rules, rule)
+	}
+	return append(ignores, ignore)
+}
+
+// isIgnored reports whether p is ignored by the .gitignore files of its ancestors.
+// The last matching rule wins, and the rules of a deeper .gitignore take precedence.
+func isIgnored(ignores []*gitignore, p string, isDir bool) bool {
+	ignored := false
+	for _, ignore := range ignores {
+		rel := p
+		if ignore.dir != "." {
+			if !strings.HasPrefix(p, ignore.dir+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(p, ignore.dir+"/")
+		}
+		for _, rule := range ignore.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			// the ignored directories are never walked, so only the path itself has to match
+			if matchSegments(strings.Split(rule.glob, "/"), strings.Split(rel, "/"), false) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,162 @@
+package static_extractor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestListGoFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gitignore":             "/build/\n*.gen.go\n!keep.gen.go\n",
+		"main.go":                "package main\n",
+		"main_test.go":           "package main\n",
+		"handler/user.go":        "package handler\n",
+		"handler/user_mock.go":   "package handler\n",
+		"handler/.gitignore":     "legacy.go\n",
+		"handler/legacy.go":      "package handler\n",
+		"handler/query.gen.go":   "package handler\n",
+		"handler/keep.gen.go":    "package handler\n",
+		"handler/generated.go":   "// Code generated by sqlc. DO NOT EDIT.\n\npackage handler\n",
+		"handler/debug.go":       "//go:build debug\n\npackage handler\n",
+		"build/out.go":           "package build\n",
+		"vendor/lib/lib.go":      "package lib\n",
+		"testdata/data.go":       "package testdata\n",
+		".git/hooks/hook.go":     "package hooks\n",
+		"_tools/tools.go":        "package tools\n",
+		"internal/util/util.go":  "package util\n",
+		"internal/util/notes.md": "",
+	})
+
+	files, err := ListGoFiles(root, WalkOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.FromSlash("handler/keep.gen.go"),
+		filepath.FromSlash("handler/user.go"),
+		filepath.FromSlash("handler/user_mock.go"),
+		filepath.FromSlash("internal/util/util.go"),
+		"main.go",
+	}, files)
+
+	files, err = ListGoFiles(root, WalkOptions{
+		Include: []string{"handler/**"},
+		Exclude: []string{"*_mock.go"},
+		Tags:    []string{"debug"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.FromSlash("handler/debug.go"),
+		filepath.FromSlash("handler/keep.gen.go"),
+		filepath.FromSlash("handler/user.go"),
+	}, files)
+}
+
+func TestExtract(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.23\n",
+		"main.go": `package main
+
+import "database/sql"
+
+var db *sql.DB
+
+func getUser(id int) error {
+	return db.QueryRow("SELECT * FROM users WHERE id = ?", id).Err()
+}
+
+func main() {}
+`,
+		"store/post.go": `package store
+
+import "database/sql"
+
+func DeletePost(db *sql.DB, id int) error {
+	_, err := db.Exec("DELETE FROM posts WHERE id = ?", id)
+	return err
+}
+`,
+	})
+	cacheFile := filepath.Join(t.TempDir(), "cache.json")
+	opts := Options{Workers: 2, CacheFile: cacheFile}
+
+	results, err := Extract(root, opts)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "main.go", results[0].File)
+	assert.False(t, results[0].Cached)
+	assert.Equal(t, []*ExtractedQuery{
+		{file: "main.go", pos: 8, column: 9, function: "getUser", raw: "SELECT * FROM users WHERE id = ?", content: "SELECT * FROM users WHERE id = ?;"},
+	}, results[0].Queries)
+
+	// only the changed package is extracted again
+	writeFiles(t, root, map[string]string{
+		"store/post.go": `package store
+
+import "database/sql"
+
+func DeletePost(db *sql.DB, id int) error {
+	_, err := db.Exec("DELETE FROM posts WHERE id = ? LIMIT 1", id)
+	return err
+}
+`,
+	})
+	cached, err := Extract(root, opts)
+	assert.NoError(t, err)
+	assert.Len(t, cached, 2)
+	assert.True(t, cached[0].Cached)
+	assert.Equal(t, results[0].Queries, cached[0].Queries)
+	assert.False(t, cached[1].Cached)
+	assert.Equal(t, "DELETE FROM posts WHERE id = ? LIMIT 1;", cached[1].Queries[0].content)
+}
+
+func TestExtractDependencyChanged(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.23\n",
+		"main.go": `package main
+
+import (
+	"database/sql"
+
+	"example.com/app/schema"
+)
+
+var db *sql.DB
+
+func getUser(id int) error {
+	return db.QueryRow("SELECT * FROM "+schema.Users+" WHERE id = ?", id).Err()
+}
+
+func main() {}
+`,
+		"schema/schema.go": "package schema\n\nconst Users = \"users\"\n",
+	})
+	opts := Options{CacheFile: filepath.Join(t.TempDir(), "cache.json")}
+
+	results, err := Extract(root, opts)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?;", results[0].Queries[0].content)
+
+	// the package importing the changed package is extracted again
+	writeFiles(t, root, map[string]string{
+		"schema/schema.go": "package schema\n\nconst Users = \"members\"\n",
+	})
+	results, err = Extract(root, opts)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.False(t, results[0].Cached)
+	assert.Equal(t, "SELECT * FROM members WHERE id = ?;", results[0].Queries[0].content)
+}
@@ -0,0 +1,78 @@
+package normalizer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/traP-jp/isuc/sql_parser"
+)
+
+// Literal is a string or number literal replaced with a placeholder
+type Literal struct {
+	// Index is the position of the placeholder among all placeholders of the replaced query
+	Index int
+	// Value is a string, int64 or float64
+	Value interface{}
+}
+
+// FingerprintQuery normalizes query like NormalizeQuery after replacing its string and number literals with ?,
+// in the style of pt-query-digest, so that `WHERE id = 1` and `WHERE id = 2` have the same fingerprint.
+// The replaced literals are returned in the order of appearance.
+func FingerprintQuery(query string) (string, []Literal) {
+	replaced, literals := ReplaceLiterals(query)
+	return NormalizeQuery(replaced), literals
+}
+
+// ReplaceLiterals replaces the string and number literals in query with ? and leaves the rest untouched,
+// so that the returned query can be executed with the literals as args.
+// Identifiers (`t1`, users.col2) and the existing placeholders are not replaced.
+func ReplaceLiterals(query string) (string, []Literal) {
+	tokens := sql_parser.TokenizeRaw(query)
+
+	var b strings.Builder
+	var literals []Literal
+	placeholders := 0
+	last := 0
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		var value interface{}
+		end := t.Pos + len(t.Raw)
+		switch {
+		case t.IsSymbol("?"):
+			placeholders++
+			continue
+		case t.IsString():
+			value = t.Literal()
+		case t.IsNumber():
+			// 1.5 is split into 1, . and 5 by the lexer
+			if i+2 < len(tokens) && tokens[i+1].Raw == "." && !tokens[i+1].Space && tokens[i+2].IsNumber() && !tokens[i+2].Space {
+				end = tokens[i+2].Pos + len(tokens[i+2].Raw)
+				i += 2
+			}
+			number, ok := parseNumber(query[t.Pos:end])
+			if !ok {
+				continue
+			}
+			value = number
+		default:
+			continue
+		}
+		b.WriteString(query[last:t.Pos])
+		b.WriteByte('?')
+		last = end
+		literals = append(literals, Literal{Index: placeholders, Value: value})
+		placeholders++
+	}
+	b.WriteString(query[last:])
+	return b.String(), literals
+}
+
+func parseNumber(s string) (interface{}, bool) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true
+	}
+	return nil, false
+}
@@ -0,0 +1,53 @@
+package normalizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprintQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+		literals []Literal
+	}{
+		{
+			query:    "SELECT * FROM users WHERE id = ?",
+			expected: "SELECT * FROM users WHERE id = ?;",
+		},
+		{
+			query:    "SELECT * FROM `users` WHERE `id` = 42",
+			expected: "SELECT * FROM users WHERE id = ?;",
+			literals: []Literal{{Index: 0, Value: int64(42)}},
+		},
+		{
+			query:    "SELECT * FROM t1 WHERE t1.col2 = ? AND name = 'it''s \\'ok\\'' AND score > 1.5 LIMIT 10",
+			expected: "SELECT * FROM t1 WHERE t1.col2 = ? AND name = ? AND score > ? LIMIT ?;",
+			literals: []Literal{{Index: 1, Value: "it's 'ok'"}, {Index: 2, Value: 1.5}, {Index: 3, Value: int64(10)}},
+		},
+		{
+			query:    "DELETE FROM posts WHERE id IN (1, 2, 3)",
+			expected: "DELETE FROM posts WHERE id IN (?);",
+			literals: []Literal{{Index: 0, Value: int64(1)}, {Index: 1, Value: int64(2)}, {Index: 2, Value: int64(3)}},
+		},
+		{
+			query:    "UPDATE users SET `2fa` = \"on\" WHERE name LIKE 'a\\_%'",
+			expected: "UPDATE users SET 2fa = ? WHERE name LIKE ?;",
+			literals: []Literal{{Index: 0, Value: "on"}, {Index: 1, Value: "a\\_%"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			actual, literals := FingerprintQuery(tt.query)
+			assert.Equal(t, tt.expected, actual)
+			assert.Equal(t, tt.literals, literals)
+		})
+	}
+}
+
+func TestReplaceLiterals(t *testing.T) {
+	replaced, literals := ReplaceLiterals("SELECT * FROM `users`\n\tWHERE `group_id` = 3 AND `id` = ?")
+	assert.Equal(t, "SELECT * FROM `users`\n\tWHERE `group_id` = ? AND `id` = ?", replaced)
+	assert.Equal(t, []Literal{{Index: 0, Value: int64(3)}}, literals)
+}
@@ -0,0 +1,51 @@
+package normalizer
+
+import "strings"
+
+// BindNamedQuery replaces the named placeholders (:name) in query with ? and returns the names in the order of appearance.
+// Quoted strings and identifiers are left untouched, so that '12:30:00' is not treated as a placeholder.
+// The backslash escapes and the doubled quotes are skipped in the strings.
+func BindNamedQuery(query string) (string, []string) {
+	var b strings.Builder
+	var names []string
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if quote != 0 {
+			b.WriteByte(ch)
+			switch {
+			case ch == '\\' && quote != '`' && i+1 < len(query):
+				// the escaped byte never closes the string
+				i++
+				b.WriteByte(query[i])
+			case ch == quote && i+1 < len(query) && query[i+1] == quote:
+				// the doubled quote is the quote itself
+				i++
+				b.WriteByte(query[i])
+			case ch == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+			b.WriteByte(ch)
+		case ch == ':' && i+1 < len(query) && isNameStart(query[i+1]) && (i == 0 || query[i-1] != ':'):
+			j := i + 1
+			for j < len(query) && (isNameStart(query[j]) || (query[j] >= '0' && query[j] <= '9')) {
+				j++
+			}
+			names = append(names, query[i+1:j])
+			b.WriteByte('?')
+			i = j - 1
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String(), names
+}
+
+func isNameStart(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_'
+}
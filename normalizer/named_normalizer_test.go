@@ -0,0 +1,53 @@
+package normalizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindNamedQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+		names    []string
+	}{
+		{
+			query:    "SELECT * FROM users WHERE id = ?;",
+			expected: "SELECT * FROM users WHERE id = ?;",
+		},
+		{
+			query:    "UPDATE users SET name = :name WHERE id = :id;",
+			expected: "UPDATE users SET name = ? WHERE id = ?;",
+			names:    []string{"name", "id"},
+		},
+		{
+			query:    "SELECT * FROM users WHERE id IN (:ids) AND created_at > '12:30:00' LIMIT :limit;",
+			expected: "SELECT * FROM users WHERE id IN (?) AND created_at > '12:30:00' LIMIT ?;",
+			names:    []string{"ids", "limit"},
+		},
+		{
+			query:    "INSERT INTO users (`name:x`, id) VALUES (:name, :user_id2);",
+			expected: "INSERT INTO users (`name:x`, id) VALUES (?, ?);",
+			names:    []string{"name", "user_id2"},
+		},
+		{
+			query:    `SELECT * FROM t WHERE a = '\\' AND b = :b;`,
+			expected: `SELECT * FROM t WHERE a = '\\' AND b = ?;`,
+			names:    []string{"b"},
+		},
+		{
+			query:    `SELECT * FROM t WHERE a = 'it\'s :x' AND b = 'it''s :y' AND c = :c;`,
+			expected: `SELECT * FROM t WHERE a = 'it\'s :x' AND b = 'it''s :y' AND c = ?;`,
+			names:    []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			actual, names := BindNamedQuery(tt.query)
+			assert.Equal(t, tt.expected, actual)
+			assert.Equal(t, tt.names, names)
+		})
+	}
+}
@@ -1,39 +1,109 @@
 package normalizer
 
 import (
-	"regexp"
 	"strings"
+
+	"github.com/traP-jp/isuc/sql_parser"
 )
 
-var spaceRegex = regexp.MustCompile(`\s+`)
-var insertRegex = regexp.MustCompile(`INSERT INTO (\w+)\s*\(`)
-var inRegex = regexp.MustCompile(`IN\s*\((\?,\s*)+\?\)`)
-var valuesRegex = regexp.MustCompile(`VALUES\s*\((\?,\s*)+\?\)`)
+// keywords are case-folded in addition to the reserved words of sql_parser.
+// Only the words that cannot be used as unquoted identifiers in MySQL are listed.
+var keywords = map[string]struct{}{
+	"ALL": {}, "BETWEEN": {}, "BY": {}, "CASE": {}, "CROSS": {}, "DEFAULT": {}, "DISTINCT": {}, "DIV": {},
+	"DUPLICATE": {}, "ELSE": {}, "EXISTS": {}, "FALSE": {}, "FOR": {}, "GROUP": {}, "HAVING": {}, "IGNORE": {},
+	"INNER": {}, "INTERVAL": {}, "IS": {}, "JOIN": {}, "KEY": {}, "LEFT": {}, "LOCK": {}, "MOD": {}, "NOT": {},
+	"NULL": {}, "ON": {}, "OR": {}, "ORDER": {}, "OUTER": {}, "REGEXP": {}, "REPLACE": {}, "RIGHT": {}, "THEN": {},
+	"TRUE": {}, "UNION": {}, "USING": {}, "WHEN": {}, "XOR": {},
+}
 
+// NormalizeQuery normalizes query on the tokens of sql_parser, so that the literals are left untouched.
+//   - whitespace between tokens is collapsed into a single space
+//   - reserved words and keywords are upper-cased
+//   - backquotes around identifiers are removed
+//   - INSERT INTO table(...) -> INSERT INTO table (...)
+//   - IN (?, ?, ?) -> IN (?)
+//   - VALUES (?, ?), (?, ?) -> VALUES (?)
+//   - a semicolon is added at the end
 func NormalizeQuery(query string) string {
-	// remove spaces
-	query = strings.ReplaceAll(query, "\r", " ")
-	query = strings.ReplaceAll(query, "\n", " ")
-	query = strings.ReplaceAll(query, "\t", " ")
-	query = spaceRegex.ReplaceAllString(query, " ")
-	query = strings.TrimSpace(query)
+	tokens := sql_parser.TokenizeRaw(query)
 
-	// remove backquotes
-	query = strings.ReplaceAll(query, "`", "")
+	var b strings.Builder
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if i > 0 && (t.Space || isInsertColumns(tokens, i)) {
+			b.WriteByte(' ')
+		}
 
-	// INSERT INTO table(... -> INSERT INTO table (...
-	query = insertRegex.ReplaceAllString(query, "INSERT INTO $1 (")
+		if t.IsReserved() && (t.Literal() == "IN" || t.Literal() == "VALUES") {
+			if end, ok := placeholderTuples(tokens, i+1, t.Literal() == "VALUES"); ok {
+				b.WriteString(t.Literal())
+				b.WriteString(" (?)")
+				i = end - 1
+				continue
+			}
+		}
 
-	// IN (?, ?, ?) -> IN (?)
-	query = inRegex.ReplaceAllString(query, "IN (?)")
+		switch {
+		case t.IsReserved():
+			b.WriteString(t.Literal())
+		case t.IsQuotedIdentifier():
+			b.WriteString(t.Literal())
+		case t.IsIdentifier():
+			if _, ok := keywords[strings.ToUpper(t.Raw)]; ok {
+				b.WriteString(strings.ToUpper(t.Raw))
+			} else {
+				b.WriteString(t.Raw)
+			}
+		default:
+			b.WriteString(t.Raw)
+		}
+	}
 
-	// VALUES (?, ?, ?) -> VALUES (?)
-	query = valuesRegex.ReplaceAllString(query, "VALUES (?)")
+	normalized := b.String()
+	if !strings.HasSuffix(normalized, ";") {
+		normalized += ";"
+	}
+	return normalized
+}
 
-	// add semicolon
-	if !strings.HasSuffix(query, ";") {
-		query += ";"
+// isInsertColumns reports whether tokens[i] is the opening parenthesis of INSERT INTO table(...)
+func isInsertColumns(tokens []sql_parser.RawToken, i int) bool {
+	return i >= 3 && tokens[i].IsSymbol("(") && tokens[i-1].IsIdentifier() &&
+		tokens[i-2].IsReserved() && tokens[i-2].Literal() == "INTO" &&
+		tokens[i-3].IsReserved() && tokens[i-3].Literal() == "INSERT"
+}
+
+// placeholderTuples matches (?, ?, ...) starting at tokens[start] and returns the end of the match.
+// If multiple is true, the following tuples separated by commas are matched as well.
+func placeholderTuples(tokens []sql_parser.RawToken, start int, multiple bool) (int, bool) {
+	end, ok := placeholderTuple(tokens, start)
+	if !ok {
+		return 0, false
+	}
+	for multiple && end+1 < len(tokens) && tokens[end].IsSymbol(",") {
+		next, ok := placeholderTuple(tokens, end+1)
+		if !ok {
+			break
+		}
+		end = next
 	}
+	return end, true
+}
 
-	return query
+func placeholderTuple(tokens []sql_parser.RawToken, start int) (int, bool) {
+	if start >= len(tokens) || !tokens[start].IsSymbol("(") {
+		return 0, false
+	}
+	for i := start + 1; i+1 < len(tokens); i += 2 {
+		if !tokens[i].IsSymbol("?") {
+			return 0, false
+		}
+		switch {
+		case tokens[i+1].IsSymbol(")"):
+			return i + 2, true
+		case !tokens[i+1].IsSymbol(","):
+			return 0, false
+		}
+	}
+	return 0, false
 }
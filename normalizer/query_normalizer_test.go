@@ -13,15 +13,15 @@ func TestNormalizeQuery(t *testing.T) {
 	}{
 		{
 			query:    "   SELECT * from table;   ",
-			expected: "SELECT * from table;",
+			expected: "SELECT * FROM table;",
 		},
 		{
 			query:    "SELECT *   \t\n from table;",
-			expected: "SELECT * from table;",
+			expected: "SELECT * FROM table;",
 		},
 		{
 			query:    "SELECT `id` from table;",
-			expected: "SELECT id from table;",
+			expected: "SELECT id FROM table;",
 		},
 		{
 			query:    "INSERT INTO table(name, col) VALUES (?, ?);",
@@ -43,6 +43,18 @@ func TestNormalizeQuery(t *testing.T) {
 			query:    "INSERT INTO users (name, display_name, description, password) VALUES(?, ?, ?, ?);",
 			expected: "INSERT INTO users (name, display_name, description, password) VALUES (?);",
 		},
+		{
+			query:    "insert into `user_2fa`(`id`, `secret`) values (?, ?), (?, ?)",
+			expected: "INSERT INTO user_2fa (id, secret) VALUES (?);",
+		},
+		{
+			query:    "SELECT * FROM users WHERE name = 'a  `b`\\'  c' AND bio LIKE \"%\n%\" order\n by created_at desc",
+			expected: "SELECT * FROM users WHERE name = 'a  `b`\\'  c' AND bio LIKE \"%\n%\" ORDER BY created_at DESC;",
+		},
+		{
+			query:    "select count(*) from t1 inner join t2 on t1.id = t2.t1_id where t2.deleted_at is not null and t1.id in (?,?)",
+			expected: "SELECT COUNT(*) FROM t1 INNER JOIN t2 ON t1.id = t2.t1_id WHERE t2.deleted_at IS NOT NULL AND t1.id IN (?);",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.query, func(t *testing.T) {
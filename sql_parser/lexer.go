@@ -13,8 +13,10 @@ const (
 	tokenType_SYMBOL     tokenType = "symbol"
 	tokenType_STRING     tokenType = "string"
 	tokenType_NUMBER     tokenType = "number"
-	tokenType_EOF        tokenType = "eof"
-	tokenType_UNKNOWN    tokenType = "unknown"
+	// named placeholder (:name) used by sqlx; the literal is the name without the colon
+	tokenType_PLACEHOLDER tokenType = "placeholder"
+	tokenType_EOF         tokenType = "eof"
+	tokenType_UNKNOWN     tokenType = "unknown"
 )
 
 type token struct {
@@ -34,6 +36,8 @@ func (t token) String() string {
 		return fmt.Sprintf("<%s(\"%s\")>", t.Type, t.Literal)
 	case tokenType_NUMBER:
 		return fmt.Sprintf("<%s(%s)>", t.Type, t.Literal)
+	case tokenType_PLACEHOLDER:
+		return fmt.Sprintf("<%s(:%s)>", t.Type, t.Literal)
 	case tokenType_EOF:
 		return fmt.Sprintf("<%s>", t.Type)
 	case tokenType_UNKNOWN:
@@ -51,9 +55,11 @@ func (t token) toSQL() string {
 	case tokenType_SYMBOL:
 		return t.Literal
 	case tokenType_STRING:
-		return "'" + strings.ReplaceAll(t.Literal, "'", "''") + "'"
+		return "'" + stringEscaper.Replace(t.Literal) + "'"
 	case tokenType_NUMBER:
 		return t.Literal
+	case tokenType_PLACEHOLDER:
+		return ":" + t.Literal
 	case tokenType_EOF:
 		return ""
 	case tokenType_UNKNOWN:
@@ -62,6 +68,23 @@ func (t token) toSQL() string {
 	return "unknown"
 }
 
+var stringEscaper = strings.NewReplacer(`\`, `\\`, "'", "''")
+
+var symbols = []string{",", "=", "!=", "<", ">", "<=", ">=", "(", ")", "*", "?", ";"}
+
+var reserved = []string{"SELECT", "FROM", "AS", "UPDATE", "SET", "DELETE", "INSERT", "INTO", "VALUES", "WHERE", "AND", "IN", "LIKE", "GROUP BY", "ORDER BY", "ASC", "DESC", "LIMIT", "OFFSET"}
+
+// reservedWords are the words of each reserved word split ahead of lexing
+var reservedWords = func() [][]string {
+	words := make([][]string, len(reserved))
+	for i, r := range reserved {
+		words[i] = strings.Split(r, " ")
+	}
+	return words
+}()
+
+var functions = []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+
 type lexer struct {
 	input string
 	pos   int
@@ -78,7 +101,15 @@ func (l *lexer) NextToken() token {
 		return token{Type: tokenType_EOF, Literal: ""}
 	}
 
-	symbols := []string{",", "=", "!=", "<", ">", "<=", ">=", "(", ")", "*", "?", ";"}
+	if l.input[l.pos] == ':' && l.pos+1 < len(l.input) && isLetter(l.input[l.pos+1]) {
+		l.pos++
+		start := l.pos
+		for l.pos < len(l.input) && (isLetter(l.input[l.pos]) || isNumber(l.input[l.pos])) {
+			l.pos++
+		}
+		return token{Type: tokenType_PLACEHOLDER, Literal: l.input[start:l.pos]}
+	}
+
 	for _, s := range symbols {
 		if strings.HasPrefix(l.input[l.pos:], s) {
 			l.pos += len(s)
@@ -86,18 +117,16 @@ func (l *lexer) NextToken() token {
 		}
 	}
 
-	str := l.input[l.pos:]
-	reserved := []string{"SELECT", "FROM", "AS", "UPDATE", "SET", "DELETE", "INSERT", "INTO", "VALUES", "WHERE", "AND", "IN", "LIKE", "GROUP BY", "ORDER BY", "ASC", "DESC", "LIMIT", "OFFSET"}
-	for _, r := range reserved {
-		if strings.HasPrefix(strings.ToUpper(str), r) && (len(str) == len(r) || !isLetter(str[len(r)])) {
-			l.pos += len(r)
+	for i, r := range reserved {
+		if n, ok := l.matchReserved(reservedWords[i]); ok {
+			l.pos += n
 			return token{Type: tokenType_RESERVED, Literal: r}
 		}
 	}
 
-	functions := []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+	str := l.input[l.pos:]
 	for _, f := range functions {
-		if strings.HasPrefix(strings.ToUpper(str), f) && (len(str) == len(f) || str[len(f)] == '(') {
+		if len(str) >= len(f) && strings.EqualFold(str[:len(f)], f) && (len(str) == len(f) || str[len(f)] == '(') {
 			l.pos += len(f)
 			return token{Type: tokenType_RESERVED, Literal: f}
 		}
@@ -120,7 +149,7 @@ func (l *lexer) NextToken() token {
 	ch := l.input[l.pos]
 	if isLetter(ch) {
 		start := l.pos
-		for l.pos < len(l.input) && isLetter(l.input[l.pos]) {
+		for l.pos < len(l.input) && (isLetter(l.input[l.pos]) || isNumber(l.input[l.pos])) {
 			l.pos++
 		}
 		literal := l.input[start:l.pos]
@@ -139,24 +168,91 @@ func (l *lexer) NextToken() token {
 	return token{Type: tokenType_UNKNOWN, Literal: string(ch)}
 }
 
+// matchReserved matches the case-insensitive words of a reserved word at the current position.
+// The words of "ORDER BY" and "GROUP BY" may be separated by any whitespace.
+func (l *lexer) matchReserved(words []string) (int, bool) {
+	pos := l.pos
+	for i, word := range words {
+		if i > 0 {
+			start := pos
+			for pos < len(l.input) && isWhitespace(l.input[pos]) {
+				pos++
+			}
+			if pos == start {
+				return 0, false
+			}
+		}
+		if len(l.input)-pos < len(word) || !strings.EqualFold(l.input[pos:pos+len(word)], word) {
+			return 0, false
+		}
+		pos += len(word)
+	}
+	if pos < len(l.input) && (isLetter(l.input[pos]) || isNumber(l.input[pos])) {
+		return 0, false
+	}
+	return pos - l.pos, true
+}
+
 func (l *lexer) skipWhitespace() {
-	for l.pos < len(l.input) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t' || l.input[l.pos] == '\n') {
+	for l.pos < len(l.input) && isWhitespace(l.input[l.pos]) {
 		l.pos++
 	}
 }
 
+// readString reads the quoted string at the current position and returns the unescaped content.
+// Both the backslash escapes and the doubled quotes are supported.
 func (l *lexer) readString(quote byte) (string, bool) {
 	if l.input[l.pos] != quote {
 		return "", false
 	}
 	l.pos++
-	start := l.pos
-	for l.pos < len(l.input) && (l.input[l.pos] != quote || l.input[l.pos-1] == '\\') {
+	var b strings.Builder
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		switch {
+		case ch == '\\' && quote != '`' && l.pos+1 < len(l.input):
+			l.pos++
+			if l.input[l.pos] == '%' || l.input[l.pos] == '_' {
+				// \% and \_ are kept as is for LIKE patterns
+				b.WriteByte('\\')
+			}
+			b.WriteByte(unescape(l.input[l.pos]))
+		case ch == quote && l.pos+1 < len(l.input) && l.input[l.pos+1] == quote:
+			l.pos++
+			b.WriteByte(quote)
+		case ch == quote:
+			l.pos++
+			return b.String(), true
+		default:
+			b.WriteByte(ch)
+		}
 		l.pos++
 	}
-	result := l.input[start:l.pos]
-	l.pos++
-	return result, true
+	// unterminated string
+	return b.String(), true
+}
+
+func unescape(ch byte) byte {
+	switch ch {
+	case '0':
+		return 0
+	case 'b':
+		return '\b'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 0x1a
+	default:
+		return ch
+	}
+}
+
+func isWhitespace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
 
 func isLetter(ch byte) bool {
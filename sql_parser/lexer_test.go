@@ -208,6 +208,27 @@ func TestLexer(t *testing.T) {
 				{Type: tokenType_EOF, Literal: ""},
 			},
 		},
+		{
+			input: "UPDATE users SET name = :name WHERE id = :user_id2 AND created_at > '12:30:00';",
+			expected: []token{
+				{Type: tokenType_RESERVED, Literal: "UPDATE"},
+				{Type: tokenType_IDENTIFIER, Literal: "users"},
+				{Type: tokenType_RESERVED, Literal: "SET"},
+				{Type: tokenType_IDENTIFIER, Literal: "name"},
+				{Type: tokenType_SYMBOL, Literal: "="},
+				{Type: tokenType_PLACEHOLDER, Literal: "name"},
+				{Type: tokenType_RESERVED, Literal: "WHERE"},
+				{Type: tokenType_IDENTIFIER, Literal: "id"},
+				{Type: tokenType_SYMBOL, Literal: "="},
+				{Type: tokenType_PLACEHOLDER, Literal: "user_id2"},
+				{Type: tokenType_RESERVED, Literal: "AND"},
+				{Type: tokenType_IDENTIFIER, Literal: "created_at"},
+				{Type: tokenType_SYMBOL, Literal: ">"},
+				{Type: tokenType_STRING, Literal: "12:30:00"},
+				{Type: tokenType_SYMBOL, Literal: ";"},
+				{Type: tokenType_EOF, Literal: ""},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -148,7 +148,10 @@ type NumberNode struct {
 	Value int
 }
 
-type PlaceholderNode struct{}
+type PlaceholderNode struct {
+	// Name is the name of the named placeholder (:name), empty for ?
+	Name string
+}
 
 type parser struct {
 	tokens []token
@@ -663,6 +666,10 @@ func (p *parser) limit() (LimitNode, error) {
 	if p.expect(token{Type: tokenType_SYMBOL, Literal: "?"}) {
 		return LimitNode{Limit: PlaceholderNode{}}, nil
 	}
+	if t.Type == tokenType_PLACEHOLDER {
+		p.consume()
+		return LimitNode{Limit: PlaceholderNode{Name: t.Literal}}, nil
+	}
 	return LimitNode{}, fmt.Errorf("<limit> got unexpected token %v", t.String())
 }
 
@@ -679,6 +686,10 @@ func (p *parser) offset() (OffsetNode, error) {
 	if p.expect(token{Type: tokenType_SYMBOL, Literal: "?"}) {
 		return OffsetNode{Offset: PlaceholderNode{}}, nil
 	}
+	if t.Type == tokenType_PLACEHOLDER {
+		p.consume()
+		return OffsetNode{Offset: PlaceholderNode{Name: t.Literal}}, nil
+	}
 	return OffsetNode{}, fmt.Errorf("<offset> got unexpected token %v", t.String())
 }
 
@@ -771,6 +782,9 @@ func (p *parser) value() (SQLNode, error) {
 			return nil, fmt.Errorf("<value> failed to parse number %v", err)
 		}
 		return NumberNode{Value: parsed}, nil
+	case tokenType_PLACEHOLDER:
+		p.consume()
+		return PlaceholderNode{Name: t.Literal}, nil
 	case tokenType_SYMBOL:
 		if t.Literal == "?" {
 			p.consume()
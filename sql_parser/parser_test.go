@@ -389,6 +389,34 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "SELECT * FROM users WHERE id IN (:ids) LIMIT :limit",
+			input: []token{
+				{Type: tokenType_RESERVED, Literal: "SELECT"},
+				{Type: tokenType_SYMBOL, Literal: "*"},
+				{Type: tokenType_RESERVED, Literal: "FROM"},
+				{Type: tokenType_IDENTIFIER, Literal: "users"},
+				{Type: tokenType_RESERVED, Literal: "WHERE"},
+				{Type: tokenType_IDENTIFIER, Literal: "id"},
+				{Type: tokenType_RESERVED, Literal: "IN"},
+				{Type: tokenType_SYMBOL, Literal: "("},
+				{Type: tokenType_PLACEHOLDER, Literal: "ids"},
+				{Type: tokenType_SYMBOL, Literal: ")"},
+				{Type: tokenType_RESERVED, Literal: "LIMIT"},
+				{Type: tokenType_PLACEHOLDER, Literal: "limit"},
+				{Type: tokenType_EOF, Literal: ""},
+			},
+			expected: SelectStmtNode{
+				Values: SelectValuesNode{Values: []SQLNode{SelectValueAsteriskNode{}}},
+				Table:  TableNode{Name: "users"},
+				Conditions: &ConditionsNode{
+					Conditions: []ConditionNode{
+						{Column: ColumnNode{Name: "id"}, Operator: OperatorNode{Operator: Operator_IN}, Value: ValuesNode{Values: []SQLNode{PlaceholderNode{Name: "ids"}}}},
+					},
+				},
+				Limit: &LimitNode{Limit: PlaceholderNode{Name: "limit"}},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,86 @@
+package sql_parser
+
+import (
+	"slices"
+	"strings"
+)
+
+// EntityProjection reports whether the rows of query can be shared by primary key with the other queries on table,
+// i.e. query is like "SELECT col1, col2 FROM table WHERE ... ORDER BY ... LIMIT ...".
+// The projected columns are returned, or nil for "SELECT *".
+func EntityProjection(query string, table string) ([]string, bool) {
+	tokens := TokenizeRaw(query)
+	if len(tokens) == 0 || !isReservedWord(tokens[0], "SELECT") {
+		return nil, false
+	}
+
+	from := slices.IndexFunc(tokens, func(t RawToken) bool { return isReservedWord(t, "FROM") })
+	if from < 2 {
+		return nil, false
+	}
+	var projection []string
+	if from == 2 && tokens[1].IsSymbol("*") {
+		projection = nil
+	} else {
+		for i := 1; i < from; i += 2 {
+			if !tokens[i].IsIdentifier() || isRowKeyword(tokens[i]) {
+				return nil, false
+			}
+			if i+1 < from && !tokens[i+1].IsSymbol(",") {
+				return nil, false
+			}
+			projection = append(projection, tokens[i].Literal())
+		}
+		if tokens[from-1].IsSymbol(",") {
+			return nil, false
+		}
+	}
+
+	if from+1 >= len(tokens) || !tokens[from+1].IsIdentifier() || tokens[from+1].Literal() != table {
+		return nil, false
+	}
+	if from+2 < len(tokens) {
+		next := tokens[from+2]
+		if !isReservedWord(next, "WHERE") && !isReservedWord(next, "ORDER BY") && !isReservedWord(next, "LIMIT") && !next.IsSymbol(";") {
+			return nil, false
+		}
+	}
+	for _, t := range tokens[from+2:] {
+		if isReservedWord(t, "SELECT") || isReservedWord(t, "GROUP BY") || isRowKeyword(t) {
+			return nil, false
+		}
+	}
+	return projection, true
+}
+
+// ValuesArePlaceholders reports whether the values of the insert query are all placeholders,
+// i.e. none of them is a literal or an expression like NOW()
+func ValuesArePlaceholders(query string) bool {
+	tokens := TokenizeRaw(query)
+	values := slices.IndexFunc(tokens, func(t RawToken) bool { return isReservedWord(t, "VALUES") })
+	if values < 0 {
+		return false
+	}
+	for _, t := range tokens[values+1:] {
+		if !t.IsPlaceholder() && !t.IsSymbol("(") && !t.IsSymbol(")") && !t.IsSymbol(",") && !t.IsSymbol(";") {
+			return false
+		}
+	}
+	return true
+}
+
+func isReservedWord(t RawToken, word string) bool {
+	return t.IsReserved() && t.Literal() == word
+}
+
+// isRowKeyword reports whether t is a keyword changing the rows of a query on a single table
+func isRowKeyword(t RawToken) bool {
+	if !t.IsIdentifier() || t.IsQuotedIdentifier() {
+		return false
+	}
+	switch strings.ToUpper(t.Raw) {
+	case "DISTINCT", "HAVING", "UNION", "JOIN", "FOR":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package sql_parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntityProjection(t *testing.T) {
+	tests := []struct {
+		query      string
+		projection []string
+		ok         bool
+	}{
+		{"SELECT * FROM `users` WHERE `id` = ?;", nil, true},
+		{"SELECT `id`, `name` FROM `users` WHERE `group_id` = ? ORDER BY `id` LIMIT 10;", []string{"id", "name"}, true},
+		{"SELECT * FROM `users`;", nil, true},
+		{"SELECT * FROM users ORDER BY id DESC LIMIT ?", nil, true},
+		{"SELECT COUNT(*) FROM `users` WHERE `group_id` = ?;", nil, false},
+		{"SELECT DISTINCT `name` FROM `users`;", nil, false},
+		{"SELECT * FROM `users` JOIN `groups` ON `users`.`group_id` = `groups`.`id`;", nil, false},
+		{"SELECT `group_id` FROM `users` GROUP BY `group_id`;", nil, false},
+		{"SELECT * FROM `groups` WHERE `id` = ?;", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			projection, ok := EntityProjection(test.query, "users")
+			assert.Equal(t, test.ok, ok)
+			if ok {
+				assert.Equal(t, test.projection, projection)
+			}
+		})
+	}
+}
+
+func TestValuesArePlaceholders(t *testing.T) {
+	assert.True(t, ValuesArePlaceholders("INSERT INTO `users` (`name`, `age`) VALUES (?, ?), (?, ?);"))
+	assert.False(t, ValuesArePlaceholders("INSERT INTO `users` (`name`, `created_at`) VALUES (?, NOW());"))
+	assert.False(t, ValuesArePlaceholders("INSERT INTO `users` (`name`, `age`) VALUES (?, 1);"))
+	assert.False(t, ValuesArePlaceholders("INSERT INTO `users` SELECT * FROM `users`;"))
+}
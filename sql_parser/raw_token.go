@@ -0,0 +1,65 @@
+package sql_parser
+
+// RawToken is a token with its source text, used to rewrite a query without touching its literals
+type RawToken struct {
+	token
+	// Raw is the source text of the token
+	Raw string
+	// Pos is the offset of the token in the source
+	Pos int
+	// Space is true if the token is preceded by whitespace
+	Space bool
+}
+
+// TokenizeRaw splits input into tokens until EOF (exclusive)
+func TokenizeRaw(input string) []RawToken {
+	l := NewLexer(input)
+	var tokens []RawToken
+	for {
+		start := l.pos
+		l.skipWhitespace()
+		space := l.pos > start
+		start = l.pos
+		t := l.NextToken()
+		if t.Type == tokenType_EOF {
+			return tokens
+		}
+		tokens = append(tokens, RawToken{token: t, Raw: input[start:l.pos], Pos: start, Space: space})
+	}
+}
+
+// Literal is the case-folded reserved word, the unquoted identifier, the unescaped string or the name of the named placeholder
+func (t RawToken) Literal() string {
+	return t.token.Literal
+}
+
+func (t RawToken) IsReserved() bool {
+	return t.Type == tokenType_RESERVED
+}
+
+func (t RawToken) IsIdentifier() bool {
+	return t.Type == tokenType_IDENTIFIER
+}
+
+// IsQuotedIdentifier reports whether the token is an identifier quoted by backquotes
+func (t RawToken) IsQuotedIdentifier() bool {
+	return t.Type == tokenType_IDENTIFIER && len(t.Raw) > 0 && t.Raw[0] == '`'
+}
+
+func (t RawToken) IsString() bool {
+	return t.Type == tokenType_STRING
+}
+
+func (t RawToken) IsNumber() bool {
+	return t.Type == tokenType_NUMBER
+}
+
+// IsPlaceholder reports whether the token is ? or a named placeholder (:name)
+func (t RawToken) IsPlaceholder() bool {
+	return t.Type == tokenType_PLACEHOLDER || (t.Type == tokenType_SYMBOL && t.token.Literal == "?")
+}
+
+// IsSymbol reports whether the token is the symbol s
+func (t RawToken) IsSymbol(s string) bool {
+	return t.Type == tokenType_SYMBOL && t.token.Literal == s
+}
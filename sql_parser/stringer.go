@@ -233,5 +233,8 @@ func (n NumberNode) String() string {
 var _ SQLNode = PlaceholderNode{}
 
 func (n PlaceholderNode) String() string {
+	if n.Name != "" {
+		return ":" + n.Name
+	}
 	return "?"
 }
@@ -153,6 +153,26 @@ func TestStringer(t *testing.T) {
 			},
 			expected: "INSERT INTO users (name, age) VALUES ('Cathy', 30);",
 		},
+		{
+			input: UpdateStmtNode{
+				Table: TableNode{Name: "users"},
+				Sets: UpdateSetsNode{
+					Sets: []UpdateSetNode{
+						{Column: ColumnNode{Name: "name"}, Value: PlaceholderNode{Name: "name"}},
+					},
+				},
+				Conditions: &ConditionsNode{
+					Conditions: []ConditionNode{
+						{
+							Column:   ColumnNode{Name: "id"},
+							Operator: OperatorNode{Operator: Operator_EQ},
+							Value:    PlaceholderNode{Name: "id"},
+						},
+					},
+				},
+			},
+			expected: "UPDATE users SET name = :name WHERE id = :id;",
+		},
 	}
 
 	for _, test := range tests {
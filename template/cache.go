@@ -1,10 +1,15 @@
 package template
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -12,8 +17,282 @@ import (
 
 	"github.com/motoki317/sc"
 	"github.com/traP-jp/isuc/domains"
+	"github.com/traP-jp/isuc/normalizer"
+	"github.com/traP-jp/isuc/sql_parser"
 )
 
+// registry holds the caches of a plan and the queries they are looked up by
+type registry struct {
+	queryMap    map[string]domains.CachePlanQuery
+	tableSchema map[string]domains.TableSchema
+	// NOTE: no write happens to these maps after newRegistry, so they are safe to use in concurrent environment
+	caches       map[string]*cacheWithInfo
+	cacheByTable map[string][]*cacheWithInfo
+	// entities are the entity stores of the tables with primary keys
+	entities map[string]*entityStore
+	// updateSets are the assignments of the update queries written through the entity stores
+	updateSets map[string][]updateSet
+	// inQueries are the queries like "SELECT * FROM table WHERE a IN (?) AND b IN (?)" read through their point queries
+	inQueries map[string]*inQuery
+	// bound is the results of bindLiterals by the raw query
+	bound *sc.Cache[string, boundQuery]
+}
+
+var (
+	// registries are all the registries created by newRegistry,
+	// so that the caches of the connectors created WithPlan are also exported and purged
+	registriesMu sync.Mutex
+	registries   []*registry
+)
+
+// allCaches returns the caches of all the registries
+func allCaches() []*cacheWithInfo {
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	var caches []*cacheWithInfo
+	for _, r := range registries {
+		for _, cache := range r.caches {
+			caches = append(caches, cache)
+		}
+	}
+	return caches
+}
+
+func newRegistry(plan *domains.CachePlan, schema []domains.TableSchema) *registry {
+	r := &registry{
+		queryMap:     make(map[string]domains.CachePlanQuery),
+		tableSchema:  make(map[string]domains.TableSchema),
+		caches:       make(map[string]*cacheWithInfo),
+		cacheByTable: make(map[string][]*cacheWithInfo),
+		entities:     make(map[string]*entityStore),
+		updateSets:   make(map[string][]updateSet),
+		inQueries:    make(map[string]*inQuery),
+	}
+	// the queries with inlined literals are bounded by the capacity
+	r.bound = sc.NewMust(func(_ context.Context, query string) (boundQuery, error) {
+		return r.replaceLiterals(query), nil
+	}, 10*time.Minute, 10*time.Minute, sc.WithLRUBackend(4096))
+	for _, table := range schema {
+		r.tableSchema[table.TableName] = table
+		if entities := newEntityStore(table); entities != nil {
+			r.entities[table.TableName] = entities
+		}
+	}
+
+	for _, q := range plan.Queries {
+		query := *q
+		// queries with named placeholders (:name) reach the driver as ? through sqlx or bindNamedArgs
+		bound, _ := normalizer.BindNamedQuery(query.Query)
+		normalized := normalizer.NormalizeQuery(bound)
+		query.Query = normalized // make sure to use normalized query
+		r.queryMap[normalized] = query
+		if query.Type == domains.CachePlanQueryType_UPDATE {
+			if sets, ok := parseUpdateSets(normalized); ok {
+				r.updateSets[normalized] = sets
+			}
+		}
+		if query.Type != domains.CachePlanQueryType_SELECT || !query.Select.Cache {
+			continue
+		}
+
+		r.addCache(query)
+	}
+
+	// read the queries like "SELECT * FROM table WHERE pk IN (?, ?, ...)" by "SELECT * FROM table WHERE pk = ?" for each value,
+	// which is generated if the plan does not have it
+	var inQueries []domains.CachePlanQuery
+	for _, query := range r.queryMap {
+		if query.Type == domains.CachePlanQueryType_SELECT && query.Select.Cache && hasInCondition(query.Select.Conditions) && query.Select.Limit == nil && query.Select.Offset == nil {
+			inQueries = append(inQueries, query)
+		}
+	}
+	for _, query := range inQueries {
+		orders, ok := r.resultOrders(*query.Select)
+		if !ok {
+			continue
+		}
+		splits, ok := r.splitColumns(*query.Select)
+		if !ok {
+			continue
+		}
+		point := pointQuery(query)
+		// the conditions of the point query are rewritten into IN conditions to fetch many points at once
+		if conditions, ok := parseInConditions(point.Query); !ok || len(conditions) != len(query.Select.Conditions) || slices.ContainsFunc(conditions, func(c inCondition) bool { return len(c.args) != 1 }) {
+			continue
+		}
+		if _, ok := r.caches[point.Query]; !ok {
+			r.queryMap[point.Query] = point
+			r.addCache(point)
+		}
+		r.inQueries[query.Query] = &inQuery{point: r.caches[point.Query], orders: orders, splits: splits}
+	}
+
+	for _, cache := range r.caches {
+		r.cacheByTable[cache.info.Table] = append(r.cacheByTable[cache.info.Table], cache)
+		conditions := cache.info.Conditions
+		if cache.info.Limit != nil && !slices.ContainsFunc(conditions, func(c domains.CachePlanCondition) bool { return c.Operator != domains.CachePlanOperator_EQ }) {
+			cache.window = parseWindowArgs(cache.query)
+		}
+		if entities, ok := r.entities[cache.info.Table]; ok {
+			if projection, ok := sql_parser.EntityProjection(cache.query, cache.info.Table); ok {
+				cache.entities = entities
+				cache.projection = projection
+				cache.byPrimaryKey = cache.uniqueOnly && slices.Equal(entities.primaryKeys, []string{cache.info.Conditions[0].Column})
+				singleList := len(conditions) == 0 || (len(conditions) == 1 && conditions[0].Operator == domains.CachePlanOperator_EQ)
+				if !cache.uniqueOnly && singleList {
+					cache.lists = make(map[string]*maintainedList)
+				}
+			}
+		}
+	}
+	registriesMu.Lock()
+	registries = append(registries, r)
+	registriesMu.Unlock()
+	return r
+}
+
+func (r *registry) addCache(query domains.CachePlanQuery) {
+	r.caches[query.Query] = &cacheWithInfo{
+		Cache:      sc.NewMust(replaceFn, 10*time.Minute, 10*time.Minute),
+		query:      query.Query,
+		info:       *query.Select,
+		uniqueOnly: r.isSingleUniqueCondition(query.Select.Conditions, query.Select.Table),
+	}
+}
+
+func hasInCondition(conditions []domains.CachePlanCondition) bool {
+	return slices.ContainsFunc(conditions, func(c domains.CachePlanCondition) bool { return c.Operator == domains.CachePlanOperator_IN })
+}
+
+// pointQuery returns the query reading a combination of the values of the conditions of query,
+// i.e. "SELECT * FROM table WHERE a = ? AND b = ?" for "SELECT * FROM table WHERE a = ? AND b IN (?)"
+func pointQuery(query domains.CachePlanQuery) domains.CachePlanQuery {
+	selectQuery := *query.Select
+	selectQuery.Conditions = make([]domains.CachePlanCondition, len(query.Select.Conditions))
+	for i, condition := range query.Select.Conditions {
+		selectQuery.Conditions[i] = domains.CachePlanCondition{Column: condition.Column, Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: i}}
+	}
+	selectQuery.Estimate = nil
+	return domains.CachePlanQuery{
+		CachePlanQueryBase: &domains.CachePlanQueryBase{
+			Query: normalizer.NormalizeQuery(strings.ReplaceAll(query.Query, " IN (?)", " = ?")),
+			Type:  domains.CachePlanQueryType_SELECT,
+		},
+		Select: &selectQuery,
+	}
+}
+
+// inQuery is a query with IN conditions read by its point query for each combination of the values
+type inQuery struct {
+	// point is the cache of the point query
+	point *cacheWithInfo
+	// orders are the columns the rows of the point queries are merged by
+	orders []columnOrder
+	// splits are the positions of the IN conditions, whose columns split the rows fetched for many points at once
+	splits []int
+}
+
+// columnOrder is a column the rows are sorted by
+type columnOrder struct {
+	column domains.TableSchemaColumn
+	desc   bool
+}
+
+// resultOrders returns the columns the rows of the select query with IN conditions are sorted by.
+// Without ORDER BY, the order is known only for "pk IN (?, ?, ...)", where MySQL returns the rows in the order of the primary key.
+// It fails if a column is not selected or its values cannot be compared.
+func (r *registry) resultOrders(query domains.CachePlanSelectQuery) ([]columnOrder, bool) {
+	schema, ok := r.tableSchema[query.Table]
+	if !ok {
+		return nil, false
+	}
+	orders := query.Orders
+	if len(orders) == 0 {
+		var inColumns []string
+		for _, condition := range query.Conditions {
+			if condition.Operator == domains.CachePlanOperator_IN {
+				inColumns = append(inColumns, condition.Column)
+			}
+		}
+		var primaryKeys []string
+		for _, column := range schema.Columns {
+			if column.IsPrimary {
+				primaryKeys = append(primaryKeys, column.ColumnName)
+			}
+		}
+		// the rows of the other IN queries are in the order of the index MySQL happens to use
+		if len(primaryKeys) != 1 || !slices.Equal(inColumns, primaryKeys) {
+			return nil, false
+		}
+		orders = append(orders, domains.CachePlanOrder{Column: primaryKeys[0], Order: domains.CachePlanOrder_ASC})
+	}
+
+	columns := make([]columnOrder, len(orders))
+	for i, order := range orders {
+		column, ok := schema.Columns[order.Column]
+		if !ok || !slices.Contains(query.Targets, order.Column) {
+			return nil, false
+		}
+		switch column.DataType {
+		case domains.TableSchemaDataType_INT, domains.TableSchemaDataType_INT64, domains.TableSchemaDataType_DATETIME:
+		default:
+			// the strings are compared by the collation of the column
+			return nil, false
+		}
+		columns[i] = columnOrder{column: column, desc: order.Order == domains.CachePlanOrder_DESC}
+	}
+	return columns, true
+}
+
+// splitColumns returns the positions of the IN conditions of the select query.
+// It fails if their columns are not selected integers, whose values read from the database are compared with the args exactly.
+func (r *registry) splitColumns(query domains.CachePlanSelectQuery) ([]int, bool) {
+	schema := r.tableSchema[query.Table]
+	var splits []int
+	for i, condition := range query.Conditions {
+		if condition.Operator != domains.CachePlanOperator_IN {
+			continue
+		}
+		column, ok := schema.Columns[condition.Column]
+		if !ok || !slices.Contains(query.Targets, condition.Column) {
+			return nil, false
+		}
+		if column.DataType != domains.TableSchemaDataType_INT && column.DataType != domains.TableSchemaDataType_INT64 {
+			return nil, false
+		}
+		splits = append(splits, i)
+	}
+	return splits, true
+}
+
+func (r *registry) purgeAll() {
+	for _, cache := range r.caches {
+		cache.Purge()
+	}
+	for _, entities := range r.entities {
+		entities.purge()
+	}
+}
+
+// MetricsSink receives the cache events of a connector. The methods must be safe for concurrent use.
+type MetricsSink interface {
+	// Hit is called when the rows of query are returned from the cache
+	Hit(query string)
+	// Miss is called when the rows of query are fetched from the database into the cache
+	Miss(query string, elapsed time.Duration)
+	// Purge is called when the whole cache of query is purged
+	Purge(query string)
+	// Forget is called when the rows of query for a key are forgotten
+	Forget(query string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Hit(string)                 {}
+func (nopMetrics) Miss(string, time.Duration) {}
+func (nopMetrics) Purge(string)               {}
+func (nopMetrics) Forget(string)              {}
+
 type cacheWithInfo struct {
 	*sc.Cache[string, *cacheRows]
 	query           string
@@ -22,6 +301,65 @@ type cacheWithInfo struct {
 	lastUpdate      atomic.Int64 // time.Time.UnixNano()
 	lastUpdateByKey syncMap[int64]
 	replaceTime     atomic.Int64
+	// hits and misses are the lookups of the cached rows, counted apart from the stats of sc
+	// since the rows are looked up by GetIfExists before Get
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	loadsMu sync.Mutex
+	loads   map[string]*load
+	// entities is the entity store the rows are stored in, or nil if the cache holds the rows itself
+	entities *entityStore
+	// projection is the columns projected from the entities, or nil for all the columns
+	projection []string
+	// byPrimaryKey is true if the query is like "SELECT * FROM table WHERE pk = ?" on the entity store
+	byPrimaryKey bool
+	// lists are the cached lists of the entities maintained by inserts,
+	// or nil unless the query is like "SELECT * FROM table WHERE col = ?" on the entity store
+	listsMu sync.Mutex
+	lists   map[string]*maintainedList
+	// window is the positions of the args of "LIMIT ?" and "OFFSET ?", or nil unless the query has LIMIT.
+	// The rows are cached as the widest window fetched from the top for the other args, and the pages are sliced from it.
+	window *windowArgs
+	// windows are the number of the rows from the top covered by the windows cached last,
+	// used to drop a narrower window before reading it
+	windows syncMap[int]
+}
+
+// load is the context of the query shared by the callers of get waiting for the same key.
+// It is canceled only when all the callers are canceled, so that the callers do not cancel each other.
+type load struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	refs   int
+}
+
+func (c *cacheWithInfo) acquireLoad(ctx context.Context, key string) *load {
+	c.loadsMu.Lock()
+	defer c.loadsMu.Unlock()
+	if c.loads == nil {
+		c.loads = make(map[string]*load)
+	}
+	l, ok := c.loads[key]
+	if !ok {
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		l = &load{ctx: loadCtx, cancel: cancel}
+		c.loads[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (c *cacheWithInfo) releaseLoad(key string, l *load) {
+	c.loadsMu.Lock()
+	defer c.loadsMu.Unlock()
+	l.refs--
+	if l.refs > 0 {
+		return
+	}
+	l.cancel()
+	if c.loads[key] == l {
+		delete(c.loads, key)
+	}
 }
 
 func (c *cacheWithInfo) updateTx() {
@@ -42,10 +380,436 @@ func (c *cacheWithInfo) isNewerThan(key string, t int64) bool {
 	return false
 }
 
+// lookup returns the rows cached for key without running the query, counting a hit or a miss
+func (c *cacheWithInfo) lookup(key string) (*cacheRows, bool) {
+	rows, ok := c.GetIfExists(key)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return rows, ok
+}
+
+// stats is the stats of sc with the hits and the misses counted by lookup
+func (c *cacheWithInfo) stats() sc.Stats {
+	stats := c.Stats()
+	stats.Hits = c.hits.Load()
+	stats.GraceHits = 0
+	stats.Misses = c.misses.Load()
+	return stats
+}
+
 func (c *cacheWithInfo) RecordReplaceTime(time time.Duration) {
 	c.replaceTime.Add(time.Nanoseconds())
 }
 
+// get is cache.Get returning when ctx is done and reporting a hit or a miss to metrics.
+// The query run on a miss is canceled only when all the callers waiting for it are canceled.
+func (c *cacheWithInfo) get(ctx context.Context, key string, metrics MetricsSink) (*cacheRows, error) {
+	for range 3 {
+		rows, elapsed, err := c.getOnce(ctx, key)
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			// joined a query whose callers have all been canceled
+			rows, elapsed, err = c.getOnce(ctx, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if materialized, ok := c.materialize(key, rows); ok {
+			if elapsed > 0 {
+				metrics.Miss(c.query, elapsed)
+			} else {
+				metrics.Hit(c.query)
+			}
+			return materialized, nil
+		}
+		// some of the entities have been forgotten since the rows were cached
+		c.Forget(key)
+	}
+	return nil, fmt.Errorf("the entities of %q are forgotten repeatedly", c.query)
+}
+
+// getWindow returns the rows [offset, end) sliced from the window cached for key,
+// which is fetched again with LIMIT end if it is narrower
+func (c *cacheWithInfo) getWindow(ctx context.Context, key string, offset int, end int, metrics MetricsSink) (*cacheRows, error) {
+	ctx = context.WithValue(ctx, windowKey{}, end)
+	for range 3 {
+		if covered, ok := c.windows.Load(key); ok && covered < end {
+			// drop the narrower window without counting a hit
+			c.Forget(key)
+		}
+		rows, err := c.get(ctx, key, metrics)
+		if err != nil {
+			return nil, err
+		}
+		if rows.covered() >= end {
+			return rows.window(offset, end), nil
+		}
+		// joined the query of a narrower window
+		c.Forget(key)
+	}
+	return nil, fmt.Errorf("the window of %q is narrowed repeatedly", c.query)
+}
+
+// windowArgs is the positions of the args of "LIMIT ?" and "OFFSET ?" among the placeholders of a query, or -1
+type windowArgs struct {
+	limit  int
+	offset int
+}
+
+func parseWindowArgs(query string) *windowArgs {
+	w := &windowArgs{limit: -1, offset: -1}
+	tokens := sql_parser.TokenizeRaw(query)
+	placeholders := 0
+	for i, t := range tokens {
+		if !t.IsPlaceholder() {
+			continue
+		}
+		if i > 0 && isReserved(tokens[i-1], "LIMIT") {
+			w.limit = placeholders
+		}
+		if i > 0 && isReserved(tokens[i-1], "OFFSET") {
+			w.offset = placeholders
+		}
+		placeholders++
+	}
+	if w.limit < 0 {
+		return nil
+	}
+	return w
+}
+
+// split returns the key of the args other than LIMIT and OFFSET, and the rows [offset, end) requested by them.
+// It returns false if LIMIT or OFFSET is not a positive integer.
+func (w *windowArgs) split(args []driver.Value) (key string, offset int, end int, ok bool) {
+	if w.limit >= len(args) || w.offset >= len(args) {
+		return "", 0, 0, false
+	}
+	limit, ok := args[w.limit].(int64)
+	if !ok || limit <= 0 {
+		return "", 0, 0, false
+	}
+	var skip int64
+	if w.offset >= 0 {
+		skip, ok = args[w.offset].(int64)
+		if !ok || skip < 0 {
+			return "", 0, 0, false
+		}
+	}
+	keyArgs := make([]driver.Value, 0, len(args))
+	for i, arg := range args {
+		if i != w.limit && i != w.offset {
+			keyArgs = append(keyArgs, arg)
+		}
+	}
+	return cacheKey(keyArgs), int(skip), int(skip + limit), true
+}
+
+// widen returns the args fetching the rows [0, end)
+func (w *windowArgs) widen(args []driver.Value, end int) []driver.Value {
+	widened := slices.Clone(args)
+	widened[w.limit] = int64(end)
+	if w.offset >= 0 {
+		widened[w.offset] = int64(0)
+	}
+	return widened
+}
+
+// materialize projects the rows of key cached as the primary keys from the entity store,
+// and returns false if any of the entities has been forgotten
+func (c *cacheWithInfo) materialize(key string, rows *cacheRows) (*cacheRows, bool) {
+	if c.entities == nil {
+		return rows, true
+	}
+	rows, ok := c.listed(key, rows)
+	if !ok {
+		return nil, false
+	}
+	return c.project(rows)
+}
+
+func (c *cacheWithInfo) project(rows *cacheRows) (*cacheRows, bool) {
+	values, ok := c.entities.load(rows.keys, rows.columns)
+	if !ok {
+		return nil, false
+	}
+	return &cacheRows{cached: true, columns: rows.columns, rows: sliceRows{rows: values}, limit: rows.limit}, true
+}
+
+// maintainedList is a list cached as rows, and current is the list with the rows inserted since then
+type maintainedList struct {
+	cached  *cacheRows
+	current *cacheRows
+}
+
+// listed returns the current version of the list cached as rows,
+// or false if the list has been written while it was fetched
+func (c *cacheWithInfo) listed(key string, rows *cacheRows) (*cacheRows, bool) {
+	if c.lists == nil {
+		return rows, true
+	}
+	c.listsMu.Lock()
+	defer c.listsMu.Unlock()
+	list, ok := c.lists[key]
+	if !ok || list.cached != rows {
+		return nil, false
+	}
+	return list.current, true
+}
+
+// Forget is sc.Cache.Forget dropping the list maintained by inserts as well
+func (c *cacheWithInfo) Forget(key string) {
+	if c.lists == nil {
+		c.Cache.Forget(key)
+		return
+	}
+	c.listsMu.Lock()
+	defer c.listsMu.Unlock()
+	delete(c.lists, key)
+	c.Cache.Forget(key)
+}
+
+// Purge is sc.Cache.Purge dropping the lists maintained by inserts as well
+func (c *cacheWithInfo) Purge() {
+	if c.lists == nil {
+		c.Cache.Purge()
+		return
+	}
+	c.listsMu.Lock()
+	defer c.listsMu.Unlock()
+	clear(c.lists)
+	c.Cache.Purge()
+}
+
+// register keeps the list fetched at since to be maintained by inserts,
+// unless the list has been written since then and the fetched one may lack the write
+func (c *cacheWithInfo) register(key string, list *cacheRows, since int64) {
+	if c.lists == nil {
+		return
+	}
+	c.listsMu.Lock()
+	defer c.listsMu.Unlock()
+	if c.isNewerThan(key, since) {
+		// the list is fetched again on the next read
+		delete(c.lists, key)
+		return
+	}
+	c.lists[key] = &maintainedList{cached: list, current: list}
+}
+
+// insert adds the inserted row to the cached list of task.key in the order of the query.
+// The list is forgotten if the row cannot be placed in it, e.g. the list is not cached or the row lacks a selected column.
+func (c *cacheWithInfo) insert(task insertTask) {
+	c.listsMu.Lock()
+	defer c.listsMu.Unlock()
+	if list, ok := c.lists[task.key]; ok {
+		if inserted, ok := c.insertRow(list.current, task.row); ok {
+			list.current = inserted
+			if c.window != nil {
+				c.windows.Store(task.key, inserted.covered())
+			}
+			return
+		}
+	}
+	delete(c.lists, task.key)
+	c.Cache.Forget(task.key)
+}
+
+func (c *cacheWithInfo) insertRow(list *cacheRows, inserted *insertedRow) (*cacheRows, bool) {
+	for _, column := range list.columns {
+		if !slices.Contains(inserted.columns, column) {
+			return nil, false
+		}
+	}
+	key, ok := inserted.entityKey(c.entities.primaryKeys)
+	if !ok {
+		return nil, false
+	}
+	if slices.Contains(list.keys, key) {
+		// the list has been fetched after the insert
+		return list, true
+	}
+
+	existing, ok := c.entities.load(list.keys, inserted.columns)
+	if !ok {
+		return nil, false
+	}
+	values := make(row, len(inserted.columns))
+	for i, column := range inserted.columns {
+		var sample driver.Value
+		for _, e := range existing {
+			if e[i] != nil {
+				sample = e[i]
+				break
+			}
+		}
+		value, ok := insertValue(c.entities.columns[column], inserted.values[i], sample)
+		if !ok {
+			return nil, false
+		}
+		values[i] = value
+	}
+
+	orders := make([]int, len(c.info.Orders))
+	for i, order := range c.info.Orders {
+		orders[i] = slices.Index(inserted.columns, order.Column)
+		if orders[i] < 0 {
+			return nil, false
+		}
+	}
+	// the rows after the full window are not known
+	full := list.limit > 0 && len(list.keys) >= list.limit
+	if full && len(orders) == 0 {
+		return nil, false
+	}
+	// the new row is placed after the rows of the same order
+	pos := len(existing)
+	for i, e := range existing {
+		diff, ok := c.compareRows(values, e, orders)
+		if !ok {
+			return nil, false
+		}
+		if diff < 0 {
+			pos = i
+			break
+		}
+	}
+	if full && pos == len(existing) {
+		// the new row falls after the window
+		return list, true
+	}
+
+	keys := slices.Insert(slices.Clone(list.keys), pos, key)
+	if full {
+		keys = keys[:list.limit]
+	}
+	c.entities.add(key, inserted.columns, values)
+	return &cacheRows{cached: true, columns: list.columns, keys: keys, limit: list.limit}, true
+}
+
+// compareRows compares the rows in the order of the query, where orders are the indexes of the order columns
+func (c *cacheWithInfo) compareRows(a, b row, orders []int) (int, bool) {
+	for i, idx := range orders {
+		diff, ok := compareValues(a[idx], b[idx])
+		if !ok {
+			return 0, false
+		}
+		if c.info.Orders[i].Order == domains.CachePlanOrder_DESC {
+			diff = -diff
+		}
+		if diff != 0 {
+			return diff, true
+		}
+	}
+	return 0, true
+}
+
+// compareValues compares the values like MySQL, where NULL is the smallest
+func compareValues(a, b driver.Value) (int, bool) {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0, true
+		case a == nil:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Compare(a, b), true
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmp.Compare(a, b), true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b), true
+		}
+	}
+	return 0, false
+}
+
+// compareColumnValues compares the values of column read from the database,
+// which are []byte unless the driver parses them (e.g. DATETIME without parseTime=true)
+func compareColumnValues(column domains.TableSchemaColumn, a, b driver.Value) (int, bool) {
+	if a == nil || b == nil {
+		return compareValues(a, b)
+	}
+	switch column.DataType {
+	case domains.TableSchemaDataType_INT, domains.TableSchemaDataType_INT64:
+		a, b = parseIntValue(a), parseIntValue(b)
+	case domains.TableSchemaDataType_DATETIME:
+		// "2006-01-02 15:04:05.999999" is sorted as bytes
+		if a, ok := a.([]byte); ok {
+			if b, ok := b.([]byte); ok {
+				return bytes.Compare(a, b), true
+			}
+		}
+	}
+	return compareValues(a, b)
+}
+
+func parseIntValue(v driver.Value) driver.Value {
+	var s string
+	switch v := v.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return v
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	return v
+}
+
+// getOnce returns the time taken by the query if it has been run by the caller, or 0 if the rows are cached
+func (c *cacheWithInfo) getOnce(ctx context.Context, key string) (*cacheRows, time.Duration, error) {
+	if rows, ok := c.lookup(key); ok {
+		return rows, 0, nil
+	}
+
+	l := c.acquireLoad(ctx, key)
+	defer c.releaseLoad(key, l)
+
+	var replaced atomic.Int64
+	ctx = context.WithValue(ctx, replacedKey{}, &replaced)
+	ctx = context.WithValue(ctx, loadKey{}, l)
+
+	var rows *cacheRows
+	var err error
+	if ctx.Done() == nil {
+		rows, err = c.Get(ctx, key)
+	} else {
+		type result struct {
+			rows *cacheRows
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			rows, err := c.Get(ctx, key)
+			done <- result{rows, err}
+		}()
+		select {
+		case r := <-done:
+			rows, err = r.rows, r.err
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return rows, time.Duration(replaced.Load()), nil
+}
+
 type (
 	queryKey          struct{}
 	stmtKey           struct{}
@@ -53,19 +817,20 @@ type (
 	queryerCtxKey     struct{}
 	namedValueArgsKey struct{}
 	cacheWithInfoKey  struct{}
+	replacedKey       struct{}
+	loadKey           struct{}
+	windowKey         struct{}
+	inBatchKey        struct{}
 )
 
 func ExportMetrics() string {
-	cacheList := make([]*cacheWithInfo, 0, len(caches))
-	for _, cache := range caches {
-		cacheList = append(cacheList, cache)
-	}
+	cacheList := allCaches()
 	sort.SliceStable(cacheList, func(i, j int) bool {
 		return cacheList[i].replaceTime.Load() < cacheList[j].replaceTime.Load()
 	})
 	res := ""
 	for _, cache := range cacheList {
-		stats := cache.Stats()
+		stats := cache.stats()
 		progress := "["
 		for i := 0; i < 20; i++ {
 			if i < int(stats.HitRatio()*20) {
@@ -89,23 +854,29 @@ type CacheStats struct {
 	Misses   int
 }
 
+// ExportCacheStats returns the stats of the caches by query, summed over the connectors caching the same query
 func ExportCacheStats() map[string]CacheStats {
 	res := make(map[string]CacheStats)
-	for query, cache := range caches {
-		stats := cache.Stats()
-		res[query] = CacheStats{
-			Query:    query,
-			HitRatio: stats.HitRatio(),
-			Hits:     int(stats.Hits),
-			Misses:   int(stats.Misses),
+	for _, cache := range allCaches() {
+		stats := cache.stats()
+		total := res[cache.query]
+		total.Query = cache.query
+		total.Hits += int(stats.Hits)
+		total.Misses += int(stats.Misses)
+		if total.Hits+total.Misses > 0 {
+			total.HitRatio = float64(total.Hits) / float64(total.Hits+total.Misses)
 		}
+		res[cache.query] = total
 	}
 	return res
 }
 
+// PurgeAllCaches purges the caches of all the connectors
 func PurgeAllCaches() {
-	for _, cache := range caches {
-		cache.Purge()
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	for _, r := range registries {
+		r.purgeAll()
 	}
 }
 
@@ -136,34 +907,81 @@ func replaceFn(ctx context.Context, key string) (*cacheRows, error) {
 	defer func() {
 		elapsed := time.Since(start)
 		cache.RecordReplaceTime(elapsed)
+		if replaced, ok := ctx.Value(replacedKey{}).(*atomic.Int64); ok {
+			replaced.Store(max(elapsed.Nanoseconds(), 1))
+		}
 	}()
 
+	// ctx passed by sc is never canceled, so the query is run with the context shared by the callers
+	queryCtx := ctx
+	if l, ok := ctx.Value(loadKey{}).(*load); ok {
+		queryCtx = l.ctx
+	}
+
+	// the rows fetched together with other keys are cached as of the time they are fetched
+	since := start.UnixNano()
+	var rows driver.Rows
+	var err error
+	if batch, ok := ctx.Value(inBatchKey{}).(*inBatch); ok {
+		rows, since, err = batch.rows(queryCtx, key)
+	} else {
+		rows, err = queryForCache(queryCtx, ctx, cache)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cacheRows, err := newCacheRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if end, ok := ctx.Value(windowKey{}).(int); ok {
+		cacheRows.limit = end
+		cache.windows.Store(key, cacheRows.covered())
+	}
+	if cache.entities != nil {
+		if kept, ok := cache.entities.keep(cacheRows, cache.projection, since); ok {
+			kept.limit = cacheRows.limit
+			cache.register(key, kept, since)
+			return kept, nil
+		}
+		return nil, fmt.Errorf("the primary keys of %s are not found in the rows of %q", cache.entities.table, cache.query)
+	}
+	return cacheRows.clone(), nil
+}
+
+// queryForCache runs the query of the cache given by ctx.
+// The query of an entity cache is rewritten to "SELECT * ..." so that the whole rows are stored in the entity store.
+func queryForCache(queryCtx context.Context, ctx context.Context, cache *cacheWithInfo) (driver.Rows, error) {
 	queryerCtx, ok := ctx.Value(queryerCtxKey{}).(driver.QueryerContext)
 	if ok {
 		query := ctx.Value(queryKey{}).(string)
 		nvargs := ctx.Value(namedValueArgsKey{}).([]driver.NamedValue)
-		rows, err := queryerCtx.QueryContext(ctx, query, nvargs)
-		if err != nil {
-			return nil, err
+		if cache.entities != nil && cache.projection != nil {
+			query = selectAll(query)
 		}
-		cacheRows, err := newCacheRows(rows)
-		if err != nil {
-			return nil, err
-		}
-		return cacheRows.clone(), nil
+		return queryerCtx.QueryContext(queryCtx, query, nvargs)
 	}
 
 	stmt := ctx.Value(stmtKey{}).(*customCacheStatement)
 	args := ctx.Value(argsKey{}).([]driver.Value)
-	rows, err := stmt.inner.Query(args)
+	if cache.entities == nil || cache.projection == nil {
+		return queryStmt(queryCtx, stmt.inner, valueToNamedValue(args))
+	}
+	inner, err := stmt.conn.inner.Prepare(selectAll(stmt.rawQuery))
 	if err != nil {
 		return nil, err
 	}
+	defer inner.Close()
+	rows, err := queryStmt(queryCtx, inner, valueToNamedValue(args))
+	if err != nil {
+		return nil, err
+	}
+	// the rows are read before the statement is closed
 	cacheRows, err := newCacheRows(rows)
 	if err != nil {
 		return nil, err
 	}
-	return cacheRows.clone(), nil
+	return cacheRows, nil
 }
 
 type syncMap[T any] struct {
@@ -3,10 +3,15 @@ package template
 import (
 	"context"
 	"database/sql/driver"
+	"io"
+	"math"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/motoki317/sc"
 	"github.com/stretchr/testify/assert"
+	"github.com/traP-jp/isuc/domains"
 	dbtest "github.com/traP-jp/isuc/testutil/db"
 )
 
@@ -77,3 +82,269 @@ func TestCacheRows(t *testing.T) {
 	checkCache()
 	checkCache()
 }
+
+type blockingQueryer struct {
+	started chan context.Context
+	release chan struct{}
+}
+
+func (q *blockingQueryer) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	q.started <- ctx
+	select {
+	case <-q.release:
+		return &emptyRows{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type emptyRows struct{}
+
+func (r *emptyRows) Columns() []string           { return []string{"id"} }
+func (r *emptyRows) Close() error                { return nil }
+func (r *emptyRows) Next(_ []driver.Value) error { return io.EOF }
+
+func TestCacheGetSharedLoad(t *testing.T) {
+	tests := []struct {
+		name           string
+		cancelBoth     bool
+		expectCanceled bool
+	}{
+		{name: "one caller canceled", cancelBoth: false, expectCanceled: false},
+		{name: "all callers canceled", cancelBoth: true, expectCanceled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &cacheWithInfo{Cache: sc.NewMust(replaceFn, time.Minute, time.Minute), query: "SELECT id FROM users WHERE id = ?;"}
+			queryer := &blockingQueryer{started: make(chan context.Context, 1), release: make(chan struct{})}
+
+			get := func(ctx context.Context) error {
+				ctx = context.WithValue(ctx, queryerCtxKey{}, queryer)
+				ctx = context.WithValue(ctx, queryKey{}, cache.query)
+				ctx = context.WithValue(ctx, namedValueArgsKey{}, []driver.NamedValue{})
+				ctx = context.WithValue(ctx, cacheWithInfoKey{}, cache)
+				_, err := cache.get(ctx, "1", nopMetrics{})
+				return err
+			}
+
+			ctx1, cancel1 := context.WithCancel(context.Background())
+			ctx2, cancel2 := context.WithCancel(context.Background())
+			defer cancel2()
+			errs1, errs2 := make(chan error, 1), make(chan error, 1)
+			go func() { errs1 <- get(ctx1) }()
+			queryCtx := <-queryer.started
+			go func() { errs2 <- get(ctx2) }()
+			assert.Eventually(t, func() bool {
+				cache.loadsMu.Lock()
+				defer cache.loadsMu.Unlock()
+				return cache.loads["1"] != nil && cache.loads["1"].refs == 2
+			}, time.Second, time.Millisecond)
+
+			cancel1()
+			assert.ErrorIs(t, <-errs1, context.Canceled)
+			if tt.cancelBoth {
+				cancel2()
+				assert.ErrorIs(t, <-errs2, context.Canceled)
+			}
+
+			assert.Eventually(t, func() bool { return (queryCtx.Err() != nil) == tt.expectCanceled }, time.Second, time.Millisecond)
+			if !tt.cancelBoth {
+				close(queryer.release)
+				assert.NoError(t, <-errs2)
+			}
+		})
+	}
+}
+
+func TestCacheInsertRow(t *testing.T) {
+	entities := newEntityStore(domains.TableSchema{
+		TableName: "users",
+		Columns: map[string]domains.TableSchemaColumn{
+			"id":       {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true, IsAutoIncrement: true},
+			"name":     {ColumnName: "name", DataType: domains.TableSchemaDataType_STRING},
+			"group_id": {ColumnName: "group_id", DataType: domains.TableSchemaDataType_INT},
+		},
+	})
+	cache := &cacheWithInfo{
+		info:     domains.CachePlanSelectQuery{Orders: []domains.CachePlanOrder{{Column: "id", Order: domains.CachePlanOrder_DESC}}},
+		entities: entities,
+	}
+	fetched := &cacheRows{
+		cached:  true,
+		columns: []string{"id", "name", "group_id"},
+		rows:    sliceRows{rows: []row{{int64(3), "Carol", int64(1)}, {int64(1), "Alice", int64(1)}}},
+	}
+	list, ok := entities.keep(fetched, nil, 0)
+	assert.True(t, ok)
+
+	inserted := &insertedRow{columns: []string{"name", "group_id", "id"}, values: row{"Bob", int64(1), int64(2)}, autoIncrement: 2}
+	list, ok = cache.insertRow(list, inserted)
+	assert.True(t, ok)
+	rows, ok := entities.load(list.keys, list.columns)
+	assert.True(t, ok)
+	assert.Equal(t, []row{{int64(3), "Carol", int64(1)}, {int64(2), "Bob", int64(1)}, {int64(1), "Alice", int64(1)}}, rows)
+
+	// the list fetched after the insert already has the row
+	again, ok := cache.insertRow(list, inserted)
+	assert.True(t, ok)
+	assert.Equal(t, list.keys, again.keys)
+
+	// the row lacks a selected column
+	_, ok = cache.insertRow(list, &insertedRow{columns: []string{"name", "id"}, values: row{"Dave", int64(4)}, autoIncrement: 1})
+	assert.False(t, ok)
+}
+
+func TestCompareValues(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		a, b driver.Value
+		cmp  int
+		ok   bool
+	}{
+		{int64(1), int64(2), -1, true},
+		{int64(2), int64(2), 0, true},
+		{now.Add(time.Second), now, 1, true},
+		{nil, int64(1), -1, true},
+		{int64(1), nil, 1, true},
+		{"a", "b", 0, false},
+		{int64(1), now, 0, false},
+	}
+	for _, test := range tests {
+		cmp, ok := compareValues(test.a, test.b)
+		assert.Equal(t, test.ok, ok, "%v, %v", test.a, test.b)
+		assert.Equal(t, test.cmp, cmp, "%v, %v", test.a, test.b)
+	}
+}
+
+func TestWindowArgs(t *testing.T) {
+	w := parseWindowArgs("SELECT * FROM `users` WHERE `group_id` = ? ORDER BY `id` DESC LIMIT ? OFFSET ?;")
+	assert.Equal(t, &windowArgs{limit: 1, offset: 2}, w)
+	assert.Equal(t, &windowArgs{limit: 0, offset: -1}, parseWindowArgs("SELECT * FROM `users` ORDER BY `id` LIMIT ?;"))
+	assert.Nil(t, parseWindowArgs("SELECT * FROM `users` WHERE `group_id` = ?;"))
+
+	key, offset, end, ok := w.split([]driver.Value{int64(1), int64(10), int64(20)})
+	assert.True(t, ok)
+	assert.Equal(t, cacheKey([]driver.Value{int64(1)}), key)
+	assert.Equal(t, 20, offset)
+	assert.Equal(t, 30, end)
+	assert.Equal(t, []driver.Value{int64(1), int64(30), int64(0)}, w.widen([]driver.Value{int64(1), int64(10), int64(20)}, end))
+
+	_, _, _, ok = w.split([]driver.Value{int64(1), int64(0), int64(0)})
+	assert.False(t, ok)
+	_, _, _, ok = w.split([]driver.Value{int64(1), "10", int64(0)})
+	assert.False(t, ok)
+}
+
+func TestCacheRowsWindow(t *testing.T) {
+	rows := &cacheRows{cached: true, columns: []string{"id"}, rows: sliceRows{rows: []row{{int64(1)}, {int64(2)}, {int64(3)}}}, limit: 3}
+	assert.Equal(t, 3, rows.covered())
+	assert.Equal(t, []row{{int64(2)}, {int64(3)}}, rows.window(1, 3).rows.rows)
+	assert.Empty(t, rows.window(5, 6).rows.rows)
+
+	// the rows fewer than the limit are the whole result
+	rows.limit = 5
+	assert.Equal(t, math.MaxInt, rows.covered())
+}
+
+func TestCacheInsertRowIntoWindow(t *testing.T) {
+	entities := newEntityStore(domains.TableSchema{
+		TableName: "users",
+		Columns: map[string]domains.TableSchemaColumn{
+			"id":   {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true},
+			"name": {ColumnName: "name", DataType: domains.TableSchemaDataType_STRING},
+		},
+	})
+	cache := &cacheWithInfo{
+		info:     domains.CachePlanSelectQuery{Orders: []domains.CachePlanOrder{{Column: "id", Order: domains.CachePlanOrder_ASC}}},
+		entities: entities,
+	}
+	fetched := &cacheRows{
+		cached:  true,
+		columns: []string{"id", "name"},
+		rows:    sliceRows{rows: []row{{int64(1), "Alice"}, {int64(3), "Carol"}}},
+	}
+	list, ok := entities.keep(fetched, nil, 0)
+	assert.True(t, ok)
+	list.limit = 2
+
+	// the row falls after the window
+	inserted, ok := cache.insertRow(list, &insertedRow{columns: []string{"id", "name"}, values: row{int64(4), "Dave"}, autoIncrement: -1})
+	assert.True(t, ok)
+	assert.Equal(t, list.keys, inserted.keys)
+
+	// the row pushes the last one out of the window
+	inserted, ok = cache.insertRow(list, &insertedRow{columns: []string{"id", "name"}, values: row{int64(2), "Bob"}, autoIncrement: -1})
+	assert.True(t, ok)
+	rows, ok := entities.load(inserted.keys, inserted.columns)
+	assert.True(t, ok)
+	assert.Equal(t, []row{{int64(1), "Alice"}, {int64(2), "Bob"}}, rows)
+	assert.Equal(t, 2, inserted.limit)
+}
+
+func TestMergeCachedRows(t *testing.T) {
+	id := domains.TableSchemaColumn{ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true}
+	createdAt := domains.TableSchemaColumn{ColumnName: "created_at", DataType: domains.TableSchemaDataType_DATETIME}
+	points := func(rows ...[]row) []*cacheRows {
+		cached := make([]*cacheRows, len(rows))
+		for i, r := range rows {
+			cached[i] = &cacheRows{cached: true, columns: []string{"id", "created_at"}, rows: sliceRows{rows: r}}
+		}
+		return cached
+	}
+
+	// the rows read by "id IN (3, 1, 2)" are sorted by the primary key
+	merged, ok := mergeCachedRows(points(
+		[]row{{int64(3), []byte("2024-01-01 00:00:00")}},
+		[]row{{int64(1), []byte("2024-01-03 00:00:00")}},
+		[]row{{int64(2), []byte("2024-01-02 00:00:00")}},
+	), []columnOrder{{column: id}})
+	assert.True(t, ok)
+	assert.Equal(t, []row{
+		{int64(1), []byte("2024-01-03 00:00:00")},
+		{int64(2), []byte("2024-01-02 00:00:00")},
+		{int64(3), []byte("2024-01-01 00:00:00")},
+	}, merged.rows.rows)
+
+	// ORDER BY created_at DESC, id
+	merged, ok = mergeCachedRows(points(
+		[]row{{[]byte("1"), []byte("2024-01-02 00:00:00")}, {[]byte("3"), []byte("2024-01-01 00:00:00")}},
+		[]row{{[]byte("2"), []byte("2024-01-02 00:00:00")}},
+	), []columnOrder{{column: createdAt, desc: true}, {column: id}})
+	assert.True(t, ok)
+	assert.Equal(t, []row{
+		{[]byte("1"), []byte("2024-01-02 00:00:00")},
+		{[]byte("2"), []byte("2024-01-02 00:00:00")},
+		{[]byte("3"), []byte("2024-01-01 00:00:00")},
+	}, merged.rows.rows)
+
+	// the values of the column cannot be compared
+	_, ok = mergeCachedRows(points([]row{{"a", nil}}, []row{{"b", nil}}), []columnOrder{{column: id}})
+	assert.False(t, ok)
+}
+
+func TestResultOrders(t *testing.T) {
+	r := &registry{tableSchema: map[string]domains.TableSchema{"users": {
+		TableName: "users",
+		Columns: map[string]domains.TableSchemaColumn{
+			"id":       {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true},
+			"group_id": {ColumnName: "group_id", DataType: domains.TableSchemaDataType_INT},
+		},
+	}}}
+	in := func(column string) []domains.CachePlanCondition {
+		return []domains.CachePlanCondition{{Column: column, Operator: domains.CachePlanOperator_IN}}
+	}
+	targets := []string{"id", "group_id"}
+
+	// "id IN (?)" is sorted by the primary key
+	orders, ok := r.resultOrders(domains.CachePlanSelectQuery{Table: "users", Targets: targets, Conditions: in("id")})
+	assert.True(t, ok)
+	assert.Equal(t, []columnOrder{{column: r.tableSchema["users"].Columns["id"]}}, orders)
+
+	// the order of "group_id IN (?)" depends on the index used
+	_, ok = r.resultOrders(domains.CachePlanSelectQuery{Table: "users", Targets: targets, Conditions: in("group_id")})
+	assert.False(t, ok)
+
+	orders, ok = r.resultOrders(domains.CachePlanSelectQuery{Table: "users", Targets: targets, Conditions: in("group_id"), Orders: []domains.CachePlanOrder{{Column: "id", Order: domains.CachePlanOrder_DESC}}})
+	assert.True(t, ok)
+	assert.Equal(t, []columnOrder{{column: r.tableSchema["users"].Columns["id"], desc: true}}, orders)
+}
@@ -0,0 +1,34 @@
+package template
+
+import (
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolation(t *testing.T) {
+	cfg := mysql.NewConfig()
+	inner, err := mysql.NewConnector(cfg)
+	assert.NoError(t, err)
+
+	// the config of a mysql connector is not visible
+	assert.False(t, Wrap(inner).(*cacheConnector).interpolate)
+	assert.True(t, Wrap(inner, WithInterpolation(true)).(*cacheConnector).interpolate)
+
+	// the DSN of the mysql driver tells interpolateParams
+	c, err := WrapDriver(&mysql.MySQLDriver{}).(*wrappedDriver).OpenConnector("user@tcp(localhost:3306)/db?interpolateParams=true")
+	assert.NoError(t, err)
+	assert.True(t, c.(*cacheConnector).interpolate)
+	c, err = WrapDriver(&mysql.MySQLDriver{}).(*wrappedDriver).OpenConnector("user@tcp(localhost:3306)/db")
+	assert.NoError(t, err)
+	assert.False(t, c.(*cacheConnector).interpolate)
+
+	cfg.InterpolateParams = true
+	c, err = NewConnector(cfg)
+	assert.NoError(t, err)
+	assert.True(t, c.(*cacheConnector).interpolate)
+	c, err = NewConnector(cfg, WithInterpolation(false))
+	assert.NoError(t, err)
+	assert.False(t, c.(*cacheConnector).interpolate)
+}
@@ -0,0 +1,85 @@
+package template
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/traP-jp/isuc/domains"
+)
+
+func TestSelectAll(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM `users` WHERE `id` = ?", selectAll("SELECT `id`, `name` FROM `users` WHERE `id` = ?"))
+	assert.Equal(t, "SELECT * FROM `users`", selectAll("SELECT * FROM `users`"))
+}
+
+func TestEntityStore(t *testing.T) {
+	store := newEntityStore(domains.TableSchema{
+		TableName: "users",
+		Columns: map[string]domains.TableSchemaColumn{
+			"id":   {ColumnName: "id", IsPrimary: true},
+			"name": {ColumnName: "name"},
+		},
+	})
+
+	fetched := &cacheRows{
+		cached:  true,
+		columns: []string{"id", "name"},
+		rows:    sliceRows{rows: []row{{int64(1), "Alice"}, {int64(2), "Bob"}}},
+	}
+	kept, ok := store.keep(fetched, []string{"name"}, 0)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"name"}, kept.columns)
+
+	rows, ok := store.load(kept.keys, kept.columns)
+	assert.True(t, ok)
+	assert.Equal(t, []row{{"Alice"}, {"Bob"}}, rows)
+
+	store.forget(cacheKey([]driver.Value{int64(2)}))
+	_, ok = store.load(kept.keys, kept.columns)
+	assert.False(t, ok)
+
+	// the rows fetched before the forget are not stored
+	kept, ok = store.keep(fetched, nil, 0)
+	assert.True(t, ok)
+	_, ok = store.load(kept.keys, kept.columns)
+	assert.False(t, ok)
+}
+
+func TestEntityStoreUpdate(t *testing.T) {
+	store := newEntityStore(domains.TableSchema{
+		TableName: "users",
+		Columns: map[string]domains.TableSchemaColumn{
+			"id":   {ColumnName: "id", IsPrimary: true},
+			"name": {ColumnName: "name"},
+		},
+	})
+
+	fetched := &cacheRows{
+		cached:  true,
+		columns: []string{"id", "name"},
+		rows:    sliceRows{rows: []row{{int64(1), "Alice"}}},
+	}
+	kept, ok := store.keep(fetched, nil, 0)
+	assert.True(t, ok)
+
+	rows, ok := store.load(kept.keys, kept.columns)
+	assert.True(t, ok)
+	store.update(kept.keys[0], []string{"name"}, []driver.Value{"updated"})
+
+	updated, ok := store.load(kept.keys, kept.columns)
+	assert.True(t, ok)
+	assert.Equal(t, []row{{int64(1), "updated"}}, updated)
+	// the rows loaded before are not changed
+	assert.Equal(t, []row{{int64(1), "Alice"}}, rows)
+
+	// a column missing in the schema may be changed by the database
+	fetched.columns = []string{"id", "name", "updated_at"}
+	fetched.rows = sliceRows{rows: []row{{int64(2), "Bob", "2024-01-01 00:00:00"}}}
+	kept, ok = store.keep(fetched, nil, time.Now().UnixNano())
+	assert.True(t, ok)
+	store.update(kept.keys[0], []string{"name"}, []driver.Value{"updated"})
+	_, ok = store.load(kept.keys, kept.columns)
+	assert.False(t, ok)
+}
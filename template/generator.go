@@ -12,6 +12,7 @@ type Generator struct {
 	driverTmpl *template.Template
 	stmtTmpl   *template.Template
 	cacheTmpl  *template.Template
+	entityTmpl *template.Template
 	data       data
 }
 
@@ -29,6 +30,7 @@ func NewGenerator(cachePlanRaw string, tableSchemaRaw string) *Generator {
 		driverTmpl: template.Must(template.ParseFS(templates, "driver.tmpl")),
 		stmtTmpl:   template.Must(template.ParseFS(templates, "stmt.tmpl")),
 		cacheTmpl:  template.Must(template.ParseFS(templates, "cache.tmpl")),
+		entityTmpl: template.Must(template.ParseFS(templates, "entity.tmpl")),
 		data:       data{CachePlanRaw: toEscapedGoStringLiteral(cachePlanRaw), TableSchemaRaw: toEscapedGoStringLiteral(tableSchemaRaw)},
 	}
 }
@@ -50,6 +52,11 @@ func (g *Generator) Generate(destDir string) {
 		panic(err)
 	}
 	defer cache.Close()
+	entity, err := os.Create(path.Join(destDir, "entity.go"))
+	if err != nil {
+		panic(err)
+	}
+	defer entity.Close()
 
 	err = g.driverTmpl.Execute(driver, g.data)
 	if err != nil {
@@ -63,6 +70,10 @@ func (g *Generator) Generate(destDir string) {
 	if err != nil {
 		panic(err)
 	}
+	err = g.entityTmpl.Execute(entity, g.data)
+	if err != nil {
+		panic(err)
+	}
 }
 
 func toEscapedGoStringLiteral(s string) string {
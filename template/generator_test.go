@@ -25,6 +25,7 @@ func TestGenerator(t *testing.T) {
 		{"driver", g.driverTmpl},
 		{"stmt", g.stmtTmpl},
 		{"cache", g.cacheTmpl},
+		{"entity", g.entityTmpl},
 	}
 
 	for _, test := range tests {
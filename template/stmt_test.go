@@ -0,0 +1,220 @@
+package template
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/traP-jp/isuc/domains"
+)
+
+func TestParseUpdateSets(t *testing.T) {
+	tests := []struct {
+		query string
+		sets  []updateSet
+		ok    bool
+	}{
+		{"UPDATE `users` SET `name` = ? WHERE `id` = ?;", []updateSet{{"name", 0}}, true},
+		{"UPDATE `users` SET `name` = ?, `age` = ? WHERE `id` = ?;", []updateSet{{"name", 0}, {"age", 1}}, true},
+		{"UPDATE `users` SET `name` = ?;", []updateSet{{"name", 0}}, true},
+		{"UPDATE `users` SET `age` = `age` + ? WHERE `id` = ?;", nil, false},
+		{"UPDATE `users` SET `name` = ?, `age` = `age` + 1 WHERE `id` = ?;", nil, false},
+		{"UPDATE `users` SET `created_at` = NOW() WHERE `id` = ?;", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			sets, ok := parseUpdateSets(test.query)
+			assert.Equal(t, test.ok, ok)
+			if ok {
+				assert.Equal(t, test.sets, sets)
+			}
+		})
+	}
+}
+
+func TestParseInConditions(t *testing.T) {
+	tests := []struct {
+		query      string
+		conditions []inCondition
+		ok         bool
+	}{
+		{"SELECT * FROM `users` WHERE `id` IN (?, ?, ?);", []inCondition{{"id", []int{0, 1, 2}}}, true},
+		{"SELECT * FROM `users` WHERE `group_id` = ? AND `id` IN (?, ?) ORDER BY `id`;", []inCondition{{"group_id", []int{0}}, {"id", []int{1, 2}}}, true},
+		{"SELECT * FROM `users` WHERE `group_id` IN (?, ?) AND `id` IN (?)", []inCondition{{"group_id", []int{0, 1}}, {"id", []int{2}}}, true},
+		{"SELECT * FROM `users` WHERE `id` IN (?, ?) LIMIT ?;", nil, false},
+		{"SELECT * FROM `users` WHERE `id` IN (?, ?) OR `group_id` = ?;", nil, false},
+		{"SELECT * FROM `users` WHERE `id` IN (SELECT `user_id` FROM `members`);", nil, false},
+		{"SELECT * FROM `users` WHERE `age` > ? AND `id` IN (?);", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			conditions, ok := parseInConditions(test.query)
+			assert.Equal(t, test.ok, ok)
+			if ok {
+				assert.Equal(t, test.conditions, conditions)
+			}
+		})
+	}
+}
+
+func TestExpandInQuery(t *testing.T) {
+	schema := []domains.TableSchema{{
+		TableName: "users",
+		Columns: map[string]domains.TableSchemaColumn{
+			"id":       {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true},
+			"group_id": {ColumnName: "group_id", DataType: domains.TableSchemaDataType_INT},
+		},
+	}}
+	inQuery := &domains.CachePlanQuery{
+		CachePlanQueryBase: &domains.CachePlanQueryBase{Query: "SELECT * FROM users WHERE group_id = ? AND id IN (?);", Type: domains.CachePlanQueryType_SELECT},
+		Select: &domains.CachePlanSelectQuery{Cache: true, Table: "users", Targets: []string{"id", "group_id"}, Conditions: []domains.CachePlanCondition{
+			{Column: "group_id", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 0}},
+			{Column: "id", Operator: domains.CachePlanOperator_IN, Placeholder: domains.CachePlanPlaceholder{Index: 1}},
+		}},
+	}
+	r := newRegistry(&domains.CachePlan{Queries: []*domains.CachePlanQuery{inQuery}}, schema)
+
+	point, ok := r.queryMap["SELECT * FROM users WHERE group_id = ? AND id = ?;"]
+	assert.True(t, ok)
+	assert.Equal(t, domains.CachePlanOperator_EQ, point.Select.Conditions[1].Operator)
+	assert.Equal(t, 1, point.Select.Conditions[1].Placeholder.Index)
+
+	in, points, ok := r.expandInQuery("SELECT * FROM users WHERE group_id = ? AND id IN (?);", "SELECT * FROM `users` WHERE `group_id` = ? AND `id` IN (?, ?)", []driver.Value{int64(1), int64(2), int64(3)})
+	assert.True(t, ok)
+	assert.Same(t, r.caches["SELECT * FROM users WHERE group_id = ? AND id = ?;"], in.point)
+	assert.Equal(t, [][]driver.Value{{int64(1), int64(2)}, {int64(1), int64(3)}}, points)
+	// without ORDER BY, the rows are sorted by the primary key
+	assert.Equal(t, []columnOrder{{column: schema[0].Columns["id"]}}, in.orders)
+
+	// the repeated values are read once
+	_, points, ok = r.expandInQuery("SELECT * FROM users WHERE group_id = ? AND id IN (?);", "SELECT * FROM `users` WHERE `group_id` = ? AND `id` IN (?, ?, ?)", []driver.Value{int64(1), int64(3), int64(2), int64(3)})
+	assert.True(t, ok)
+	assert.Equal(t, [][]driver.Value{{int64(1), int64(3)}, {int64(1), int64(2)}}, points)
+
+	_, _, ok = r.expandInQuery("SELECT * FROM users WHERE group_id = ? AND id IN (?);", "SELECT * FROM `users` WHERE `id` IN (?, ?) AND `group_id` = ?", []driver.Value{int64(2), int64(3), int64(1)})
+	assert.False(t, ok)
+}
+
+func TestInQueryRead(t *testing.T) {
+	schema := []domains.TableSchema{{
+		TableName: "users",
+		Columns: map[string]domains.TableSchemaColumn{
+			"id":       {ColumnName: "id", DataType: domains.TableSchemaDataType_INT, IsPrimary: true},
+			"group_id": {ColumnName: "group_id", DataType: domains.TableSchemaDataType_INT},
+		},
+	}}
+	query := &domains.CachePlanQuery{
+		CachePlanQueryBase: &domains.CachePlanQueryBase{Query: "SELECT * FROM users WHERE group_id = ? AND id IN (?);", Type: domains.CachePlanQueryType_SELECT},
+		Select: &domains.CachePlanSelectQuery{Cache: true, Table: "users", Targets: []string{"id", "group_id"}, Conditions: []domains.CachePlanCondition{
+			{Column: "group_id", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 0}},
+			{Column: "id", Operator: domains.CachePlanOperator_IN, Placeholder: domains.CachePlanPlaceholder{Index: 1}},
+		}},
+	}
+	r := newRegistry(&domains.CachePlan{Queries: []*domains.CachePlanQuery{query}}, schema)
+	table := []row{{int64(2), int64(1)}, {int64(3), int64(1)}, {int64(4), int64(1)}}
+
+	type call struct {
+		query string
+		args  []driver.Value
+	}
+	var calls []call
+	fetch := func(ctx context.Context, query string, args []driver.Value) (*cacheRows, error) {
+		calls = append(calls, call{query, args})
+		rows := &cacheRows{cached: true, columns: []string{"id", "group_id"}}
+		for _, r := range slices.Backward(table) {
+			if slices.ContainsFunc(args[1:], func(id driver.Value) bool { return id == r[0] }) {
+				rows.rows.append(r)
+			}
+		}
+		return rows, nil
+	}
+	read := func(args ...driver.Value) []row {
+		placeholders := strings.Repeat(", ?", len(args)-2)
+		in, points, ok := r.expandInQuery(query.Query, "SELECT * FROM `users` WHERE `group_id` = ? AND `id` IN (?"+placeholders+")", args)
+		assert.True(t, ok)
+		rows, ok, err := in.read(context.Background(), points, fetch, nopMetrics{})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		return rows.rows.rows
+	}
+
+	// the missing points are fetched by a single query
+	assert.Equal(t, []row{{int64(2), int64(1)}, {int64(3), int64(1)}}, read(int64(1), int64(3), int64(2), int64(5)))
+	assert.Equal(t, []call{{"SELECT * FROM users WHERE group_id = ? AND id IN (?, ?, ?);", []driver.Value{int64(1), int64(3), int64(2), int64(5)}}}, calls)
+
+	// only the points missing in the cache are fetched
+	calls = nil
+	assert.Equal(t, []row{{int64(2), int64(1)}, {int64(3), int64(1)}, {int64(4), int64(1)}}, read(int64(1), int64(2), int64(4), int64(3)))
+	assert.Equal(t, []call{{"SELECT * FROM users WHERE group_id = ? AND id = ?;", []driver.Value{int64(1), int64(4)}}}, calls)
+
+	// cached
+	calls = nil
+	assert.Equal(t, []row{{int64(3), int64(1)}, {int64(4), int64(1)}}, read(int64(1), int64(4), int64(3)))
+	assert.Empty(t, calls)
+}
+
+func TestInBatchQuery(t *testing.T) {
+	query, ok := inBatchQuery("SELECT * FROM users WHERE group_id = ? AND id = ? ORDER BY id;", []int{1, 3})
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT * FROM users WHERE group_id = ? AND id IN (?, ?, ?) ORDER BY id;", query)
+
+	query, ok = inBatchQuery("SELECT * FROM users WHERE id = ?;", []int{2})
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT * FROM users WHERE id IN (?, ?);", query)
+
+	_, ok = inBatchQuery("SELECT * FROM users WHERE age > ?;", []int{2})
+	assert.False(t, ok)
+}
+
+func TestCartesian(t *testing.T) {
+	args := []driver.Value{"a", "b", int64(1), int64(2)}
+	points := cartesian([][]driver.Value{nil}, []int{0, 1}, args)
+	points = cartesian(points, []int{2, 3}, args)
+	assert.Equal(t, [][]driver.Value{{"a", int64(1)}, {"a", int64(2)}, {"b", int64(1)}, {"b", int64(2)}}, points)
+}
+
+func TestEntityValue(t *testing.T) {
+	name := domains.TableSchemaColumn{ColumnName: "name", DataType: domains.TableSchemaDataType_STRING}
+	age := domains.TableSchemaColumn{ColumnName: "age", DataType: domains.TableSchemaDataType_INT, IsNullable: true}
+	createdAt := domains.TableSchemaColumn{ColumnName: "created_at", DataType: domains.TableSchemaDataType_DATETIME}
+
+	value, ok := entityValue(name, "updated")
+	assert.True(t, ok)
+	assert.Equal(t, "updated", value)
+
+	value, ok = entityValue(age, true)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), value)
+
+	value, ok = entityValue(age, nil)
+	assert.True(t, ok)
+	assert.Nil(t, value)
+
+	_, ok = entityValue(name, nil)
+	assert.False(t, ok)
+	_, ok = entityValue(age, "1")
+	assert.False(t, ok)
+	_, ok = entityValue(createdAt, "2024-01-01 00:00:00")
+	assert.False(t, ok)
+}
+
+func TestInsertValue(t *testing.T) {
+	createdAt := domains.TableSchemaColumn{ColumnName: "created_at", DataType: domains.TableSchemaDataType_DATETIME}
+	sample := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	inserted := time.Date(2024, 1, 2, 9, 0, 0, 0, time.FixedZone("JST", 9*60*60))
+
+	value, ok := insertValue(createdAt, inserted, sample)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), value)
+
+	// the fractional seconds may be rounded by the database
+	_, ok = insertValue(createdAt, inserted.Add(time.Millisecond), sample)
+	assert.False(t, ok)
+	// the location of the column is not known
+	_, ok = insertValue(createdAt, inserted, nil)
+	assert.False(t, ok)
+}
@@ -7,19 +7,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"github.com/motoki317/sc"
 	"github.com/traP-jp/isuc/domains"
 	"github.com/traP-jp/isuc/normalizer"
 )
 
-var queryMap = make(map[string]domains.CachePlanQuery)
-
-var tableSchema = make(map[string]domains.TableSchema)
-
 // TODO: generate
 const cachePlanRaw = `queries:
   - query: SELECT * FROM ` + "`" + `users` + "`" + ` WHERE ` + "`" + `id` + "`" + ` = ?;
@@ -67,6 +64,42 @@ const cachePlanRaw = `queries:
         operator: eq
         placeholder:
           index: 0
+  - query: SELECT * FROM ` + "`" + `users` + "`" + ` WHERE ` + "`" + `group_id` + "`" + ` = ? AND ` + "`" + `id` + "`" + ` IN (?);
+    type: select
+    table: users
+    cache: true
+    targets:
+      - id
+      - name
+      - age
+      - group_id
+      - created_at
+    conditions:
+      - column: group_id
+        operator: eq
+        placeholder:
+          index: 0
+      - column: id
+        operator: in
+        placeholder:
+          index: 1
+  - query: SELECT * FROM ` + "`" + `users` + "`" + ` ORDER BY ` + "`" + `id` + "`" + ` DESC LIMIT ? OFFSET ?;
+    type: select
+    table: users
+    cache: true
+    targets:
+      - id
+      - name
+      - age
+      - group_id
+      - created_at
+    orders:
+      - column: id
+        order: desc
+    limit:
+      index: 0
+    offset:
+      index: 1
   - query: UPDATE ` + "`" + `users` + "`" + ` SET ` + "`" + `name` + "`" + ` = ? WHERE ` + "`" + `id` + "`" + ` = ?;
     type: update
     table: users
@@ -79,6 +112,49 @@ const cachePlanRaw = `queries:
         operator: eq
         placeholder:
           index: 1
+  - query: UPDATE ` + "`" + `users` + "`" + ` SET ` + "`" + `name` + "`" + ` = ?, ` + "`" + `age` + "`" + ` = ? WHERE ` + "`" + `id` + "`" + ` = ?;
+    type: update
+    table: users
+    targets:
+      - column: name
+        placeholder:
+          index: 0
+      - column: age
+        placeholder:
+          index: 0
+          extra: true
+    conditions:
+      - column: id
+        operator: eq
+        placeholder:
+          index: 1
+  - query: UPDATE ` + "`" + `users` + "`" + ` SET ` + "`" + `age` + "`" + ` = ` + "`" + `age` + "`" + ` + 1 WHERE ` + "`" + `id` + "`" + ` = ?;
+    type: update
+    table: users
+    targets:
+      - column: age
+        placeholder:
+          index: 0
+      - column: created_at
+        placeholder:
+          index: 1
+      - column: group_id
+        placeholder:
+          index: 2
+      - column: id
+        placeholder:
+          index: 3
+      - column: name
+        placeholder:
+          index: 4
+  - query: DELETE FROM ` + "`" + `users` + "`" + ` WHERE ` + "`" + `id` + "`" + ` = ?;
+    type: delete
+    table: users
+    conditions:
+      - column: id
+        operator: eq
+        placeholder:
+          index: 0
   - query: INSERT INTO ` + "`" + `users` + "`" + ` (` + "`" + `name` + "`" + `, ` + "`" + `age` + "`" + `, ` + "`" + `created_at` + "`" + `) VALUES (?, ?, ?);
     type: insert
     table: users
@@ -105,6 +181,9 @@ const schemaRaw = `CREATE TABLE ` + "`" + `users` + "`" + ` (
 );
 `
 
+// defaultRegistry holds the caches of the plan this package is generated from
+var defaultRegistry *registry
+
 func init() {
 	sql.Register("mysql+cache", CacheDriver{})
 
@@ -112,66 +191,211 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
-	for _, table := range schema {
-		tableSchema[table.TableName] = table
-	}
 
 	plan, err := domains.LoadCachePlan(strings.NewReader(cachePlanRaw))
 	if err != nil {
 		panic(err)
 	}
 
-	for _, query := range plan.Queries {
-		normalized := normalizer.NormalizeQuery(query.Query)
-		query.Query = normalized // make sure to use normalized query
-		queryMap[normalized] = *query
-		if query.Type != domains.CachePlanQueryType_SELECT || !query.Select.Cache {
-			continue
-		}
-
-		conditions := query.Select.Conditions
-		if isSingleUniqueCondition(conditions, query.Select.Table) {
-			caches[normalized] = &cacheWithInfo{
-				Cache:      sc.NewMust(replaceFn, 10*time.Minute, 10*time.Minute),
-				query:      normalized,
-				info:       *query.Select,
-				uniqueOnly: true,
-			}
-			continue
-		}
-		caches[query.Query] = &cacheWithInfo{
-			Cache:      sc.NewMust(replaceFn, 10*time.Minute, 10*time.Minute),
-			query:      query.Query,
-			info:       *query.Select,
-			uniqueOnly: false,
-		}
-
-		// TODO: if query is like "SELECT * FROM WHERE pk IN (?, ?, ...)", generate cache with query "SELECT * FROM table WHERE pk = ?"
-	}
-
-	for _, cache := range caches {
-		cacheByTable[cache.info.Table] = append(cacheByTable[cache.info.Table], cache)
-	}
+	defaultRegistry = newRegistry(plan, schema)
 }
 
-var _ driver.Driver = CacheDriver{}
+var (
+	_ driver.Driver        = CacheDriver{}
+	_ driver.DriverContext = CacheDriver{}
+)
 
 type CacheDriver struct{}
 
 func (d CacheDriver) Open(dsn string) (driver.Conn, error) {
+	connector, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+func (d CacheDriver) OpenConnector(dsn string) (driver.Connector, error) {
 	cfg, err := mysql.ParseDSN(dsn)
 	if err != nil {
 		return nil, err
 	}
+	return NewConnector(cfg)
+}
+
+// NewConnector returns a connector of the mysql driver caching the queries, to be opened by sql.OpenDB.
+//
+//	connector, err := NewConnector(cfg, WithLogger(logger))
+//	db := sql.OpenDB(connector)
+func NewConnector(cfg *mysql.Config, opts ...Option) (driver.Connector, error) {
 	c, err := mysql.NewConnector(cfg)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := c.Connect(context.Background())
+	// without interpolateParams, the mysql driver rejects args outside prepared statements
+	o := newOptions(opts)
+	return &cacheConnector{inner: c, driver: CacheDriver{}, interpolate: o.interpolates(cfg.InterpolateParams), options: o}, nil
+}
+
+// Wrap returns a connector caching the queries of the plan this package is generated from
+// on top of the connections of inner, which can be any database/sql driver (TiDB, MariaDB, tracing drivers, sqlmock, SQLite, ...).
+// The connections of a mysql connector are assumed to reject args outside prepared statements unless WithInterpolation(true) is given,
+// since its config is not visible.
+//
+//	db := sql.OpenDB(Wrap(connector))
+func Wrap(inner driver.Connector, opts ...Option) driver.Connector {
+	o := newOptions(opts)
+	return &cacheConnector{inner: inner, driver: WrapDriver(inner.Driver(), opts...), interpolate: o.interpolates(!isMySQL(inner.Driver())), options: o}
+}
+
+// WrapDriver is like Wrap, but wraps a driver so that it can be registered by sql.Register
+func WrapDriver(inner driver.Driver, opts ...Option) driver.Driver {
+	return &wrappedDriver{inner: inner, options: newOptions(opts)}
+}
+
+// Option configures a connector created by NewConnector, Wrap or WrapDriver
+type Option func(o *options)
+
+type options struct {
+	registry *registry
+	logger   *log.Logger
+	metrics  MetricsSink
+	// interpolate is set by WithInterpolation, or nil to be told from the inner driver
+	interpolate *bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{registry: defaultRegistry, logger: log.Default(), metrics: nopMetrics{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPlan caches the queries of plan instead of the plan this package is generated from.
+// The caches are not shared with the other connectors.
+func WithPlan(plan *domains.CachePlan, schema []domains.TableSchema) Option {
+	return func(o *options) {
+		o.registry = newRegistry(plan, schema)
+	}
+}
+
+// WithInterpolation tells whether QueryContext and ExecContext of the inner connections accept args,
+// e.g. false for the mysql driver without interpolateParams, so that the queries are prepared at the first attempt.
+// NewConnector follows cfg.InterpolateParams and WrapDriver follows the DSN of the mysql driver by default.
+func WithInterpolation(interpolate bool) Option {
+	return func(o *options) {
+		o.interpolate = &interpolate
+	}
+}
+
+// interpolates returns the interpolation set by WithInterpolation, or def if not set
+func (o *options) interpolates(def bool) bool {
+	if o.interpolate != nil {
+		return *o.interpolate
+	}
+	return def
+}
+
+// isMySQL returns true if d is the mysql driver, which rejects args outside prepared statements without interpolateParams
+func isMySQL(d driver.Driver) bool {
+	switch d.(type) {
+	case mysql.MySQLDriver, *mysql.MySQLDriver:
+		return true
+	}
+	return false
+}
+
+// WithLogger sets the logger of the unknown queries, log.Default() by default
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMetrics sends the cache events of the connector to sink
+func WithMetrics(sink MetricsSink) Option {
+	return func(o *options) {
+		o.metrics = sink
+	}
+}
+
+var (
+	_ driver.Driver        = &wrappedDriver{}
+	_ driver.DriverContext = &wrappedDriver{}
+)
+
+type wrappedDriver struct {
+	inner driver.Driver
+	*options
+}
+
+func (d *wrappedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.inner.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheConn{inner: conn, interpolate: d.interpolatesDSN(name), options: d.options}, nil
+}
+
+// interpolatesDSN returns whether the connections opened by name accept args, which is told by interpolateParams for the mysql driver
+func (d *wrappedDriver) interpolatesDSN(name string) bool {
+	if !isMySQL(d.inner) {
+		return d.interpolates(true)
+	}
+	cfg, err := mysql.ParseDSN(name)
+	if err != nil {
+		return d.interpolates(false)
+	}
+	return d.interpolates(cfg.InterpolateParams)
+}
+
+func (d *wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.inner.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &cacheConnector{inner: c, driver: d, interpolate: d.interpolatesDSN(name), options: d.options}, nil
+	}
+	return &dsnConnector{name: name, driver: d}, nil
+}
+
+// dsnConnector opens the connections by driver.Driver.Open like database/sql does for the drivers without driver.DriverContext
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c *dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+var _ driver.Connector = &cacheConnector{}
+
+type cacheConnector struct {
+	inner  driver.Connector
+	driver driver.Driver
+	// interpolate is false if the inner connections never accept args in QueryContext and ExecContext.
+	// Otherwise the inner connections may still return driver.ErrSkip, and database/sql retries with a prepared statement.
+	interpolate bool
+	*options
+}
+
+func (c *cacheConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.inner.Connect(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &cacheConn{inner: conn, config: cfg}, nil
+	return &cacheConn{inner: conn, interpolate: c.interpolate, options: c.options}, nil
+}
+
+func (c *cacheConnector) Driver() driver.Driver {
+	return c.driver
 }
 
 var (
@@ -183,22 +407,39 @@ var (
 )
 
 type cacheConn struct {
-	inner   driver.Conn
-	config  *mysql.Config
-	tx      bool
-	txStart int64 // time.Time.UnixNano()
+	inner driver.Conn
+	// interpolate is true if QueryContext and ExecContext of the inner connection may accept args
+	interpolate bool
+	*options
+	// tx is the running transaction, nil outside transactions
+	tx *cacheTx
+	// cleanUp is the caches written by the running statement
 	cleanUp cleanUpTask
 }
 
 func (c *cacheConn) Prepare(rawQuery string) (driver.Stmt, error) {
-	normalizedQuery := normalizer.NormalizeQuery(rawQuery)
+	query, names := normalizer.BindNamedQuery(rawQuery)
+	bound := c.registry.bindLiterals(query)
+	stmt, err := c.prepare(bound.query, bound.normalized)
+	if err != nil {
+		return nil, err
+	}
+	if len(bound.literals) > 0 {
+		stmt = &literalStmt{Stmt: stmt, literals: bound.literals}
+	}
+	if len(names) > 0 {
+		stmt = &namedStmt{Stmt: stmt, names: names}
+	}
+	return stmt, nil
+}
 
-	queryInfo, ok := queryMap[normalizedQuery]
+func (c *cacheConn) prepare(rawQuery string, normalizedQuery string) (driver.Stmt, error) {
+	queryInfo, ok := c.registry.queryMap[normalizedQuery]
 	if !ok {
 		// unknown (insert, update, delete) query
 		if !strings.HasPrefix(strings.ToUpper(normalizedQuery), "SELECT") {
-			log.Println("unknown query:", normalizedQuery)
-			PurgeAllCaches()
+			c.logger.Println("unknown query:", normalizedQuery)
+			c.purgeAll()
 		}
 		return c.inner.Prepare(rawQuery)
 	}
@@ -225,13 +466,7 @@ func (c *cacheConn) Close() error {
 }
 
 func (c *cacheConn) Begin() (driver.Tx, error) {
-	inner, err := c.BeginTx(context.Background(), driver.TxOptions{})
-	if err != nil {
-		return nil, err
-	}
-	c.tx = true
-	c.txStart = time.Now().UnixNano()
-	return &cacheTx{conn: c, inner: inner}, nil
+	return c.BeginTx(context.Background(), driver.TxOptions{})
 }
 
 func (c *cacheConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
@@ -248,9 +483,8 @@ func (c *cacheConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.
 			return nil, err
 		}
 	}
-	c.tx = true
-	c.txStart = time.Now().UnixNano()
-	return &cacheTx{conn: c, inner: inner}, nil
+	c.tx = &cacheTx{conn: c, inner: inner, start: time.Now().UnixNano()}
+	return c.tx, nil
 }
 
 func (c *cacheConn) Ping(ctx context.Context) error {
@@ -265,27 +499,122 @@ var _ driver.Tx = &cacheTx{}
 type cacheTx struct {
 	conn  *cacheConn
 	inner driver.Tx
+	start int64 // time.Time.UnixNano()
+	// overlay is the caches written inside the transaction
+	overlay txOverlay
+	// fills is the rows read inside the transaction, which are not shared with the other connections
+	fills map[*cacheWithInfo]map[string]*cacheRows
 }
 
 func (t *cacheTx) Commit() error {
-	t.conn.tx = false
-	defer t.conn.cleanUp.do()
+	t.conn.tx = nil
+	// the caches are cleaned up even if the commit fails, as it may have been applied
+	defer t.overlay.cleanUp.do(t.conn.metrics)
 	return t.inner.Commit()
 }
 
 func (t *cacheTx) Rollback() error {
-	t.conn.tx = false
+	t.conn.tx = nil
 	// no need to clean up
-	t.conn.cleanUp.reset()
+	t.overlay.reset()
 	return t.inner.Rollback()
 }
 
+// get serves key of cache inside the transaction.
+// The rows read by query are kept in the transaction instead of the shared cache,
+// because they are read under the snapshot of the transaction and may include uncommitted writes.
+func (t *cacheTx) get(cache *cacheWithInfo, key string, query func() (driver.Rows, error)) (driver.Rows, error) {
+	if rows, ok := t.fills[cache][key]; ok {
+		t.conn.metrics.Hit(cache.query)
+		return rows.clone(), nil
+	}
+	if rows, ok := cache.lookup(key); ok {
+		if materialized, ok := t.materialize(cache, key, rows); ok {
+			t.conn.metrics.Hit(cache.query)
+			return materialized, nil
+		}
+	}
+
+	start := time.Now()
+	inner, err := query()
+	if err != nil {
+		return nil, err
+	}
+	defer inner.Close()
+	rows, err := newCacheRows(inner)
+	if err != nil {
+		return nil, err
+	}
+	t.conn.metrics.Miss(cache.query, time.Since(start))
+
+	if t.fills == nil {
+		t.fills = make(map[*cacheWithInfo]map[string]*cacheRows)
+	}
+	if t.fills[cache] == nil {
+		t.fills[cache] = make(map[string]*cacheRows)
+	}
+	t.fills[cache][key] = rows
+	return rows.clone(), nil
+}
+
+// forgetFills drops the rows read inside the transaction by the caches on the entities written by tasks,
+// since the rows are not looked up by the entities
+func (t *cacheTx) forgetFills(tasks cleanUpTask) {
+	written := make(map[*entityStore]struct{})
+	for _, entities := range tasks.purgeEntities {
+		written[entities] = struct{}{}
+	}
+	for _, forget := range tasks.forgetEntities {
+		written[forget.entities] = struct{}{}
+	}
+	for _, update := range tasks.updateEntities {
+		written[update.entities] = struct{}{}
+	}
+	for cache := range t.fills {
+		if _, ok := written[cache.entities]; ok && cache.entities != nil {
+			delete(t.fills, cache)
+		}
+	}
+}
+
+// materialize is cacheWithInfo.materialize inside the transaction,
+// which fails if any of the entities has been written by the transaction or may be newer than its snapshot
+func (t *cacheTx) materialize(cache *cacheWithInfo, key string, rows *cacheRows) (*cacheRows, bool) {
+	if cache.entities == nil {
+		return rows, true
+	}
+	rows, ok := cache.listed(key, rows)
+	if !ok {
+		return nil, false
+	}
+	for _, key := range rows.keys {
+		if t.overlay.touchedEntity(cache.entities, key) || cache.entities.isNewerThan(key, t.start) {
+			return nil, false
+		}
+	}
+	return cache.project(rows)
+}
+
+// bypass reports whether key of cache must be read from the database inside the transaction,
+// because the transaction has written it or the cache may be newer than the snapshot of the transaction
+func (t *cacheTx) bypass(cache *cacheWithInfo, key string) bool {
+	if cache.byPrimaryKey && (t.overlay.touchedEntity(cache.entities, key) || cache.entities.isNewerThan(key, t.start)) {
+		// the key of the cache is the key of the entity
+		return true
+	}
+	return t.overlay.touched(cache, key) || cache.isNewerThan(key, t.start)
+}
+
 var _ driver.Rows = &cacheRows{}
 
 type cacheRows struct {
 	cached  bool
 	columns []string
 	rows    sliceRows
+	// keys are the primary keys of the rows stored in the entity store instead of rows
+	keys []string
+	// limit is the LIMIT of the window the rows are fetched with, or 0 if the rows are the whole result
+	limit int
 }
 
 func newCacheRows(inner driver.Rows) (*cacheRows, error) {
@@ -307,7 +636,26 @@ func (r *cacheRows) clone() *cacheRows {
 		cached:  r.cached,
 		columns: r.columns,
 		rows:    r.rows.clone(),
+		keys:    r.keys,
+		limit:   r.limit,
+	}
+}
+
+// covered returns the number of the rows from the top of the result the rows have
+func (r *cacheRows) covered() int {
+	n := max(len(r.rows.rows), len(r.keys))
+	if r.limit == 0 || n < r.limit {
+		// the rows are the whole result
+		return math.MaxInt
 	}
+	return r.limit
+}
+
+// window returns the rows [offset, end), which share the values with r
+func (r *cacheRows) window(offset int, end int) *cacheRows {
+	rows := r.rows.rows
+	offset, end = min(offset, len(rows)), min(end, len(rows))
+	return &cacheRows{cached: true, columns: r.columns, rows: sliceRows{rows: rows[offset:end]}}
 }
 
 func (r *cacheRows) Columns() []string {
@@ -329,24 +677,58 @@ func (r *cacheRows) Next(dest []driver.Value) error {
 	return r.rows.next(dest)
 }
 
-func mergeCachedRows(rows []*cacheRows) *cacheRows {
-	if len(rows) == 0 {
-		return nil
-	}
+// mergeCachedRows merges the rows of the point queries of an IN query into the rows sorted by orders.
+// It fails if the values of the order columns cannot be compared.
+func mergeCachedRows(rows []*cacheRows, orders []columnOrder) (*cacheRows, bool) {
 	if len(rows) == 1 {
-		return rows[0]
+		return rows[0], true
+	}
+
+	indexes := make([]int, len(orders))
+	for i, order := range orders {
+		indexes[i] = slices.Index(rows[0].columns, order.column.ColumnName)
+		if indexes[i] < 0 {
+			return nil, false
+		}
 	}
 
 	mergedSlice := sliceRows{}
 	for _, r := range rows {
 		mergedSlice.concat(r.rows)
 	}
+	sortable := true
+	// the rows of each point query are sorted already, and the stable merge sort keeps the order of the rows with the same values
+	slices.SortStableFunc(mergedSlice.rows, func(a, b row) int {
+		diff, ok := compareOrders(a, b, orders, indexes)
+		sortable = sortable && ok
+		return diff
+	})
+	if !sortable {
+		return nil, false
+	}
 
 	return &cacheRows{
 		cached:  true,
 		columns: rows[0].columns,
 		rows:    mergedSlice,
+	}, true
+}
+
+// compareOrders compares the rows by orders, where indexes are the positions of the order columns in the rows
+func compareOrders(a, b row, orders []columnOrder, indexes []int) (int, bool) {
+	for i, order := range orders {
+		diff, ok := compareColumnValues(order.column, a[indexes[i]], b[indexes[i]])
+		if !ok {
+			return 0, false
+		}
+		if order.desc {
+			diff = -diff
+		}
+		if diff != 0 {
+			return diff, true
+		}
 	}
+	return 0, true
 }
 
 func (r *cacheRows) cacheInnerRows(inner driver.Rows) error {
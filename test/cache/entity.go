@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"database/sql/driver"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/traP-jp/isuc/domains"
+	"github.com/traP-jp/isuc/sql_parser"
+)
+
+// entityStore holds the rows of a table by primary key, shared by the caches of the queries on the table.
+// The caches of such queries hold only the primary keys of their rows and project the columns from the store,
+// so that a row is stored once and a write by primary key is reflected in every query at once.
+type entityStore struct {
+	table string
+	// primaryKeys are the primary key columns sorted by name
+	primaryKeys []string
+	// columns are the columns in the schema
+	columns map[string]domains.TableSchemaColumn
+
+	mu   sync.RWMutex
+	rows map[string]entity
+
+	lastUpdate      atomic.Int64 // time.Time.UnixNano()
+	lastUpdateByKey syncMap[int64]
+}
+
+type entity struct {
+	// columns are the columns of values, shared by the rows fetched together
+	columns []string
+	values  row
+}
+
+// newEntityStore returns nil if the table has no primary key
+func newEntityStore(table domains.TableSchema) *entityStore {
+	var primaryKeys []string
+	for _, column := range table.Columns {
+		if column.IsPrimary {
+			primaryKeys = append(primaryKeys, column.ColumnName)
+		}
+	}
+	if len(primaryKeys) == 0 {
+		return nil
+	}
+	slices.Sort(primaryKeys)
+	return &entityStore{table: table.TableName, primaryKeys: primaryKeys, columns: table.Columns, rows: make(map[string]entity)}
+}
+
+// keep stores the rows fetched by "SELECT * ..." at since and returns the rows to be cached,
+// which hold only the primary keys and the projected columns.
+// The entities written after since are not stored, so the cached rows are fetched again on the next read.
+func (s *entityStore) keep(fetched *cacheRows, projection []string, since int64) (*cacheRows, bool) {
+	pkIdx := make([]int, len(s.primaryKeys))
+	for i, pk := range s.primaryKeys {
+		pkIdx[i] = slices.Index(fetched.columns, pk)
+		if pkIdx[i] < 0 {
+			return nil, false
+		}
+	}
+
+	keys := make([]string, 0, len(fetched.rows.rows))
+	pkValues := make([]driver.Value, len(pkIdx))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, values := range fetched.rows.rows {
+		for i, idx := range pkIdx {
+			pkValues[i] = values[idx]
+		}
+		key := cacheKey(pkValues)
+		if !s.isNewerThan(key, since) {
+			s.rows[key] = entity{columns: fetched.columns, values: values}
+		}
+		keys = append(keys, key)
+	}
+
+	columns := projection
+	if columns == nil {
+		columns = fetched.columns
+	}
+	return &cacheRows{cached: true, columns: columns, keys: keys}, true
+}
+
+// load returns the columns of the entities of keys,
+// and returns false if any of them has been forgotten or does not have the columns
+func (s *entityStore) load(keys []string, columns []string) ([]row, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rows := make([]row, 0, len(keys))
+	for _, key := range keys {
+		e, ok := s.rows[key]
+		if !ok {
+			return nil, false
+		}
+		if slices.Equal(e.columns, columns) {
+			rows = append(rows, e.values)
+			continue
+		}
+		projected := make(row, len(columns))
+		for i, column := range columns {
+			idx := slices.Index(e.columns, column)
+			if idx < 0 {
+				return nil, false
+			}
+			projected[i] = e.values[idx]
+		}
+		rows = append(rows, projected)
+	}
+	return rows, true
+}
+
+func (s *entityStore) forget(key string) {
+	s.lastUpdateByKey.Store(key, time.Now().UnixNano())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rows, key)
+}
+
+// add stores the entity inserted into the database
+func (s *entityStore) add(key string, columns []string, values row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows[key] = entity{columns: columns, values: values}
+}
+
+// update rewrites the columns of the entity of key as a new copy, so that the rows being read are not changed.
+// The entity is forgotten instead if it has a column missing in the schema (e.g. "ON UPDATE CURRENT_TIMESTAMP"),
+// which may be changed by the database as well.
+func (s *entityStore) update(key string, columns []string, values []driver.Value) {
+	s.lastUpdateByKey.Store(key, time.Now().UnixNano())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.rows[key]
+	if !ok {
+		return
+	}
+	for _, column := range e.columns {
+		if _, ok := s.columns[column]; !ok {
+			delete(s.rows, key)
+			return
+		}
+	}
+	updated := slices.Clone(e.values)
+	for i, column := range columns {
+		idx := slices.Index(e.columns, column)
+		if idx < 0 {
+			delete(s.rows, key)
+			return
+		}
+		updated[idx] = values[i]
+	}
+	s.rows[key] = entity{columns: e.columns, values: updated}
+}
+
+func (s *entityStore) purge() {
+	s.lastUpdate.Store(time.Now().UnixNano())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.rows)
+}
+
+func (s *entityStore) isNewerThan(key string, t int64) bool {
+	if s.lastUpdate.Load() > t {
+		return true
+	}
+	if update, ok := s.lastUpdateByKey.Load(key); ok && update > t {
+		return true
+	}
+	return false
+}
+
+// selectAll rewrites "SELECT col1, col2 FROM ..." into "SELECT * FROM ..."
+func selectAll(query string) string {
+	tokens := sql_parser.TokenizeRaw(query)
+	from := slices.IndexFunc(tokens, func(t sql_parser.RawToken) bool { return isReserved(t, "FROM") })
+	if from < 2 {
+		return query
+	}
+	last := tokens[from-1]
+	return query[:tokens[1].Pos] + "*" + query[last.Pos+len(last.Raw):]
+}
+
+func isReserved(t sql_parser.RawToken, word string) bool {
+	return t.IsReserved() && t.Literal() == word
+}
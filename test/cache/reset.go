@@ -6,10 +6,25 @@ import (
 	"github.com/motoki317/sc"
 )
 
+// Reset drops the rows, the stats and the update times kept by the caches of all the registries
 func Reset() {
-	for key := range caches {
-		v := caches[key]
-		*v.Cache = *sc.NewMust(replaceFn, 10*time.Minute, 10*time.Minute)
-		caches[key] = v
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	for _, r := range registries {
+		for _, c := range r.caches {
+			*c.Cache = *sc.NewMust(replaceFn, 10*time.Minute, 10*time.Minute)
+			c.Purge()
+			c.hits.Store(0)
+			c.misses.Store(0)
+			c.replaceTime.Store(0)
+			c.lastUpdate.Store(0)
+			c.lastUpdateByKey.m.Clear()
+			c.windows.m.Clear()
+		}
+		for _, entities := range r.entities {
+			entities.purge()
+			entities.lastUpdate.Store(0)
+			entities.lastUpdateByKey.m.Clear()
+		}
 	}
 }
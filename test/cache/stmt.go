@@ -3,19 +3,24 @@ package cache
 import (
 	"context"
 	"database/sql/driver"
-	"log"
+	"errors"
+	"fmt"
+	"iter"
 	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/traP-jp/isuc/domains"
 	"github.com/traP-jp/isuc/normalizer"
+	"github.com/traP-jp/isuc/sql_parser"
 )
 
-// NOTE: no write happens to this map, so it's safe to use in concurrent environment
-var caches = make(map[string]*cacheWithInfo)
-
-var cacheByTable = make(map[string][]*cacheWithInfo)
-
-var _ driver.Stmt = &customCacheStatement{}
+var (
+	_ driver.Stmt             = &customCacheStatement{}
+	_ driver.StmtQueryContext = &customCacheStatement{}
+	_ driver.StmtExecContext  = &customCacheStatement{}
+)
 
 type customCacheStatement struct {
 	inner    driver.Stmt
@@ -35,53 +40,48 @@ func (s *customCacheStatement) NumInput() int {
 }
 
 func (s *customCacheStatement) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valueToNamedValue(args))
+}
+
+func (s *customCacheStatement) ExecContext(ctx context.Context, nvargs []driver.NamedValue) (driver.Result, error) {
 	var res driver.Result
 	var err error
 	switch s.queryInfo.Type {
 	case domains.CachePlanQueryType_INSERT:
-		res, err = s.execInsert(args)
+		res, err = s.execInsert(ctx, nvargs)
 	case domains.CachePlanQueryType_UPDATE:
-		res, err = s.execUpdate(args)
+		res, err = s.execUpdate(ctx, nvargs)
 	case domains.CachePlanQueryType_DELETE:
-		res, err = s.execDelete(args)
+		res, err = s.execDelete(ctx, nvargs)
 	default:
-		res, err = s.inner.(driver.StmtExecContext).ExecContext(context.Background(), valueToNamedValue(args))
+		res, err = execStmt(ctx, s.inner, nvargs)
 	}
 
-	if !s.conn.tx {
-		s.conn.cleanUp.do()
-	} else {
-		// cleanups are deferred until the transaction is committed
-		// because we need to forget the cache only if the transaction is committed
-		for _, c := range s.conn.cleanUp.purge {
-			c.updateTx()
-		}
-		for _, forget := range s.conn.cleanUp.forget {
-			forget.cache.updateByKeyTx(forget.key)
-		}
-	}
+	s.conn.flushCleanUp()
 
 	return res, err
 }
 
-func (s *customCacheStatement) execInsert(args []driver.Value) (driver.Result, error) {
-	cleanup := handleInsertQuery(s.query, *s.queryInfo.Insert, args)
+func (s *customCacheStatement) execInsert(ctx context.Context, nvargs []driver.NamedValue) (driver.Result, error) {
+	cleanup := s.conn.registry.handleInsertQuery(s.rawQuery, s.query, *s.queryInfo.Insert, namedToValue(nvargs))
+	res, err := execStmt(ctx, s.inner, nvargs)
+	cleanup.inserted(res, err)
 	s.conn.cleanUp.append(cleanup)
-	return s.inner.(driver.StmtExecContext).ExecContext(context.Background(), valueToNamedValue(args))
+	return res, err
 }
 
-func (s *customCacheStatement) execUpdate(args []driver.Value) (driver.Result, error) {
-	cleanup := handleUpdateQuery(*s.queryInfo.Update, args)
+func (s *customCacheStatement) execUpdate(ctx context.Context, nvargs []driver.NamedValue) (driver.Result, error) {
+	cleanup := s.conn.registry.handleUpdateQuery(s.queryInfo.Query, *s.queryInfo.Update, namedToValue(nvargs))
+	res, err := execStmt(ctx, s.inner, nvargs)
+	cleanup.updated(err)
 	s.conn.cleanUp.append(cleanup)
-	nvarsgs := valueToNamedValue(args)
-	return s.inner.(driver.StmtExecContext).ExecContext(context.Background(), nvarsgs)
+	return res, err
 }
 
-func (s *customCacheStatement) execDelete(args []driver.Value) (driver.Result, error) {
-	cleanup := handleDeleteQuery(*s.queryInfo.Delete, args)
+func (s *customCacheStatement) execDelete(ctx context.Context, nvargs []driver.NamedValue) (driver.Result, error) {
+	cleanup := s.conn.registry.handleDeleteQuery(*s.queryInfo.Delete, namedToValue(nvargs))
 	s.conn.cleanUp.append(cleanup)
-	nvargs := valueToNamedValue(args)
-	return s.inner.(driver.StmtExecContext).ExecContext(context.Background(), nvargs)
+	return execStmt(ctx, s.inner, nvargs)
 }
 
 func (c *cacheConn) ExecContext(ctx context.Context, rawQuery string, nvargs []driver.NamedValue) (driver.Result, error) {
@@ -90,21 +90,26 @@ func (c *cacheConn) ExecContext(ctx context.Context, rawQuery string, nvargs []d
 		return nil, driver.ErrSkip
 	}
 
-	if !c.config.InterpolateParams {
+	if !c.interpolate {
 		return nil, driver.ErrSkip
 	}
 
-	normalizedQuery := normalizer.NormalizeQuery(rawQuery)
+	rawQuery, nvargs, err := bindNamedArgs(rawQuery, nvargs)
+	if err != nil {
+		return nil, err
+	}
+	bound := c.registry.bindLiterals(rawQuery)
+	rawQuery, normalizedQuery := bound.query, bound.normalized
+	nvargs = bindLiteralValues(bound.literals, nvargs)
 
-	queryInfo, ok := queryMap[normalizedQuery]
+	queryInfo, ok := c.registry.queryMap[normalizedQuery]
 	if !ok {
-		log.Println("unknown query:", normalizedQuery)
-		PurgeAllCaches()
+		c.logger.Println("unknown query:", normalizedQuery)
+		c.purgeAll()
 		return inner.ExecContext(ctx, rawQuery, nvargs)
 	}
 
 	var res driver.Result
-	var err error
 	switch queryInfo.Type {
 	case domains.CachePlanQueryType_INSERT:
 		res, err = c.execInsert(ctx, rawQuery, queryInfo, nvargs, inner)
@@ -116,18 +121,7 @@ func (c *cacheConn) ExecContext(ctx context.Context, rawQuery string, nvargs []d
 		res, err = inner.ExecContext(ctx, rawQuery, nvargs)
 	}
 
-	if !c.tx {
-		c.cleanUp.do()
-	} else {
-		// cleanups are deferred until the transaction is committed
-		// because we need to forget the cache only if the transaction is committed
-		for _, c := range c.cleanUp.purge {
-			c.updateTx()
-		}
-		for _, forget := range c.cleanUp.forget {
-			forget.cache.updateByKeyTx(forget.key)
-		}
-	}
+	c.flushCleanUp()
 
 	return res, err
 }
@@ -138,50 +132,89 @@ func (c *cacheConn) execInsert(ctx context.Context, rawQuery string, queryInfo d
 		args = append(args, nv.Value)
 	}
 
-	cleanUp := handleInsertQuery(queryInfo.Query, *queryInfo.Insert, args)
-	c.cleanUp.append(cleanUp)
-
-	return inner.ExecContext(ctx, rawQuery, nvargs)
+	cleanUp := c.registry.handleInsertQuery(rawQuery, queryInfo.Query, *queryInfo.Insert, args)
+	return c.execWithCleanUp(ctx, rawQuery, nvargs, inner, cleanUp)
 }
 
 func (c *cacheConn) execUpdate(ctx context.Context, rawQuery string, queryInfo domains.CachePlanQuery, nvargs []driver.NamedValue, inner driver.ExecerContext) (driver.Result, error) {
 	args := namedToValue(nvargs)
 
-	cleanUp := handleUpdateQuery(*queryInfo.Update, args)
-	c.cleanUp.append(cleanUp)
-
-	return inner.ExecContext(ctx, rawQuery, nvargs)
+	cleanUp := c.registry.handleUpdateQuery(queryInfo.Query, *queryInfo.Update, args)
+	return c.execWithCleanUp(ctx, rawQuery, nvargs, inner, cleanUp)
 }
 
 func (c *cacheConn) execDelete(ctx context.Context, rawQuery string, queryInfo domains.CachePlanQuery, nvargs []driver.NamedValue, inner driver.ExecerContext) (driver.Result, error) {
 	args := namedToValue(nvargs)
 
-	cleanUp := handleDeleteQuery(*queryInfo.Delete, args)
-	c.cleanUp.append(cleanUp)
+	cleanUp := c.registry.handleDeleteQuery(*queryInfo.Delete, args)
+	return c.execWithCleanUp(ctx, rawQuery, nvargs, inner, cleanUp)
+}
+
+func (c *cacheConn) purgeAll() {
+	for _, cache := range c.registry.caches {
+		c.cleanUp.purge = append(c.cleanUp.purge, cache)
+	}
+	for _, entities := range c.registry.entities {
+		c.cleanUp.purgeEntities = append(c.cleanUp.purgeEntities, entities)
+	}
+	c.flushCleanUp()
+}
 
-	return inner.ExecContext(ctx, rawQuery, nvargs)
+// flushCleanUp purges and forgets the caches written by the statement.
+// Inside a transaction, they are added to the overlay of the transaction and cleaned up on commit instead.
+func (c *cacheConn) flushCleanUp() {
+	if c.tx == nil {
+		c.cleanUp.do(c.metrics)
+		return
+	}
+	c.tx.overlay.add(c.cleanUp)
+	c.tx.forgetFills(c.cleanUp)
+	c.cleanUp.reset()
+}
+
+// execWithCleanUp registers cleanUp unless the inner connection returns driver.ErrSkip,
+// in which case database/sql retries the query with a prepared statement that registers it instead
+func (c *cacheConn) execWithCleanUp(ctx context.Context, rawQuery string, nvargs []driver.NamedValue, inner driver.ExecerContext, cleanUp cleanUpTask) (driver.Result, error) {
+	res, err := inner.ExecContext(ctx, rawQuery, nvargs)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	cleanUp.inserted(res, err)
+	cleanUp.updated(err)
+	c.cleanUp.append(cleanUp)
+	return res, err
 }
 
 func (s *customCacheStatement) Query(args []driver.Value) (driver.Rows, error) {
-	ctx := context.WithValue(context.Background(), stmtKey{}, s)
-	ctx = context.WithValue(ctx, argsKey{}, args)
+	return s.QueryContext(context.Background(), valueToNamedValue(args))
+}
+
+func (s *customCacheStatement) QueryContext(ctx context.Context, nvargs []driver.NamedValue) (driver.Rows, error) {
+	args := namedToValue(nvargs)
 
-	conditions := s.queryInfo.Select.Conditions
-	// if query is like "SELECT * FROM table WHERE cond IN (?, ?, ?, ...)"
-	if len(conditions) == 1 && conditions[0].Operator == domains.CachePlanOperator_IN {
-		return s.inQuery(args)
+	// if query is like "SELECT * FROM table WHERE a = ? AND b IN (?, ?, ...)"
+	if hasInCondition(s.queryInfo.Select.Conditions) {
+		return s.inQuery(ctx, args)
 	}
 
-	cache := caches[cacheName(s.query)]
+	cache := s.conn.registry.caches[cacheName(s.query)]
+	if cache.window != nil {
+		return s.windowQuery(ctx, cache, nvargs)
+	}
 	key := cacheKey(args)
-	if s.conn.tx && cache.isNewerThan(key, s.conn.txStart) {
-		// cache is newer than the transaction start time
-		// we should not use the cache
-		return s.inner.Query(args)
+	if tx := s.conn.tx; tx != nil {
+		if tx.bypass(cache, key) {
+			return queryStmt(ctx, s.inner, nvargs)
+		}
+		return tx.get(cache, key, func() (driver.Rows, error) {
+			return queryStmt(ctx, s.inner, nvargs)
+		})
 	}
 
-	ctx = context.WithValue(ctx, cacheWithInfoKey{}, cache)
-	rows, err := cache.Get(ctx, key)
+	cacheCtx := context.WithValue(ctx, stmtKey{}, s)
+	cacheCtx = context.WithValue(cacheCtx, argsKey{}, args)
+	cacheCtx = context.WithValue(cacheCtx, cacheWithInfoKey{}, cache)
+	rows, err := cache.get(cacheCtx, key, s.conn.metrics)
 	if err != nil {
 		return nil, err
 	}
@@ -189,42 +222,52 @@ func (s *customCacheStatement) Query(args []driver.Value) (driver.Rows, error) {
 	return rows, nil
 }
 
-func (s *customCacheStatement) inQuery(args []driver.Value) (driver.Rows, error) {
-	// "SELECT * FROM table WHERE cond IN (?, ?, ...)"
-	// separate the query into multiple queries and merge the results
-	table := s.queryInfo.Select.Table
-	condIdx := s.queryInfo.Select.Conditions[0].Placeholder.Index
-	condValues := args[condIdx:]
-
-	// find the query "SELECT * FROM table WHERE cond = ?"
-	var cache *cacheWithInfo
-	for _, c := range cacheByTable[table] {
-		if len(c.info.Conditions) == 1 && c.info.Conditions[0].Column == s.queryInfo.Select.Conditions[0].Column && c.info.Conditions[0].Operator == domains.CachePlanOperator_EQ {
-			cache = c
-		}
+func (s *customCacheStatement) windowQuery(ctx context.Context, cache *cacheWithInfo, nvargs []driver.NamedValue) (driver.Rows, error) {
+	// "SELECT * FROM table WHERE cond = ? ORDER BY ... LIMIT ? OFFSET ?"
+	// slice the page from the window "... LIMIT offset+limit OFFSET 0"
+	args := namedToValue(nvargs)
+	key, offset, end, ok := cache.window.split(args)
+	// inside transactions, the query is read from the database as it is
+	if !ok || s.conn.tx != nil {
+		return queryStmt(ctx, s.inner, nvargs)
 	}
-	if cache == nil {
-		return s.inner.(driver.StmtQueryContext).QueryContext(context.Background(), valueToNamedValue(args))
+
+	cacheCtx := context.WithValue(ctx, stmtKey{}, s)
+	cacheCtx = context.WithValue(cacheCtx, argsKey{}, cache.window.widen(args, end))
+	cacheCtx = context.WithValue(cacheCtx, cacheWithInfoKey{}, cache)
+	return cache.getWindow(cacheCtx, key, offset, end, s.conn.metrics)
+}
+
+func (s *customCacheStatement) inQuery(ctx context.Context, args []driver.Value) (driver.Rows, error) {
+	// "SELECT * FROM table WHERE a = ? AND b IN (?, ?, ...)"
+	// separate the query into "SELECT * FROM table WHERE a = ? AND b = ?" for each combination of the values and merge the results
+	in, points, ok := s.conn.registry.expandInQuery(s.query, s.rawQuery, args)
+	// inside transactions, the query is read from the database as a whole
+	if !ok || s.conn.tx != nil {
+		return queryStmt(ctx, s.inner, valueToNamedValue(args))
 	}
 
-	allRows := make([]*cacheRows, 0, len(condValues))
-	for _, condValue := range condValues {
-		// prepare new statement
-		stmt, err := s.conn.Prepare(cache.query)
+	rows, ok, err := in.read(ctx, points, func(ctx context.Context, query string, args []driver.Value) (*cacheRows, error) {
+		stmt, err := s.conn.inner.Prepare(query)
 		if err != nil {
 			return nil, err
 		}
-		ctx := context.WithValue(context.Background(), stmtKey{}, stmt)
-		ctx = context.WithValue(ctx, argsKey{}, []driver.Value{condValue})
-		ctx = context.WithValue(ctx, cacheWithInfoKey{}, cache)
-		rows, err := cache.Get(ctx, cacheKey([]driver.Value{condValue}))
+		defer stmt.Close()
+		rows, err := queryStmt(ctx, stmt, valueToNamedValue(args))
 		if err != nil {
 			return nil, err
 		}
-		allRows = append(allRows, rows)
+		defer rows.Close()
+		// the rows are read before the statement is closed
+		return newCacheRows(rows)
+	}, s.conn.metrics)
+	if err != nil {
+		return nil, err
 	}
-
-	return mergeCachedRows(allRows), nil
+	if !ok {
+		return queryStmt(ctx, s.inner, valueToNamedValue(args))
+	}
+	return rows, nil
 }
 
 func (c *cacheConn) QueryContext(ctx context.Context, rawQuery string, nvargs []driver.NamedValue) (driver.Rows, error) {
@@ -233,13 +276,19 @@ func (c *cacheConn) QueryContext(ctx context.Context, rawQuery string, nvargs []
 		return nil, driver.ErrSkip
 	}
 
-	if !c.config.InterpolateParams {
+	if !c.interpolate {
 		return nil, driver.ErrSkip
 	}
 
-	normalizedQuery := normalizer.NormalizeQuery(rawQuery)
+	rawQuery, nvargs, err := bindNamedArgs(rawQuery, nvargs)
+	if err != nil {
+		return nil, err
+	}
+	bound := c.registry.bindLiterals(rawQuery)
+	rawQuery, normalizedQuery := bound.query, bound.normalized
+	nvargs = bindLiteralValues(bound.literals, nvargs)
 
-	queryInfo, ok := queryMap[normalizedQuery]
+	queryInfo, ok := c.registry.queryMap[normalizedQuery]
 	if !ok {
 		return inner.QueryContext(ctx, rawQuery, nvargs)
 	}
@@ -247,10 +296,9 @@ func (c *cacheConn) QueryContext(ctx context.Context, rawQuery string, nvargs []
 		return inner.QueryContext(ctx, rawQuery, nvargs)
 	}
 
-	conditions := queryInfo.Select.Conditions
-	// if query is like "SELECT * FROM table WHERE cond IN (?, ?, ?, ...)"
-	if len(conditions) == 1 && conditions[0].Operator == domains.CachePlanOperator_IN {
-		return c.inQuery(ctx, rawQuery, nvargs, inner)
+	// if query is like "SELECT * FROM table WHERE a = ? AND b IN (?, ?, ...)"
+	if hasInCondition(queryInfo.Select.Conditions) {
+		return c.inQuery(ctx, normalizedQuery, rawQuery, nvargs, inner)
 	}
 
 	args := make([]driver.Value, len(nvargs))
@@ -258,20 +306,26 @@ func (c *cacheConn) QueryContext(ctx context.Context, rawQuery string, nvargs []
 		args[i] = nv.Value
 	}
 
-	cache := caches[queryInfo.Query]
+	cache := c.registry.caches[queryInfo.Query]
+	if cache.window != nil {
+		return c.windowQuery(ctx, rawQuery, cache, nvargs, inner)
+	}
 	key := cacheKey(args)
 
-	if c.tx && cache.isNewerThan(key, c.txStart) {
-		// cache is newer than the transaction start time
-		// we should not use the cache
-		return inner.QueryContext(ctx, rawQuery, nvargs)
+	if c.tx != nil {
+		if c.tx.bypass(cache, key) {
+			return inner.QueryContext(ctx, rawQuery, nvargs)
+		}
+		return c.tx.get(cache, key, func() (driver.Rows, error) {
+			return inner.QueryContext(ctx, rawQuery, nvargs)
+		})
 	}
 
 	cacheCtx := context.WithValue(ctx, namedValueArgsKey{}, nvargs)
 	cacheCtx = context.WithValue(cacheCtx, queryerCtxKey{}, inner)
 	cacheCtx = context.WithValue(cacheCtx, queryKey{}, rawQuery)
 	cacheCtx = context.WithValue(cacheCtx, cacheWithInfoKey{}, cache)
-	rows, err := cache.Get(cacheCtx, key)
+	rows, err := cache.get(cacheCtx, key, c.metrics)
 	if err != nil {
 		return nil, err
 	}
@@ -279,57 +333,352 @@ func (c *cacheConn) QueryContext(ctx context.Context, rawQuery string, nvargs []
 	return rows, nil
 }
 
-func (c *cacheConn) inQuery(ctx context.Context, query string, args []driver.NamedValue, inner driver.QueryerContext) (driver.Rows, error) {
-	// "SELECT * FROM table WHERE cond IN (?, ?, ...)"
-	// separate the query into multiple queries and merge the results
-	normalizedQuery := normalizer.NormalizeQuery(query)
+func (c *cacheConn) windowQuery(ctx context.Context, query string, cache *cacheWithInfo, nvargs []driver.NamedValue, inner driver.QueryerContext) (driver.Rows, error) {
+	// "SELECT * FROM table WHERE cond = ? ORDER BY ... LIMIT ? OFFSET ?"
+	// slice the page from the window "... LIMIT offset+limit OFFSET 0"
+	args := namedToValue(nvargs)
+	key, offset, end, ok := cache.window.split(args)
+	// inside transactions, the query is read from the database as it is
+	if !ok || c.tx != nil {
+		return inner.QueryContext(ctx, query, nvargs)
+	}
+
+	cacheCtx := context.WithValue(ctx, namedValueArgsKey{}, valueToNamedValue(cache.window.widen(args, end)))
+	cacheCtx = context.WithValue(cacheCtx, queryerCtxKey{}, inner)
+	cacheCtx = context.WithValue(cacheCtx, queryKey{}, query)
+	cacheCtx = context.WithValue(cacheCtx, cacheWithInfoKey{}, cache)
+	return cache.getWindow(cacheCtx, key, offset, end, c.metrics)
+}
 
-	queryInfo := queryMap[normalizedQuery]
-	table := queryInfo.Select.Table
-	condIdx := queryInfo.Select.Conditions[0].Placeholder.Index
-	condValues := args[condIdx:]
+func (c *cacheConn) inQuery(ctx context.Context, normalizedQuery string, query string, args []driver.NamedValue, inner driver.QueryerContext) (driver.Rows, error) {
+	// "SELECT * FROM table WHERE a = ? AND b IN (?, ?, ...)"
+	// separate the query into "SELECT * FROM table WHERE a = ? AND b = ?" for each combination of the values and merge the results
+	in, points, ok := c.registry.expandInQuery(normalizedQuery, query, namedToValue(args))
+	// inside transactions, the query is read from the database as a whole
+	if !ok || c.tx != nil {
+		return inner.QueryContext(ctx, query, args)
+	}
 
-	// find the query "SELECT * FROM table WHERE cond = ?"
-	var cache *cacheWithInfo
-	for _, c := range cacheByTable[table] {
-		if len(c.info.Conditions) == 1 && c.info.Conditions[0].Column == queryInfo.Select.Conditions[0].Column && c.info.Conditions[0].Operator == domains.CachePlanOperator_EQ {
-			cache = c
+	rows, ok, err := in.read(ctx, points, func(ctx context.Context, query string, args []driver.Value) (*cacheRows, error) {
+		rows, err := inner.QueryContext(ctx, query, valueToNamedValue(args))
+		if err != nil {
+			return nil, err
 		}
+		defer rows.Close()
+		return newCacheRows(rows)
+	}, c.metrics)
+	if err != nil {
+		return nil, err
 	}
-	if cache == nil {
+	if !ok {
 		return inner.QueryContext(ctx, query, args)
 	}
+	return rows, nil
+}
 
-	allRows := make([]*cacheRows, 0, len(condValues))
-	for _, condValue := range condValues {
-		nvargs := []driver.NamedValue{condValue}
-		cacheCtx := context.WithValue(ctx, queryKey{}, cache.query)
-		cacheCtx = context.WithValue(cacheCtx, queryerCtxKey{}, inner)
-		cacheCtx = context.WithValue(cacheCtx, namedValueArgsKey{}, nvargs)
-		cacheCtx = context.WithValue(cacheCtx, cacheWithInfoKey{}, cache)
-		rows, err := cache.Get(cacheCtx, cacheKey([]driver.Value{condValue.Value}))
+// read returns the rows of the points read from the point cache and merged in the order of the IN query.
+// The points missing in the cache are fetched by query at once.
+// It returns false if the rows cannot be merged.
+func (in *inQuery) read(ctx context.Context, points [][]driver.Value, query func(ctx context.Context, query string, args []driver.Value) (*cacheRows, error), metrics MetricsSink) (*cacheRows, bool, error) {
+	batch := &inBatch{in: in, points: points, query: query}
+	cacheCtx := context.WithValue(ctx, inBatchKey{}, batch)
+	cacheCtx = context.WithValue(cacheCtx, cacheWithInfoKey{}, in.point)
+
+	allRows := make([]*cacheRows, 0, len(points))
+	for _, point := range points {
+		rows, err := in.point.get(cacheCtx, cacheKey(point), metrics)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		allRows = append(allRows, rows)
 	}
 
-	return mergeCachedRows(allRows), nil
+	rows, ok := mergeCachedRows(allRows, in.orders)
+	return rows, ok, nil
+}
+
+// inBatch fetches the rows of the points of an IN query missing in the cache with a single query.
+// The first miss fetches the points missing in the cache from it to the last, and the following misses are served from the rows.
+type inBatch struct {
+	in     *inQuery
+	points [][]driver.Value
+	// query runs the query on the inner connection
+	query func(ctx context.Context, query string, args []driver.Value) (*cacheRows, error)
+
+	mu sync.Mutex
+	// fetched are the rows fetched for each key at since
+	fetched map[string]*cacheRows
+	since   int64
 }
 
-func handleInsertQuery(query string, queryInfo domains.CachePlanInsertQuery, insertValues []driver.Value) (cleanUp cleanUpTask) {
+// rows returns the rows of the point of key and the time they are fetched at
+func (b *inBatch) rows(ctx context.Context, key string) (*cacheRows, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// the rows written after the fetch are fetched again
+	if rows, ok := b.fetched[key]; ok && !b.in.point.isNewerThan(key, b.since) {
+		return rows, b.since, nil
+	}
+
+	i := slices.IndexFunc(b.points, func(point []driver.Value) bool { return cacheKey(point) == key })
+	if i < 0 {
+		return nil, 0, fmt.Errorf("the key is not a point of %q", b.in.point.query)
+	}
+	// the points cached in the meantime are not fetched again
+	missing := [][]driver.Value{b.points[i]}
+	for _, point := range b.points[i+1:] {
+		if _, ok := b.in.point.GetIfExists(cacheKey(point)); !ok {
+			missing = append(missing, point)
+		}
+	}
+	since := time.Now().UnixNano()
+	fetched, err := b.fetch(ctx, missing)
+	if err != nil {
+		return nil, 0, err
+	}
+	b.fetched, b.since = fetched, since
+	return fetched[key], since, nil
+}
+
+// fetch fetches the rows of points by "SELECT * FROM table WHERE a = ? AND b IN (?, ?, ...)" and splits them by the values of the IN conditions
+func (b *inBatch) fetch(ctx context.Context, points [][]driver.Value) (map[string]*cacheRows, error) {
+	cache := b.in.point
+	var args []driver.Value
+	counts := make([]int, len(cache.info.Conditions))
+	for i := range cache.info.Conditions {
+		seen := make(map[string]struct{})
+		for _, point := range points {
+			key := cacheKey([]driver.Value{point[i]})
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			args = append(args, point[i])
+		}
+		counts[i] = len(seen)
+	}
+	query := cache.query
+	if cache.entities != nil && cache.projection != nil {
+		query = selectAll(query)
+	}
+	query, ok := inBatchQuery(query, counts)
+	if !ok {
+		return nil, fmt.Errorf("failed to rewrite %q into IN conditions", cache.query)
+	}
+
+	rows, err := b.query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]int, len(b.in.splits))
+	for i, split := range b.in.splits {
+		columns[i] = slices.Index(rows.columns, cache.info.Conditions[split].Column)
+		if columns[i] < 0 {
+			return nil, fmt.Errorf("the column %s is not found in the rows of %q", cache.info.Conditions[split].Column, cache.query)
+		}
+	}
+
+	// the rows of each point are in the order of the query
+	bySplit := make(map[string][]row)
+	for _, r := range rows.rows.rows {
+		values := make([]driver.Value, len(columns))
+		for i, column := range columns {
+			values[i] = parseIntValue(r[column])
+		}
+		key := cacheKey(values)
+		bySplit[key] = append(bySplit[key], r)
+	}
+	fetched := make(map[string]*cacheRows, len(points))
+	for _, point := range points {
+		values := make([]driver.Value, len(b.in.splits))
+		for i, split := range b.in.splits {
+			values[i] = parseIntValue(point[split])
+		}
+		fetched[cacheKey(point)] = &cacheRows{cached: true, columns: rows.columns, rows: sliceRows{rows: bySplit[cacheKey(values)]}}
+	}
+	return fetched, nil
+}
+
+// inBatchQuery rewrites the conditions "a = ? AND b = ?" of the point query into "a = ? AND b IN (?, ?, ...)",
+// where counts are the number of the values of each condition
+func inBatchQuery(query string, counts []int) (string, bool) {
+	tokens := sql_parser.TokenizeRaw(query)
+	where := slices.IndexFunc(tokens, func(t sql_parser.RawToken) bool { return isReserved(t, "WHERE") })
+	if where < 0 || where+4*len(counts) > len(tokens) {
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString(query[:tokens[where].Pos+len(tokens[where].Raw)])
+	for i, count := range counts {
+		condition := tokens[where+1+4*i : where+4+4*i]
+		if !condition[0].IsIdentifier() || !condition[1].IsSymbol("=") || !condition[2].IsPlaceholder() {
+			return "", false
+		}
+		if i > 0 {
+			if !isReserved(tokens[where+4*i], "AND") {
+				return "", false
+			}
+			b.WriteString(" AND")
+		}
+		b.WriteByte(' ')
+		b.WriteString(condition[0].Raw)
+		if count == 1 {
+			b.WriteString(" = ?")
+		} else {
+			b.WriteString(" IN (?" + strings.Repeat(", ?", count-1) + ")")
+		}
+	}
+	last := tokens[where+4*len(counts)-1]
+	b.WriteString(query[last.Pos+len(last.Raw):])
+	return b.String(), true
+}
+
+// inCondition is "col = ?" or "col IN (?, ?, ...)" in the WHERE clause of a select query
+type inCondition struct {
+	column string
+	// args are the positions of the placeholders in the args
+	args []int
+}
+
+// parseInConditions returns the conditions of query joined by AND if all of their values are placeholders.
+// It fails if the query has other placeholders, e.g. LIMIT ?.
+func parseInConditions(query string) ([]inCondition, bool) {
+	tokens := sql_parser.TokenizeRaw(query)
+	where := slices.IndexFunc(tokens, func(t sql_parser.RawToken) bool { return isReserved(t, "WHERE") })
+	if where < 0 || slices.ContainsFunc(tokens[:where], sql_parser.RawToken.IsPlaceholder) {
+		return nil, false
+	}
+
+	var conditions []inCondition
+	index := 0
+	i := where + 1
+	for {
+		if i+2 >= len(tokens) || !tokens[i].IsIdentifier() {
+			return nil, false
+		}
+		condition := inCondition{column: tokens[i].Literal()}
+		switch {
+		case tokens[i+1].IsSymbol("=") && tokens[i+2].IsPlaceholder():
+			condition.args = []int{index}
+			index++
+			i += 3
+		case isReserved(tokens[i+1], "IN") && tokens[i+2].IsSymbol("("):
+			for i += 3; i+1 < len(tokens) && tokens[i].IsPlaceholder(); i += 2 {
+				condition.args = append(condition.args, index)
+				index++
+				if tokens[i+1].IsSymbol(")") {
+					break
+				}
+				if !tokens[i+1].IsSymbol(",") {
+					return nil, false
+				}
+			}
+			if i+1 >= len(tokens) || !tokens[i].IsPlaceholder() || !tokens[i+1].IsSymbol(")") {
+				return nil, false
+			}
+			i += 2
+		default:
+			return nil, false
+		}
+		conditions = append(conditions, condition)
+
+		if i == len(tokens) || isReserved(tokens[i], "ORDER BY") || tokens[i].IsSymbol(";") {
+			break
+		}
+		if !isReserved(tokens[i], "AND") {
+			return nil, false
+		}
+		i++
+	}
+	if slices.ContainsFunc(tokens[i:], sql_parser.RawToken.IsPlaceholder) {
+		return nil, false
+	}
+	return conditions, true
+}
+
+// expandInQuery returns the IN query and the args of its point query for each combination of the values of args.
+// The raw query must have the conditions of the point query in the same order.
+func (r *registry) expandInQuery(query string, rawQuery string, args []driver.Value) (*inQuery, [][]driver.Value, bool) {
+	in, ok := r.inQueries[query]
+	if !ok {
+		return nil, nil, false
+	}
+	conditions, ok := parseInConditions(rawQuery)
+	if !ok || len(conditions) != len(in.point.info.Conditions) {
+		return nil, nil, false
+	}
+	points := [][]driver.Value{nil}
+	for i, condition := range conditions {
+		if condition.column != in.point.info.Conditions[i].Column || condition.args[len(condition.args)-1] >= len(args) {
+			return nil, nil, false
+		}
+		points = cartesian(points, condition.args, args)
+	}
+	// the rows fetched at once are split by the integers of the IN conditions
+	for _, split := range in.splits {
+		for _, point := range points {
+			if _, ok := parseIntValue(point[split]).(int64); !ok {
+				return nil, nil, false
+			}
+		}
+	}
+
+	// the repeated values are read once, as MySQL returns each row once
+	seen := make(map[string]struct{}, len(points))
+	points = slices.DeleteFunc(points, func(point []driver.Value) bool {
+		key := cacheKey(point)
+		_, ok := seen[key]
+		seen[key] = struct{}{}
+		return ok
+	})
+	return in, points, true
+}
+
+// cartesian appends each of the args at indexes to each of points
+func cartesian(points [][]driver.Value, indexes []int, args []driver.Value) [][]driver.Value {
+	product := make([][]driver.Value, 0, len(points)*len(indexes))
+	for _, point := range points {
+		for _, index := range indexes {
+			product = append(product, append(slices.Clip(point), args[index]))
+		}
+	}
+	return product
+}
+
+func (r *registry) handleInsertQuery(rawQuery string, query string, queryInfo domains.CachePlanInsertQuery, insertValues []driver.Value) (cleanUp cleanUpTask) {
 	table := queryInfo.Table
 	insertArgs, _ := normalizer.NormalizeArgs(query)
 
 	rows := slices.Chunk(insertValues, len(queryInfo.Columns))
 
-	for _, cache := range cacheByTable[table] {
+	onDuplicate := strings.Contains(query, "ON DUPLICATE KEY UPDATE")
+	if entities, ok := r.entities[table]; ok && onDuplicate {
+		// the existing rows may be updated
+		cleanUp.purgeEntities = append(cleanUp.purgeEntities, entities)
+	}
+
+	// the rows to be added to the cached lists, or nil if they are not known before the insert
+	var inserted []*insertedRow
+	if entities, ok := r.entities[table]; ok && !onDuplicate && len(insertArgs.ExtraArgs) == 0 && sql_parser.ValuesArePlaceholders(rawQuery) {
+		inserted = r.insertedRows(entities, queryInfo.Columns, rows)
+	}
+
+	for _, cache := range r.cacheByTable[table] {
 		if cache.uniqueOnly {
 			// no need to purge
 			continue
 		}
 
 		cacheConditions := cache.info.Conditions
+		if len(cacheConditions) == 0 && cache.lists != nil && inserted != nil {
+			// select query: "SELECT * FROM table ORDER BY ... LIMIT ?"
+			// add the rows to the only list
+			for _, row := range inserted {
+				cleanUp.insert = append(cleanUp.insert, insertTask{cache, cacheKey(nil), row})
+			}
+			continue
+		}
 		isComplexQuery := len(cacheConditions) != 1 || len(insertArgs.ExtraArgs) > 0 || cacheConditions[0].Operator != domains.CachePlanOperator_EQ
 		if isComplexQuery {
 			cleanUp.purge = append(cleanUp.purge, cache)
@@ -341,10 +690,20 @@ func handleInsertQuery(query string, queryInfo domains.CachePlanInsertQuery, ins
 		if insertColumnIdx >= 0 {
 			// insert query: "INSERT INTO table (col1, col2, ...) VALUES (?, ?, ...), (?, ?, ...), ..."
 			// select query: "SELECT * FROM table WHERE col1 = ?"
-			// forget the cache
+			// add the rows to the cache if possible, or forget the cache
+			i := 0
 			for row := range rows {
-				cleanUp.forget = append(cleanUp.forget, forgetTask{cache, cacheKey([]driver.Value{row[insertColumnIdx]})})
+				key := cacheKey([]driver.Value{row[insertColumnIdx]})
+				if cache.lists != nil && inserted != nil {
+					cleanUp.insert = append(cleanUp.insert, insertTask{cache, key, inserted[i]})
+				} else {
+					cleanUp.forget = append(cleanUp.forget, forgetTask{cache, key})
+				}
+				i++
 			}
+		} else if column := r.tableSchema[table].Columns[cacheCondition.Column]; column.IsNullable && !column.HasDefault {
+			// the new rows have NULL in the column, which never matches "col = ?"
+			continue
 		} else {
 			cleanUp.purge = append(cleanUp.purge, cache)
 		}
@@ -353,20 +712,97 @@ func handleInsertQuery(query string, queryInfo domains.CachePlanInsertQuery, ins
 	return cleanUp
 }
 
-func handleUpdateQuery(queryInfo domains.CachePlanUpdateQuery, args []driver.Value) cleanUpTask {
+// insertedRow is a row of an insert query, whose auto-increment primary key is filled after the insert
+type insertedRow struct {
+	columns []string
+	values  row
+	// autoIncrement is the index of the primary key filled by LastInsertId, or -1
+	autoIncrement int
+}
+
+// insertedRows returns the rows of the insert query with their primary keys,
+// or nil if the primary keys are not known even after the insert
+func (r *registry) insertedRows(entities *entityStore, columns []string, rows iter.Seq[[]driver.Value]) []*insertedRow {
+	autoIncrement := -1
+	var missing []string
+	for _, pk := range entities.primaryKeys {
+		if !slices.Contains(columns, pk) {
+			missing = append(missing, pk)
+		}
+	}
+	if len(missing) > 0 {
+		if len(missing) > 1 || !r.tableSchema[entities.table].Columns[missing[0]].IsAutoIncrement {
+			return nil
+		}
+		columns = append(slices.Clone(columns), missing[0])
+		autoIncrement = len(columns) - 1
+	}
+
+	var inserted []*insertedRow
+	for values := range rows {
+		if len(values) != len(columns) && !(autoIncrement >= 0 && len(values) == len(columns)-1) {
+			return nil
+		}
+		values := slices.Clone(values)
+		if autoIncrement >= 0 {
+			values = append(values, nil)
+		}
+		inserted = append(inserted, &insertedRow{columns: columns, values: values, autoIncrement: autoIncrement})
+	}
+	if autoIncrement >= 0 && len(inserted) != 1 {
+		// the ids of the rows inserted at once are not always consecutive
+		return nil
+	}
+	return inserted
+}
+
+func (r *insertedRow) entityKey(primaryKeys []string) (string, bool) {
+	pk := make([]driver.Value, len(primaryKeys))
+	for i, column := range primaryKeys {
+		idx := slices.Index(r.columns, column)
+		if idx < 0 || r.values[idx] == nil {
+			return "", false
+		}
+		pk[i] = r.values[idx]
+	}
+	return cacheKey(pk), true
+}
+
+func (r *registry) handleUpdateQuery(query string, queryInfo domains.CachePlanUpdateQuery, args []driver.Value) cleanUpTask {
 	// TODO: support composite primary key and other unique key
 	table := queryInfo.Table
 	updateConditions := queryInfo.Conditions
 
 	var cleanUp cleanUpTask
 
+	updateByUnique := r.isSingleUniqueCondition(updateConditions, table)
+	writeThrough := false
+	if entities, ok := r.entities[table]; ok {
+		if updateByUnique && slices.Equal(entities.primaryKeys, []string{updateConditions[0].Column}) {
+			task := entityTask{entities, cacheKey([]driver.Value{args[updateConditions[0].Placeholder.Index]})}
+			if update, ok := r.entityUpdate(task, query, args); ok {
+				// "UPDATE table SET col1 = ?, col2 = ? WHERE pk = ?" rewrites the cached entity with the values
+				cleanUp.updateEntities = append(cleanUp.updateEntities, update)
+				writeThrough = true
+			} else {
+				cleanUp.forgetEntities = append(cleanUp.forgetEntities, task)
+			}
+		} else {
+			cleanUp.purgeEntities = append(cleanUp.purgeEntities, entities)
+		}
+	}
+
 	// if query is NOT "UPDATE `table` SET ... WHERE `unique_col` = ?"
-	if !isSingleUniqueCondition(updateConditions, table) {
-		for _, cache := range cacheByTable[table] {
+	if !updateByUnique {
+		for _, cache := range r.cacheByTable[table] {
 			if !usedBySelectQuery(cache.info.Targets, queryInfo.Targets) {
 				// no need to purge because the cache does not contain the updated column
 				continue
 			}
+			if cache.entities != nil && !usedByConditions(cache.info, queryInfo.Targets) {
+				// the rows of the cache do not change, and their columns are fixed through the entity store
+				continue
+			}
 			cleanUp.purge = append(cleanUp.purge, cache)
 		}
 		return cleanUp
@@ -375,25 +811,31 @@ func handleUpdateQuery(queryInfo domains.CachePlanUpdateQuery, args []driver.Val
 	updateCondition := updateConditions[0]
 	uniqueValue := args[updateCondition.Placeholder.Index]
 
-	for _, cache := range cacheByTable[table] {
+	for _, cache := range r.cacheByTable[table] {
 		if !usedBySelectQuery(cache.info.Targets, queryInfo.Targets) {
 			// no need to purge because the cache does not contain the updated column
 			continue
 		}
 
+		if writeThrough && cache.entities != nil && !usedByConditions(cache.info, queryInfo.Targets) {
+			// the updated columns are written through the entity store
+			continue
+		}
+
 		cacheConditions := cache.info.Conditions
-		if isSingleUniqueCondition(cacheConditions, table) && cacheConditions[0].Column == updateCondition.Column {
+		if r.isSingleUniqueCondition(cacheConditions, table) && cacheConditions[0].Column == updateCondition.Column {
 			// forget only the updated row
 			cleanUp.forget = append(cleanUp.forget, forgetTask{cache, cacheKey([]driver.Value{uniqueValue})})
-		} else {
+		} else if cache.entities == nil || usedByConditions(cache.info, queryInfo.Targets) {
 			cleanUp.purge = append(cleanUp.purge, cache)
 		}
+		// otherwise the rows of the cache do not change, and their columns are fixed through the entity store
 	}
 
 	return cleanUp
 }
 
-func handleDeleteQuery(queryInfo domains.CachePlanDeleteQuery, args []driver.Value) cleanUpTask {
+func (r *registry) handleDeleteQuery(queryInfo domains.CachePlanDeleteQuery, args []driver.Value) cleanUpTask {
 	table := queryInfo.Table
 
 	var cleanUp cleanUpTask
@@ -402,30 +844,173 @@ func handleDeleteQuery(queryInfo domains.CachePlanDeleteQuery, args []driver.Val
 	var deleteByUnique bool
 	if len(queryInfo.Conditions) == 1 {
 		condition := queryInfo.Conditions[0]
-		column := tableSchema[table].Columns[condition.Column]
+		column := r.tableSchema[table].Columns[condition.Column]
 		deleteByUnique = (column.IsPrimary || column.IsUnique) && condition.Operator == domains.CachePlanOperator_EQ
 	}
+	entities, hasEntities := r.entities[table]
+	if hasEntities {
+		if deleteByUnique && slices.Equal(entities.primaryKeys, []string{queryInfo.Conditions[0].Column}) {
+			pk := args[queryInfo.Conditions[0].Placeholder.Index]
+			cleanUp.forgetEntities = append(cleanUp.forgetEntities, entityTask{entities, cacheKey([]driver.Value{pk})})
+		} else {
+			cleanUp.purgeEntities = append(cleanUp.purgeEntities, entities)
+		}
+	}
+
 	if !deleteByUnique {
 		// we should purge all cache
-		cleanUp.purge = append(cleanUp.purge, cacheByTable[table]...)
+		for _, cache := range r.cacheByTable[table] {
+			if cache.entities != nil {
+				// the deleted entities are not found in the entity store, so the rows are fetched again
+				continue
+			}
+			cleanUp.purge = append(cleanUp.purge, cache)
+		}
 		return cleanUp
 	}
 
 	uniqueValue := args[queryInfo.Conditions[0].Placeholder.Index]
 
-	for _, cache := range cacheByTable[table] {
+	for _, cache := range r.cacheByTable[table] {
 		if cache.uniqueOnly {
 			// query like "SELECT * FROM table WHERE pk = ?"
 			// we should forget the cache
 			cleanUp.forget = append(cleanUp.forget, forgetTask{cache, cacheKey([]driver.Value{uniqueValue})})
-		} else {
+		} else if cache.entities == nil {
 			cleanUp.purge = append(cleanUp.purge, cache)
 		}
+		// otherwise the deleted entity is not found in the entity store, so the rows are fetched again
 	}
 
 	return cleanUp
 }
 
+// updateSet is "col = ?" in the SET clause of an update query
+type updateSet struct {
+	column string
+	// index is the position of the placeholder in the args
+	index int
+}
+
+// parseUpdateSets returns the assignments of query if all of their values are placeholders,
+// which include the literals replaced with placeholders in the plan
+func parseUpdateSets(query string) ([]updateSet, bool) {
+	tokens := sql_parser.TokenizeRaw(query)
+	set := slices.IndexFunc(tokens, func(t sql_parser.RawToken) bool { return isReserved(t, "SET") })
+	if set < 0 {
+		return nil, false
+	}
+	index := 0
+	for _, t := range tokens[:set] {
+		if t.IsPlaceholder() {
+			index++
+		}
+	}
+
+	var sets []updateSet
+	for i := set + 1; i < len(tokens); i += 4 {
+		if i+2 >= len(tokens) || !tokens[i].IsIdentifier() || !tokens[i+1].IsSymbol("=") || !tokens[i+2].IsPlaceholder() {
+			return nil, false
+		}
+		sets = append(sets, updateSet{column: tokens[i].Literal(), index: index})
+		index++
+		if i+3 == len(tokens) {
+			break
+		}
+		next := tokens[i+3]
+		if isReserved(next, "WHERE") || isReserved(next, "ORDER BY") || isReserved(next, "LIMIT") || next.IsSymbol(";") {
+			break
+		}
+		if !next.IsSymbol(",") {
+			return nil, false
+		}
+	}
+	return sets, len(sets) > 0
+}
+
+// entityUpdate returns the values written to the entity of task by the update query.
+// It fails if the query is not in r.updateSets, updates the primary key, or the values may differ from the ones read from the database.
+func (r *registry) entityUpdate(task entityTask, query string, args []driver.Value) (entityUpdate, bool) {
+	sets, ok := r.updateSets[query]
+	if !ok {
+		return entityUpdate{}, false
+	}
+	columns := r.tableSchema[task.entities.table].Columns
+	update := entityUpdate{entityTask: task, columns: make([]string, len(sets)), values: make([]driver.Value, len(sets))}
+	for i, set := range sets {
+		column, ok := columns[set.column]
+		if !ok || column.IsPrimary || set.index >= len(args) {
+			return entityUpdate{}, false
+		}
+		value, ok := entityValue(column, args[set.index])
+		if !ok {
+			return entityUpdate{}, false
+		}
+		update.columns[i] = set.column
+		update.values[i] = value
+	}
+	return update, true
+}
+
+// entityValue converts the arg written to the column into the value read from it
+func entityValue(column domains.TableSchemaColumn, arg driver.Value) (driver.Value, bool) {
+	if arg == nil {
+		return nil, column.IsNullable
+	}
+	switch column.DataType {
+	case domains.TableSchemaDataType_STRING, domains.TableSchemaDataType_BYTES:
+		switch v := arg.(type) {
+		case string:
+			return v, true
+		case []byte:
+			// the caller may reuse the buffer after the query
+			return slices.Clone(v), true
+		}
+	case domains.TableSchemaDataType_INT, domains.TableSchemaDataType_INT64:
+		switch v := arg.(type) {
+		case int64:
+			return v, true
+		case bool:
+			if v {
+				return int64(1), true
+			}
+			return int64(0), true
+		}
+	}
+	return nil, false
+}
+
+// insertValue converts the arg inserted into the column into the value read from it.
+// A datetime is read in the location of sample, which is a value read from the column.
+func insertValue(column domains.TableSchemaColumn, arg driver.Value, sample driver.Value) (driver.Value, bool) {
+	if column.DataType == domains.TableSchemaDataType_DATETIME {
+		t, ok := arg.(time.Time)
+		s, sampled := sample.(time.Time)
+		// the fractional seconds may be rounded by the database
+		if !ok || !sampled || t.Nanosecond() != 0 {
+			return nil, arg == nil && column.IsNullable
+		}
+		return t.In(s.Location()), true
+	}
+	return entityValue(column, arg)
+}
+
+// usedByConditions reports whether the rows of the select query may change by updating the columns
+func usedByConditions(selectQuery domains.CachePlanSelectQuery, updateTarget []domains.CachePlanUpdateTarget) bool {
+	for _, target := range updateTarget {
+		inConditions := slices.ContainsFunc(selectQuery.Conditions, func(condition domains.CachePlanCondition) bool {
+			return condition.Column == target.Column
+		})
+		inOrders := slices.ContainsFunc(selectQuery.Orders, func(order domains.CachePlanOrder) bool {
+			return order.Column == target.Column
+		})
+		if inConditions || inOrders {
+			return true
+		}
+	}
+	return false
+}
+
 func usedBySelectQuery(selectTarget []string, updateTarget []domains.CachePlanUpdateTarget) bool {
 	for _, target := range updateTarget {
 		inSelectTarget := slices.ContainsFunc(selectTarget, func(selectTarget string) bool {
@@ -438,15 +1023,189 @@ func usedBySelectQuery(selectTarget []string, updateTarget []domains.CachePlanUp
 	return false
 }
 
-func isSingleUniqueCondition(conditions []domains.CachePlanCondition, table string) bool {
+func (r *registry) isSingleUniqueCondition(conditions []domains.CachePlanCondition, table string) bool {
 	if len(conditions) != 1 {
 		return false
 	}
 	condition := conditions[0]
-	column := tableSchema[table].Columns[condition.Column]
+	column := r.tableSchema[table].Columns[condition.Column]
 	return (column.IsPrimary || column.IsUnique) && condition.Operator == domains.CachePlanOperator_EQ
 }
 
+var (
+	_ driver.StmtQueryContext = &namedStmt{}
+	_ driver.StmtExecContext  = &namedStmt{}
+)
+
+// namedStmt is a statement prepared from a query with named placeholders (:name).
+// The named args (sql.Named) are ordered by the placeholders before being passed to the inner statement.
+type namedStmt struct {
+	driver.Stmt
+	names []string
+}
+
+// NumInput is -1 since a name repeated in the query is given once, so the number of the args differs from the placeholders
+func (s *namedStmt) NumInput() int {
+	return -1
+}
+
+func (s *namedStmt) QueryContext(ctx context.Context, nvargs []driver.NamedValue) (driver.Rows, error) {
+	args, err := bindNamedValues(s.names, nvargs)
+	if err != nil {
+		return nil, err
+	}
+	return queryStmt(ctx, s.Stmt, args)
+}
+
+func (s *namedStmt) ExecContext(ctx context.Context, nvargs []driver.NamedValue) (driver.Result, error) {
+	args, err := bindNamedValues(s.names, nvargs)
+	if err != nil {
+		return nil, err
+	}
+	return execStmt(ctx, s.Stmt, args)
+}
+
+var (
+	_ driver.StmtQueryContext = &literalStmt{}
+	_ driver.StmtExecContext  = &literalStmt{}
+)
+
+// literalStmt is a statement prepared from a query whose literals are replaced with placeholders by bindLiterals.
+// The literals are inserted into the args before being passed to the inner statement.
+type literalStmt struct {
+	driver.Stmt
+	literals []normalizer.Literal
+}
+
+func (s *literalStmt) NumInput() int {
+	n := s.Stmt.NumInput()
+	if n < 0 {
+		return n
+	}
+	return n - len(s.literals)
+}
+
+func (s *literalStmt) QueryContext(ctx context.Context, nvargs []driver.NamedValue) (driver.Rows, error) {
+	args := bindLiteralValues(s.literals, nvargs)
+	return queryStmt(ctx, s.Stmt, args)
+}
+
+func (s *literalStmt) ExecContext(ctx context.Context, nvargs []driver.NamedValue) (driver.Result, error) {
+	args := bindLiteralValues(s.literals, nvargs)
+	return execStmt(ctx, s.Stmt, args)
+}
+
+// boundQuery is the query bound by bindLiterals
+type boundQuery struct {
+	query string
+	// normalized is the normalized query looked up in the plan
+	normalized string
+	literals   []normalizer.Literal
+}
+
+// bindLiterals replaces the literals in query with ? if the query itself is not in the plan but its fingerprint is,
+// so that the queries with inlined literals (e.g. built by fmt.Sprintf) are treated as instances of the planned query.
+// The result is cached by the raw query.
+func (r *registry) bindLiterals(query string) boundQuery {
+	bound, _ := r.bound.Get(context.Background(), query)
+	return bound
+}
+
+func (r *registry) replaceLiterals(query string) boundQuery {
+	normalized := normalizer.NormalizeQuery(query)
+	if _, ok := r.queryMap[normalized]; ok {
+		return boundQuery{query: query, normalized: normalized}
+	}
+	bound, literals := normalizer.ReplaceLiterals(query)
+	if len(literals) == 0 {
+		return boundQuery{query: query, normalized: normalized}
+	}
+	boundNormalized := normalizer.NormalizeQuery(bound)
+	if _, ok := r.queryMap[boundNormalized]; !ok {
+		return boundQuery{query: query, normalized: normalized}
+	}
+	return boundQuery{query: bound, normalized: boundNormalized, literals: literals}
+}
+
+// bindLiteralValues inserts the literals replaced by bindLiterals into the positional args
+func bindLiteralValues(literals []normalizer.Literal, nvargs []driver.NamedValue) []driver.NamedValue {
+	if len(literals) == 0 {
+		return nvargs
+	}
+	args := make([]driver.NamedValue, 0, len(nvargs)+len(literals))
+	for len(args) < cap(args) {
+		i := len(args)
+		if len(literals) > 0 && literals[0].Index == i {
+			args = append(args, driver.NamedValue{Ordinal: i + 1, Value: literals[0].Value})
+			literals = literals[1:]
+			continue
+		}
+		if len(nvargs) == 0 {
+			break
+		}
+		nv := nvargs[0]
+		nv.Ordinal = i + 1
+		args = append(args, nv)
+		nvargs = nvargs[1:]
+	}
+	return args
+}
+
+// bindNamedArgs rewrites the named placeholders (:name) in query to ? and orders the named args accordingly,
+// because the inner driver supports neither of them
+func bindNamedArgs(query string, nvargs []driver.NamedValue) (string, []driver.NamedValue, error) {
+	bound, names := normalizer.BindNamedQuery(query)
+	args, err := bindNamedValues(names, nvargs)
+	if err != nil {
+		return "", nil, err
+	}
+	return bound, args, nil
+}
+
+// bindNamedValues resolves driver.NamedValue.Name into the positions of the placeholders named names
+func bindNamedValues(names []string, nvargs []driver.NamedValue) ([]driver.NamedValue, error) {
+	named := slices.ContainsFunc(nvargs, func(nv driver.NamedValue) bool { return nv.Name != "" })
+	if !named {
+		return nvargs, nil
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("named args are given to a query without named placeholders")
+	}
+
+	byName := make(map[string]driver.Value, len(nvargs))
+	for _, nv := range nvargs {
+		if nv.Name == "" {
+			return nil, fmt.Errorf("named and positional args cannot be mixed")
+		}
+		byName[nv.Name] = nv.Value
+	}
+	args := make([]driver.NamedValue, len(names))
+	for i, name := range names {
+		value, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("named arg %q is not given", name)
+		}
+		args[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+	return args, nil
+}
+
+// queryStmt runs stmt with ctx if the driver supports it
+func queryStmt(ctx context.Context, stmt driver.Stmt, nvargs []driver.NamedValue) (driver.Rows, error) {
+	if inner, ok := stmt.(driver.StmtQueryContext); ok {
+		return inner.QueryContext(ctx, nvargs)
+	}
+	return stmt.Query(namedToValue(nvargs))
+}
+
+// execStmt runs stmt with ctx if the driver supports it
+func execStmt(ctx context.Context, stmt driver.Stmt, nvargs []driver.NamedValue) (driver.Result, error) {
+	if inner, ok := stmt.(driver.StmtExecContext); ok {
+		return inner.ExecContext(ctx, nvargs)
+	}
+	return stmt.Exec(namedToValue(nvargs))
+}
+
 func valueToNamedValue(args []driver.Value) []driver.NamedValue {
 	nvargs := make([]driver.NamedValue, len(args))
 	for i, arg := range args {
@@ -468,27 +1227,189 @@ type forgetTask struct {
 	key   string
 }
 
+type entityTask struct {
+	entities *entityStore
+	key      string
+}
+
+type entityUpdate struct {
+	entityTask
+	columns []string
+	values  []driver.Value
+}
+
+type insertTask struct {
+	cache *cacheWithInfo
+	key   string
+	row   *insertedRow
+}
+
 type cleanUpTask struct {
-	purge  []*cacheWithInfo
-	forget []forgetTask
+	purge          []*cacheWithInfo
+	forget         []forgetTask
+	insert         []insertTask
+	purgeEntities  []*entityStore
+	forgetEntities []entityTask
+	updateEntities []entityUpdate
 }
 
 func (c *cleanUpTask) reset() {
 	c.purge = c.purge[:0]
 	c.forget = c.forget[:0]
+	c.insert = c.insert[:0]
+	c.purgeEntities = c.purgeEntities[:0]
+	c.forgetEntities = c.forgetEntities[:0]
+	c.updateEntities = c.updateEntities[:0]
 }
 
-func (c *cleanUpTask) do() {
+func (c *cleanUpTask) do(metrics MetricsSink) {
+	// the transactions started before are not served from the cleaned caches
+	for _, cache := range c.purge {
+		cache.updateTx()
+	}
+	for _, forget := range c.forget {
+		forget.cache.updateByKeyTx(forget.key)
+	}
+	for _, insert := range c.insert {
+		insert.cache.updateByKeyTx(insert.key)
+	}
+
+	for _, entities := range c.purgeEntities {
+		entities.purge()
+	}
+	for _, forget := range c.forgetEntities {
+		forget.entities.forget(forget.key)
+	}
+	for _, update := range c.updateEntities {
+		update.entities.update(update.key, update.columns, update.values)
+	}
 	for _, cache := range c.purge {
 		cache.Purge()
+		metrics.Purge(cache.query)
 	}
 	for _, forget := range c.forget {
 		forget.cache.Forget(forget.key)
+		metrics.Forget(forget.cache.query)
+	}
+	for _, insert := range c.insert {
+		insert.cache.insert(insert)
 	}
 	c.reset()
 }
 
+// inserted fills the auto-increment primary keys of the inserted rows by the result of the insert,
+// or falls back to forgetting the lists if the rows are not known
+func (c *cleanUpTask) inserted(res driver.Result, err error) {
+	tasks := c.insert
+	c.insert = nil
+	for _, task := range tasks {
+		if err == nil && task.row.autoIncrement >= 0 && task.row.values[task.row.autoIncrement] == nil {
+			if id, idErr := res.LastInsertId(); idErr == nil && id > 0 {
+				task.row.values[task.row.autoIncrement] = id
+			}
+		}
+		if err != nil || (task.row.autoIncrement >= 0 && task.row.values[task.row.autoIncrement] == nil) {
+			c.forget = append(c.forget, forgetTask{task.cache, task.key})
+			continue
+		}
+		c.insert = append(c.insert, task)
+	}
+}
+
+// updated falls back to forgetting the entities written through if the update fails,
+// since the values may have been rejected by the database
+func (c *cleanUpTask) updated(err error) {
+	if err == nil {
+		return
+	}
+	for _, update := range c.updateEntities {
+		c.forgetEntities = append(c.forgetEntities, update.entityTask)
+	}
+	c.updateEntities = nil
+}
+
 func (c *cleanUpTask) append(tasks cleanUpTask) {
 	c.purge = append(c.purge, tasks.purge...)
 	c.forget = append(c.forget, tasks.forget...)
+	c.insert = append(c.insert, tasks.insert...)
+	c.purgeEntities = append(c.purgeEntities, tasks.purgeEntities...)
+	c.forgetEntities = append(c.forgetEntities, tasks.forgetEntities...)
+	c.updateEntities = append(c.updateEntities, tasks.updateEntities...)
+}
+
+// txOverlay is the caches written inside a transaction.
+// They are read from the database until the transaction ends, and cleaned up only if it is committed.
+type txOverlay struct {
+	cleanUp           cleanUpTask
+	purged            map[*cacheWithInfo]struct{}
+	forgotten         map[forgetTask]struct{}
+	purgedEntities    map[*entityStore]struct{}
+	forgottenEntities map[entityTask]struct{}
+}
+
+func (o *txOverlay) add(tasks cleanUpTask) {
+	if o.purged == nil {
+		o.purged = make(map[*cacheWithInfo]struct{})
+		o.forgotten = make(map[forgetTask]struct{})
+		o.purgedEntities = make(map[*entityStore]struct{})
+		o.forgottenEntities = make(map[entityTask]struct{})
+	}
+	for _, cache := range tasks.purge {
+		if _, ok := o.purged[cache]; !ok {
+			o.purged[cache] = struct{}{}
+			o.cleanUp.purge = append(o.cleanUp.purge, cache)
+		}
+	}
+	for _, forget := range tasks.forget {
+		if _, ok := o.forgotten[forget]; !ok {
+			o.forgotten[forget] = struct{}{}
+			o.cleanUp.forget = append(o.cleanUp.forget, forget)
+		}
+	}
+	for _, entities := range tasks.purgeEntities {
+		if _, ok := o.purgedEntities[entities]; !ok {
+			o.purgedEntities[entities] = struct{}{}
+			o.cleanUp.purgeEntities = append(o.cleanUp.purgeEntities, entities)
+		}
+	}
+	for _, forget := range tasks.forgetEntities {
+		if _, ok := o.forgottenEntities[forget]; !ok {
+			o.forgottenEntities[forget] = struct{}{}
+			o.cleanUp.forgetEntities = append(o.cleanUp.forgetEntities, forget)
+		}
+	}
+	for _, insert := range tasks.insert {
+		// the list is read from the database until the commit
+		o.forgotten[forgetTask{insert.cache, insert.key}] = struct{}{}
+		o.cleanUp.insert = append(o.cleanUp.insert, insert)
+	}
+	for _, update := range tasks.updateEntities {
+		// the updates are applied in order, and the entity is read from the database until the commit
+		o.forgottenEntities[update.entityTask] = struct{}{}
+		o.cleanUp.updateEntities = append(o.cleanUp.updateEntities, update)
+	}
+}
+
+func (o *txOverlay) touched(cache *cacheWithInfo, key string) bool {
+	if _, ok := o.purged[cache]; ok {
+		return true
+	}
+	_, ok := o.forgotten[forgetTask{cache, key}]
+	return ok
+}
+
+func (o *txOverlay) touchedEntity(entities *entityStore, key string) bool {
+	if _, ok := o.purgedEntities[entities]; ok {
+		return true
+	}
+	_, ok := o.forgottenEntities[entityTask{entities, key}]
+	return ok
+}
+
+func (o *txOverlay) reset() {
+	o.cleanUp.reset()
+	clear(o.purged)
+	clear(o.forgotten)
+	clear(o.purgedEntities)
+	clear(o.forgottenEntities)
 }
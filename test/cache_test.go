@@ -2,12 +2,15 @@ package test
 
 import (
 	"database/sql"
+	"log"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
+	"github.com/traP-jp/isuc/domains"
 	"github.com/traP-jp/isuc/normalizer"
 	"github.com/traP-jp/isuc/test/cache"
 	dbtest "github.com/traP-jp/isuc/testutil/db"
@@ -16,7 +19,8 @@ import (
 func dbs(t *testing.T) map[string]*sqlx.DB {
 	db := NewDB(t)
 	withInterpolateParamsDB := NewDB(t, dbtest.WithInterpolateParams())
-	return map[string]*sqlx.DB{"interpolateParams=false": db, "interpolateParams=true": withInterpolateParamsDB}
+	wrappedDB := NewWrappedDB(t, dbtest.WithInterpolateParams())
+	return map[string]*sqlx.DB{"interpolateParams=false": db, "interpolateParams=true": withInterpolateParamsDB, "wrapped": wrappedDB}
 }
 
 func TestSimpleQuery(t *testing.T) {
@@ -97,7 +101,7 @@ func testSelectAfterUpdate(t *testing.T, db *sqlx.DB) {
 	}
 	user.Name = "updated"
 
-	// no cache hit because users with id=1 is updated
+	// cache hit because the new name is written through the cache
 	var user2 User
 	err = db.Get(&user2, "SELECT * FROM `users` WHERE `id` = ?", 1)
 	if err != nil {
@@ -107,7 +111,43 @@ func testSelectAfterUpdate(t *testing.T, db *sqlx.DB) {
 	AssertUser(t, user, user2)
 
 	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
-	assert.Equal(t, 0, stats.Hits)
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+}
+
+func TestSelectAfterFailedUpdate(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectAfterFailedUpdate(t, db)
+		})
+	}
+}
+
+func testSelectAfterFailedUpdate(t *testing.T, db *sqlx.DB) {
+	var user User
+	err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertUser(t, InitialData[0], user)
+
+	// the name is too long for VARCHAR(255) in the strict mode
+	_, err = db.Exec("UPDATE `users` SET `name` = ? WHERE `id` = ?", strings.Repeat("a", 256), 1)
+	assert.Error(t, err)
+
+	// the rejected name is not written through the cache
+	var user2 User
+	err = db.Get(&user2, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertUser(t, InitialData[0], user2)
+
+	// the row is read from the database again
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
 	assert.Equal(t, 2, stats.Misses)
 }
 
@@ -186,6 +226,71 @@ func testSelectIn(t *testing.T, db *sqlx.DB) {
 	assert.Equal(t, 2, stats.Misses)
 }
 
+func TestSelectInOrder(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectInOrder(t, db)
+		})
+	}
+}
+
+func testSelectInOrder(t *testing.T, db *sqlx.DB) {
+	for range 2 {
+		// the rows are returned in the order of the primary key once for each value, as MySQL does
+		var users []User
+		err := db.Select(&users, "SELECT * FROM `users` WHERE `id` IN (?, ?, ?)", 2, 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, users, 2)
+		AssertUser(t, InitialData[0], users[0])
+		AssertUser(t, InitialData[1], users[1])
+	}
+
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
+	assert.Equal(t, 2, stats.Hits)
+	assert.Equal(t, 2, stats.Misses)
+}
+
+func TestSelectInWithEq(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectInWithEq(t, db)
+		})
+	}
+}
+
+func testSelectInWithEq(t *testing.T, db *sqlx.DB) {
+	var users []User
+	err := db.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ? AND `id` IN (?, ?, ?)", 1, 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, users, 2)
+	AssertUser(t, InitialData[0], users[0])
+	AssertUser(t, InitialData[1], users[1])
+
+	// cache hit
+	users = nil
+	err = db.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ? AND `id` IN (?, ?, ?)", 1, 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, users, 2)
+	AssertUser(t, InitialData[0], users[0])
+	AssertUser(t, InitialData[1], users[1])
+
+	// each combination of the values is cached by the generated query
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `group_id` = ? AND `id` = ?")]
+	assert.Equal(t, 3, stats.Hits)
+	assert.Equal(t, 3, stats.Misses)
+}
+
 func TestSelectUsersByGroupID(t *testing.T) {
 	for name, db := range dbs(t) {
 		cache.Reset()
@@ -237,6 +342,234 @@ func testSelectUsersByGroupID(t *testing.T, db *sqlx.DB) {
 	assert.Equal(t, 1, stats.Misses)
 }
 
+func TestSelectUsersByGroupIDAfterInsert(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectUsersByGroupIDAfterInsert(t, db)
+		})
+	}
+}
+
+func testSelectUsersByGroupIDAfterInsert(t *testing.T, db *sqlx.DB) {
+	var users []User
+	err := db.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newUser := User{
+		Name:      "new",
+		Age:       10,
+		GroupID:   sql.Null[int]{Valid: true, V: 1},
+		CreatedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	res, err := db.Exec(
+		"INSERT INTO `users` (`name`, `age`, `group_id`, `created_at`) VALUES (?, ?, ?, ?)",
+		newUser.Name, newUser.Age, newUser.GroupID.V, newUser.CreatedAt,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUser.ID = int(id)
+
+	// cache hit because the new user is added to the cached list
+	err = db.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group1Users := make([]User, 0)
+	for _, user := range InitialData {
+		if user.GroupID.Valid && user.GroupID.V == 1 {
+			group1Users = append(group1Users, user)
+		}
+	}
+	AssertUsers(t, append(group1Users, newUser), users)
+
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `group_id` = ?")]
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+}
+
+func TestSelectPages(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectPages(t, db)
+		})
+	}
+}
+
+func testSelectPages(t *testing.T, db *sqlx.DB) {
+	const query = "SELECT * FROM `users` ORDER BY `id` DESC LIMIT ? OFFSET ?"
+	page := func(limit, offset int) []User {
+		var users []User
+		if err := db.Select(&users, query, limit, offset); err != nil {
+			t.Fatal(err)
+		}
+		return users
+	}
+
+	AssertUsers(t, []User{InitialData[3], InitialData[2]}, page(2, 0))
+	// cache hit because the page is in the cached window
+	AssertUsers(t, []User{InitialData[2]}, page(1, 1))
+	// cache miss because the page is out of the cached window, which is widened
+	AssertUsers(t, []User{InitialData[1], InitialData[0]}, page(2, 2))
+
+	newUser := User{
+		Name:      "new",
+		Age:       10,
+		GroupID:   sql.Null[int]{Valid: true, V: 1},
+		CreatedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	res, err := db.Exec(
+		"INSERT INTO `users` (`name`, `age`, `group_id`, `created_at`) VALUES (?, ?, ?, ?)",
+		newUser.Name, newUser.Age, newUser.GroupID.V, newUser.CreatedAt,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUser.ID = int(id)
+
+	// cache hit because the new user is added to the cached window
+	AssertUsers(t, []User{newUser, InitialData[3]}, page(2, 0))
+
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery(query)]
+	assert.Equal(t, 2, stats.Hits)
+	assert.Equal(t, 2, stats.Misses)
+}
+
+func TestSelectUsersByGroupIDAfterUpdate(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectUsersByGroupIDAfterUpdate(t, db)
+		})
+	}
+}
+
+func testSelectUsersByGroupIDAfterUpdate(t *testing.T, db *sqlx.DB) {
+	var users []User
+	err := db.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group1Users := make([]User, 0)
+	for _, user := range InitialData {
+		if user.GroupID.Valid && user.GroupID.V == 1 {
+			group1Users = append(group1Users, user)
+		}
+	}
+	AssertUsers(t, group1Users, users)
+
+	_, err = db.Exec("UPDATE `users` SET `name` = ? WHERE `id` = ?", "updated", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group1Users[0].Name = "updated"
+
+	// the updated user is reflected in the users of the group through the entity store
+	err = db.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUsers(t, group1Users, users)
+
+	var user User
+	err = db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, group1Users[0], user)
+
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `group_id` = ?")]
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+}
+
+func TestSelectAfterUpdateByLiteral(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectAfterUpdateByLiteral(t, db)
+		})
+	}
+}
+
+func testSelectAfterUpdateByLiteral(t *testing.T, db *sqlx.DB) {
+	var user User
+	err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, InitialData[0], user)
+
+	_, err = db.Exec("UPDATE `users` SET `name` = ?, `age` = 30 WHERE `id` = ?", "updated", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Name = "updated"
+	user.Age = 30
+
+	// cache hit because both the placeholder and the literal are written through the cache
+	var user2 User
+	err = db.Get(&user2, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, user, user2)
+
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+}
+
+func TestSelectAfterUpdateByExpression(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testSelectAfterUpdateByExpression(t, db)
+		})
+	}
+}
+
+func testSelectAfterUpdateByExpression(t *testing.T, db *sqlx.DB) {
+	var user User
+	err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, InitialData[0], user)
+
+	_, err = db.Exec("UPDATE `users` SET `age` = `age` + 1 WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Age++
+
+	// no cache hit because the new age is not known until it is read
+	var user2 User
+	err = db.Get(&user2, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, user, user2)
+
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
+	assert.Equal(t, 0, stats.Hits)
+	assert.Equal(t, 2, stats.Misses)
+}
+
 func TestTransaction(t *testing.T) {
 	for name, db := range dbs(t) {
 		cache.Reset()
@@ -355,11 +688,190 @@ func testSelectTransaction(t *testing.T, db *sqlx.DB) {
 		t.Fatal(err)
 	}
 
+	// the second query is served from the rows kept in the transaction, which are not shared
 	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
-	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 0, stats.Hits)
 	assert.Equal(t, 1, stats.Misses)
 }
 
+func TestUncommittedRowsNotCached(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testUncommittedRowsNotCached(t, db)
+		})
+	}
+}
+
+func testUncommittedRowsNotCached(t *testing.T, db *sqlx.DB) {
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	// inserting a row does not forget the cache of "SELECT * FROM users WHERE id = ?"
+	res, err := tx.Exec("INSERT INTO `users` (`name`, `age`, `created_at`) VALUES (?, ?, ?)", "uncommitted", 20, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	err = tx.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "uncommitted", user.Name)
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the row read inside the transaction must not leak into the cache
+	err = db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", id)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestReadYourWritesInTransaction(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testReadYourWritesInTransaction(t, db)
+		})
+	}
+}
+
+func testReadYourWritesInTransaction(t *testing.T, db *sqlx.DB) {
+	// fill the cache of both users
+	for _, id := range []int{1, 2} {
+		var user User
+		if err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	_, err := tx.Exec("UPDATE `users` SET `name` = ? WHERE `id` = ?", "updated", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the written key is read from the database inside the transaction
+	var user User
+	err = tx.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := InitialData[0]
+	updated.Name = "updated"
+	AssertUser(t, updated, user)
+
+	// the untouched key is still served from the cache
+	err = tx.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, InitialData[1], user)
+
+	// the write is not visible outside the transaction
+	err = db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, InitialData[0], user)
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rollback leaves the cache untouched
+	err = db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertUser(t, InitialData[0], user)
+
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
+	assert.Equal(t, 3, stats.Hits)
+	assert.Equal(t, 2, stats.Misses)
+}
+
+func TestReadListAfterUpdateInTransaction(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testReadListAfterUpdateInTransaction(t, db)
+		})
+	}
+}
+
+func testReadListAfterUpdateInTransaction(t *testing.T, db *sqlx.DB) {
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	var users []User
+	err := tx.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, users, 2)
+
+	_, err = tx.Exec("UPDATE `users` SET `name` = ? WHERE `id` = ?", "updated", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the list read before the update is not served inside the transaction
+	users = nil
+	err = tx.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := InitialData[0]
+	updated.Name = "updated"
+	assert.Len(t, users, 2)
+	AssertUser(t, updated, users[0])
+	AssertUser(t, InitialData[1], users[1])
+}
+
+func TestReadListAfterDeleteInTransaction(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testReadListAfterDeleteInTransaction(t, db)
+		})
+	}
+}
+
+func testReadListAfterDeleteInTransaction(t *testing.T, db *sqlx.DB) {
+	tx := db.MustBegin()
+	defer tx.Rollback()
+
+	var users []User
+	err := tx.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, users, 2)
+
+	_, err = tx.Exec("DELETE FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the deleted row is not served from the list read before the delete
+	users = nil
+	err = tx.Select(&users, "SELECT * FROM `users` WHERE `group_id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, users, 1)
+	AssertUser(t, InitialData[1], users[0])
+}
+
 func TestFuzzyRead(t *testing.T) {
 	for name, db := range dbs(t) {
 		cache.Reset()
@@ -439,3 +951,177 @@ func testFuzzyRead(t *testing.T, db *sqlx.DB) {
 		}
 	}
 }
+
+func TestNamedParameters(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testNamedParameters(t, db)
+		})
+	}
+}
+
+func testNamedParameters(t *testing.T, db *sqlx.DB) {
+	var user User
+	err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = :id", sql.Named("id", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertUser(t, InitialData[0], user)
+
+	_, err = db.NamedExec("UPDATE `users` SET `name` = :name WHERE `id` = :id", map[string]interface{}{"name": "updated", "id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Name = "updated"
+
+	var user2 User
+	err = db.Get(&user2, "SELECT * FROM `users` WHERE `id` = :id", sql.Named("id", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertUser(t, user, user2)
+
+	// named queries share the cache with the positional ones, and are written through it as well
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+	// a name repeated in the query is given once
+	var user3 User
+	err = db.Get(&user3, "SELECT * FROM `users` WHERE `id` = :id AND `id` = :id", sql.Named("id", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertUser(t, InitialData[1], user3)
+}
+
+func TestInlinedLiterals(t *testing.T) {
+	for name, db := range dbs(t) {
+		cache.Reset()
+		t.Run(name, func(t *testing.T) {
+			testInlinedLiterals(t, db)
+		})
+	}
+}
+
+func testInlinedLiterals(t *testing.T, db *sqlx.DB) {
+	var user User
+	err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertUser(t, InitialData[0], user)
+
+	var user2 User
+	err = db.Get(&user2, "SELECT * FROM `users` WHERE `id` = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertUser(t, InitialData[0], user2)
+
+	// the query with the inlined literal is an instance of the planned query
+	stats := cache.ExportCacheStats()[normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")]
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+}
+
+type countingMetrics struct {
+	mu     sync.Mutex
+	hits   map[string]int
+	misses map[string]int
+	purges map[string]int
+}
+
+func newCountingMetrics() *countingMetrics {
+	return &countingMetrics{hits: map[string]int{}, misses: map[string]int{}, purges: map[string]int{}}
+}
+
+func (m *countingMetrics) Hit(query string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hits[query]++
+}
+
+func (m *countingMetrics) Miss(query string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.misses[query]++
+}
+
+func (m *countingMetrics) Purge(query string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purges[query]++
+}
+
+func (m *countingMetrics) Forget(string) {}
+
+func TestConnectorOptions(t *testing.T) {
+	cache.Reset()
+
+	metrics := newCountingMetrics()
+	var logs strings.Builder
+	db := NewConnectorDB(t, cache.WithMetrics(metrics), cache.WithLogger(log.New(&logs, "", 0)))
+
+	query := normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")
+	for range 2 {
+		var user User
+		err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertUser(t, InitialData[0], user)
+	}
+	assert.Equal(t, 1, metrics.hits[query])
+	assert.Equal(t, 1, metrics.misses[query])
+
+	_, err := db.Exec("UPDATE `users` SET `age` = `age` + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, logs.String(), "unknown query:")
+	assert.Equal(t, 1, metrics.purges[query])
+}
+
+func TestConnectorWithPlan(t *testing.T) {
+	cache.Reset()
+
+	schema, err := domains.LoadTableSchema(tableSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &domains.CachePlan{Queries: []*domains.CachePlanQuery{{
+		CachePlanQueryBase: &domains.CachePlanQueryBase{Query: "SELECT * FROM users WHERE id = ?", Type: domains.CachePlanQueryType_SELECT},
+		Select: &domains.CachePlanSelectQuery{
+			Cache:      true,
+			Table:      "users",
+			Targets:    []string{"id", "name", "age", "group_id", "created_at"},
+			Conditions: []domains.CachePlanCondition{{Column: "id", Operator: domains.CachePlanOperator_EQ, Placeholder: domains.CachePlanPlaceholder{Index: 0}}},
+		},
+	}}}
+	metrics := newCountingMetrics()
+	db := NewConnectorDB(t, cache.WithPlan(plan, schema), cache.WithMetrics(metrics))
+
+	for range 2 {
+		var user User
+		err := db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertUser(t, InitialData[0], user)
+	}
+
+	// the caches of the plan are not shared with the generated plan
+	query := normalizer.NormalizeQuery("SELECT * FROM `users` WHERE `id` = ?")
+	assert.Equal(t, 1, metrics.hits[query])
+	assert.Equal(t, 1, metrics.misses[query])
+	// the stats are exported together with the generated plan
+	stats := cache.ExportCacheStats()[query]
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 1, stats.Misses)
+}
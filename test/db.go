@@ -5,8 +5,9 @@ import (
 	_ "embed"
 	"testing"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/traP-jp/isuc/test/cache"
+	"github.com/traP-jp/isuc/test/cache"
 	dbtest "github.com/traP-jp/isuc/testutil/db"
 )
 
@@ -14,36 +15,62 @@ import (
 var tableSchema string
 
 func NewDB(t *testing.T, opts ...dbtest.Option) *sqlx.DB {
-	setup := func(db *sql.DB) error {
-		_, err := db.Exec(tableSchema)
+	db := dbtest.SetUpIsucDB(t, setupDB, opts...)
+
+	return sqlx.NewDb(db, "mysql+cache")
+}
+
+// NewWrappedDB opens the database with cache.Wrap on top of a mysql connector
+func NewWrappedDB(t *testing.T, opts ...dbtest.Option) *sqlx.DB {
+	db := dbtest.SetUpIsucDBWith(t, setupDB, func(cfg *mysql.Config) (*sql.DB, error) {
+		connector, err := mysql.NewConnector(cfg)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return sql.OpenDB(cache.Wrap(connector, cache.WithInterpolation(cfg.InterpolateParams))), nil
+	}, opts...)
 
-		for _, user := range InitialData {
-			if user.GroupID.Valid {
-				_, err := db.Exec(
-					"INSERT INTO `users` (`id`, `name`, `age`, `group_id`, `created_at`) VALUES (?, ?, ?, ?, ?)",
-					user.ID, user.Name, user.Age, user.GroupID.V, user.CreatedAt,
-				)
-				if err != nil {
-					return err
-				}
-			} else {
-				_, err := db.Exec(
-					"INSERT INTO `users` (`id`, `name`, `age`, `created_at`) VALUES (?, ?, ?, ?)",
-					user.ID, user.Name, user.Age, user.CreatedAt,
-				)
-				if err != nil {
-					return err
-				}
-			}
+	return sqlx.NewDb(db, "mysql")
+}
+
+// NewConnectorDB opens the database with cache.NewConnector configured by opts
+func NewConnectorDB(t *testing.T, opts ...cache.Option) *sqlx.DB {
+	db := dbtest.SetUpIsucDBWith(t, setupDB, func(cfg *mysql.Config) (*sql.DB, error) {
+		connector, err := cache.NewConnector(cfg, opts...)
+		if err != nil {
+			return nil, err
 		}
+		return sql.OpenDB(connector), nil
+	})
 
-		return nil
+	return sqlx.NewDb(db, "mysql")
+}
+
+func setupDB(db *sql.DB) error {
+	_, err := db.Exec(tableSchema)
+	if err != nil {
+		return err
 	}
 
-	db := dbtest.SetUpIsucDB(t, setup, opts...)
+	for _, user := range InitialData {
+		if user.GroupID.Valid {
+			_, err := db.Exec(
+				"INSERT INTO `users` (`id`, `name`, `age`, `group_id`, `created_at`) VALUES (?, ?, ?, ?, ?)",
+				user.ID, user.Name, user.Age, user.GroupID.V, user.CreatedAt,
+			)
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err := db.Exec(
+				"INSERT INTO `users` (`id`, `name`, `age`, `created_at`) VALUES (?, ?, ?, ?)",
+				user.ID, user.Name, user.Age, user.CreatedAt,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	return sqlx.NewDb(db, "mysql+cache")
+	return nil
 }
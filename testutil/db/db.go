@@ -55,6 +55,15 @@ type Option func(cfg *mysqldriver.Config) *mysqldriver.Config
 func SetUpIsucDB(t *testing.T, setup func(db *sql.DB) error, opts ...Option) *sql.DB {
 	t.Helper()
 
+	return SetUpIsucDBWith(t, setup, func(cfg *mysqldriver.Config) (*sql.DB, error) {
+		return sql.Open("mysql+cache", cfg.FormatDSN())
+	}, opts...)
+}
+
+// SetUpIsucDBWith is like SetUpIsucDB, but opens the database with open, e.g. to wrap a connector
+func SetUpIsucDBWith(t *testing.T, setup func(db *sql.DB) error, open func(cfg *mysqldriver.Config) (*sql.DB, error), opts ...Option) *sql.DB {
+	t.Helper()
+
 	connection := mysqlContainer.MustConnectionString(context.Background(), "parseTime=true", "multiStatements=true")
 	db, err := sql.Open("mysql", connection)
 	if err != nil {
@@ -81,7 +90,7 @@ func SetUpIsucDB(t *testing.T, setup func(db *sql.DB) error, opts ...Option) *sq
 		opt(cfg)
 	}
 
-	db, err = sql.Open("mysql+cache", cfg.FormatDSN())
+	db, err = open(cfg)
 	if err != nil {
 		t.Fatal(err)
 	}